@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   AUDIT_PREFIX		=  "audit_"
+
+const   AUDIT_SUCCESS		=  "success"
+const   AUDIT_FAILURE		=  "failure"
+
+const   INVOKE_FAILED_EVENT	=  "invokeFailed"
+
+//==============================================================================================================================
+//	Audit Entry - One invoke of the chaincode: who called which function on which entity, when, and how it ended.
+//				  Successful invokes are stored under audit_<TxID>; failed ones only reach the invokeFailed event and
+//				  the peer log. Entity is the first argument of the call unless that is a JSON document or a bulk payload.
+//==============================================================================================================================
+type Audit_Entry struct {
+	TxID			string	`json:"txId"`
+	Timestamp		int64	`json:"timestamp"`
+	Caller			string	`json:"caller"`
+	Role			string	`json:"role"`
+	Function		string	`json:"function"`
+	Entity			string	`json:"entity,omitempty"`
+	Outcome			string	`json:"outcome"`
+	Error			string	`json:"error,omitempty"`
+}
+
+//==============================================================================================================================
+//	Audit Filter - Narrows the audit log. Empty fields match everything; From and To are Unix times, To of 0 has no
+//				   upper bound.
+//==============================================================================================================================
+type Audit_Filter struct {
+	Participant		string	`json:"participant"`
+	Role			string	`json:"role"`
+	Function		string	`json:"function"`
+	Entity			string	`json:"entity"`
+	Outcome			string	`json:"outcome"`
+	From			int64	`json:"from"`
+	To				int64	`json:"to"`
+}
+
+//==============================================================================================================================
+//	Buffered Stub - Holds back the writes and event of an invoke so they can be dropped if it fails, leaving only the
+//					audit entry of a successful invoke to be written after them. A nil value in writes is a deleted key.
+//==============================================================================================================================
+type buffered_stub struct {
+	shim.ChaincodeStubInterface
+	writes			map[string][]byte
+	event_name		string
+	event			[]byte
+}
+
+func new_buffered_stub(stub shim.ChaincodeStubInterface) *buffered_stub {
+
+	return &buffered_stub{ChaincodeStubInterface: stub, writes: make(map[string][]byte)}
+}
+
+func (b *buffered_stub) GetState(key string) ([]byte, error) {
+
+	if value, ok := b.writes[key]; ok { return value, nil }
+	return b.ChaincodeStubInterface.GetState(key)
+}
+
+func (b *buffered_stub) PutState(key string, value []byte) error {
+
+	if value == nil { return errors.New("Cannot store a nil value at " + key) }
+	b.writes[key] = value
+	return nil
+}
+
+func (b *buffered_stub) DelState(key string) error {
+
+	b.writes[key] = nil
+	return nil
+}
+
+//==============================================================================================================================
+//	 RangeQueryState - Ranges over the state as this invoke has left it: keys it wrote are included and keys it deleted
+//					   left out. The keys come back in order.
+//==============================================================================================================================
+func (b *buffered_stub) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+
+	iter, err := b.ChaincodeStubInterface.RangeQueryState(startKey, endKey)
+	if err != nil { return nil, err }
+	defer iter.Close()
+
+	values := map[string][]byte{}
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil { return nil, err }
+		values[key] = value
+	}
+
+	for key, value := range b.writes {
+		if key < startKey || key > endKey { continue }
+		if value == nil { delete(values, key) } else { values[key] = value }
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values { keys = append(keys, key) }
+	sort.Strings(keys)
+
+	return &buffered_range{keys: keys, values: values}, nil
+}
+
+//==============================================================================================================================
+//	Buffered Range - The result of a range query on a buffered stub, already read in full.
+//==============================================================================================================================
+type buffered_range struct {
+	keys			[]string
+	values			map[string][]byte
+}
+
+func (r *buffered_range) HasNext() bool {
+
+	return len(r.keys) > 0
+}
+
+func (r *buffered_range) Next() (string, []byte, error) {
+
+	if len(r.keys) == 0 { return "", nil, errors.New("Range query has no more keys") }
+	key := r.keys[0]
+	r.keys = r.keys[1:]
+	return key, r.values[key], nil
+}
+
+func (r *buffered_range) Close() error {
+
+	r.keys = nil
+	return nil
+}
+
+func (b *buffered_stub) SetEvent(name string, payload []byte) error {
+
+	b.event_name, b.event = name, payload
+	return nil
+}
+
+//==============================================================================================================================
+//	 commit - Writes what the invoke held back, in key order.
+//==============================================================================================================================
+func (b *buffered_stub) commit() error {
+
+	keys := make([]string, 0, len(b.writes))
+	for key := range b.writes { keys = append(keys, key) }
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		if b.writes[key] == nil { err = b.ChaincodeStubInterface.DelState(key) } else { err = b.ChaincodeStubInterface.PutState(key, b.writes[key]) }
+		if err != nil { return errors.New("Error storing " + key) }
+	}
+
+	if b.event_name == "" { return nil }
+	return b.ChaincodeStubInterface.SetEvent(b.event_name, b.event)
+}
+
+//==============================================================================================================================
+//	 audit_entity - Works out which entity an invoke acted on from its arguments.
+//==============================================================================================================================
+func audit_entity(function string, args []string) string {
+
+	if len(args) == 0 || strings.HasPrefix(function, "bulk_") || function == "import_ledger" { return "" }
+
+	entity := strings.TrimSpace(args[0])
+	if strings.HasPrefix(entity, "{") || strings.HasPrefix(entity, "[") { return "" }
+	return entity
+}
+
+//==============================================================================================================================
+//	 matches - true if the entry passes every field of the filter.
+//==============================================================================================================================
+func (f Audit_Filter) matches(v Audit_Entry) bool {
+
+	if f.Participant != "" && v.Caller != f.Participant { return false }
+	if f.Role != "" && v.Role != f.Role { return false }
+	if f.Function != "" && v.Function != f.Function { return false }
+	if f.Entity != "" && v.Entity != f.Entity { return false }
+	if f.Outcome != "" && v.Outcome != f.Outcome { return false }
+	if v.Timestamp < f.From { return false }
+	if f.To != 0 && v.Timestamp > f.To { return false }
+	return true
+}
+
+//==============================================================================================================================
+//	 audit_entry - Describes an invoke and its outcome. A caller whose details cannot be read is recorded without them.
+//==============================================================================================================================
+func (t *SimpleChaincode) audit_entry(stub shim.ChaincodeStubInterface, function string, args []string, outcome error) Audit_Entry {
+
+	caller, role, _ := t.get_caller_data(stub)
+
+	v := Audit_Entry{TxID: stub.GetTxID(), Timestamp: t.get_tx_time(stub), Caller: caller, Role: role, Function: function, Entity: audit_entity(function, args), Outcome: AUDIT_SUCCESS}
+	if outcome != nil { v.Outcome = AUDIT_FAILURE; v.Error = outcome.Error() }
+	return v
+}
+
+//==============================================================================================================================
+//	 record_audit - Adds an invoke to the audit log.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_audit(stub shim.ChaincodeStubInterface, v Audit_Entry) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return errors.New("Error converting audit record") }
+
+	err = stub.PutState(AUDIT_PREFIX + v.TxID, bytes)
+	if err != nil { return errors.New("Error storing audit record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 report_failure - Logs the audit entry of a failed invoke and sends it as the invokeFailed event. A peer discards
+//					  every write of a transaction that returns an error, so a failure never reaches the audit log.
+//==============================================================================================================================
+func (t *SimpleChaincode) report_failure(stub shim.ChaincodeStubInterface, v Audit_Entry) {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("INVOKE: %s failed: %s", v.Function, v.Error); return }
+
+	fmt.Printf("INVOKE: Failed: %s", bytes)
+	err = stub.SetEvent(INVOKE_FAILED_EVENT, bytes)
+	if err != nil { fmt.Printf("INVOKE: Error sending %s event: %s", INVOKE_FAILED_EVENT, err) }
+}
+
+//=================================================================================================================================
+//	 get_audit_log - Returns the invokes that match the filter, oldest first. filter_json may be empty for every
+//					 invoke.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_audit_log(stub shim.ChaincodeStubInterface, filter_json string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	var f Audit_Filter
+	if filter_json != "" {
+		err = json.Unmarshal([]byte(filter_json), &f)
+		if err != nil { return nil, errors.New("Invalid audit filter JSON") }
+	}
+	if f.Outcome != "" && f.Outcome != AUDIT_SUCCESS && f.Outcome != AUDIT_FAILURE { return nil, errors.New("Unknown outcome " + f.Outcome + ", expected success or failure") }
+
+	ids, err := t.retrieve_range_ids(stub, AUDIT_PREFIX)
+	if err != nil { return nil, err }
+
+	result := []Audit_Entry{}
+	for _, id := range ids {
+		bytes, err := stub.GetState(AUDIT_PREFIX + id)
+		if err != nil || bytes == nil { return nil, errors.New("Failed to retrieve Audit Entry " + id) }
+
+		var v Audit_Entry
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt Audit Entry " + id) }
+		if f.matches(v) { result = append(result, v) }
+	}
+
+	sort.SliceStable(result, func(a, b int) bool { return result[a].Timestamp < result[b].Timestamp })	// Keys are TxIDs, which are not in time order
+	return json.Marshal(result)
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Bulk import limits - The maximum number of rows a single bulk payload may carry.
+//==============================================================================================================================
+const   MAX_BULK_RECORDS	=  500
+
+const   BULK_FORMAT_JSON	=  "json"
+const   BULK_FORMAT_CSV		=  "csv"
+
+//==============================================================================================================================
+//	Bulk Report - Returned from every bulk create. Rows holds one entry per input row, in input order, so the caller
+//				  can match failures back to the records they sent.
+//==============================================================================================================================
+type Bulk_Report struct {
+	Total		int					`json:"total"`
+	Created		int					`json:"created"`
+	Failed		int					`json:"failed"`
+	Rows		[]Bulk_Row_Result	`json:"rows"`
+}
+
+type Bulk_Row_Result struct {
+	Row			int		`json:"row"`
+	ID			string	`json:"id"`
+	Success		bool	`json:"success"`
+	Error		string	`json:"error,omitempty"`
+}
+
+//==============================================================================================================================
+//	Bulk PoS row - The percentage is a pointer so a row that leaves it out gets the program's default earn rate rather
+//				   than 0%.
+//==============================================================================================================================
+type bulk_pos_row struct {
+	PoSID				string	`json:"posId"`
+	PoSName				string	`json:"posName"`
+	LoyaltyPercentage	*int	`json:"percentage"`
+	PartnerID			string	`json:"partnerId"`
+}
+
+//==============================================================================================================================
+//	 check_partner_caller - Only partners and the regulator may create PoS and items or onboard records in bulk.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_partner_caller(stub shim.ChaincodeStubInterface) error {
+
+	_, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+
+	if caller_affiliation != AUTHORITY && caller_affiliation != AIRLINES && caller_affiliation != HOTEL && caller_affiliation != VENDOR {
+		return errors.New("Permission Denied. Operation is not allowed for role " + caller_affiliation)
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 parse_bulk_rows - Splits a JSON array or CSV payload into one JSON object per row. CSV payloads must start with a
+//					   header row naming the JSON fields; columns listed in int_fields are converted to numbers so the
+//					   row unmarshals the same way a JSON row would. A column that isn't a number is left as a string
+//					   so the row fails on its own instead of failing the whole payload.
+//==============================================================================================================================
+func parse_bulk_rows(format string, payload string, int_fields []string) ([]json.RawMessage, error) {
+
+	var rows []json.RawMessage
+
+	if format == BULK_FORMAT_JSON {
+		err := json.Unmarshal([]byte(payload), &rows)
+		if err != nil { return nil, errors.New("Invalid JSON payload, expected an array of objects") }
+	} else if format == BULK_FORMAT_CSV {
+		reader := csv.NewReader(strings.NewReader(payload))
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil { return nil, errors.New("Invalid CSV payload: " + err.Error()) }
+		if len(records) == 0 { return nil, errors.New("Invalid CSV payload, header row missing") }
+
+		header := records[0]
+		for _, record := range records[1:] {
+			fields := make(map[string]interface{})
+			for c, name := range header {
+				if c >= len(record) { break }
+				fields[name] = record[c]
+				for _, f := range int_fields {
+					if f != name { continue }
+					n, err := strconv.Atoi(record[c])
+					if err == nil { fields[name] = n }
+				}
+			}
+			bytes, err := json.Marshal(fields)
+			if err != nil { return nil, errors.New("Invalid CSV payload") }
+			rows = append(rows, bytes)
+		}
+	} else {
+		return nil, errors.New("Unknown bulk format " + format + ", expected json or csv")
+	}
+
+	if len(rows) == 0 { return nil, errors.New("Bulk payload contains no records") }
+	if len(rows) > MAX_BULK_RECORDS { return nil, errors.New(fmt.Sprintf("Bulk payload contains %d records, the limit is %d", len(rows), MAX_BULK_RECORDS)) }
+
+	return rows, nil
+}
+
+//==============================================================================================================================
+//	 retrieve_ids - Returns the ids held in the ID holder stored at key. A holder that was never written is empty.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_ids(stub shim.ChaincodeStubInterface, key string, field string) ([]string, error) {
+
+	holder := make(map[string][]string)
+
+	bytes, err := stub.GetState(key)
+	if err != nil { return nil, errors.New("Unable to get " + key) }
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &holder)
+		if err != nil { return nil, errors.New("Corrupt " + key + " record") }
+	}
+	return holder[field], nil
+}
+
+//==============================================================================================================================
+//	 retrieve_range_ids - Returns the ids of the records stored under prefix, in key order, for records that are listed
+//						  by a range query rather than an ID holder.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_range_ids(stub shim.ChaincodeStubInterface, prefix string) ([]string, error) {
+
+	iter, err := stub.RangeQueryState(prefix, prefix + "~")
+	if err != nil { return nil, errors.New("Unable to list " + prefix) }
+	defer iter.Close()
+
+	var ids []string
+	for iter.HasNext() {
+		key, _, err := iter.Next()
+		if err != nil { return nil, errors.New("Unable to list " + prefix) }
+		if strings.HasPrefix(key, prefix) { ids = append(ids, strings.TrimPrefix(key, prefix)) }
+	}
+	return ids, nil
+}
+
+//==============================================================================================================================
+//	 append_ids - Adds the ids passed to the ID holder stored at key, creating the holder if Init never wrote it.
+//				  The holders all store a single JSON array, so one read and one write covers the whole batch.
+//==============================================================================================================================
+func (t *SimpleChaincode) append_ids(stub shim.ChaincodeStubInterface, key string, field string, ids []string) error {
+
+	existing, err := t.retrieve_ids(stub, key, field)
+	if err != nil { return err }
+
+	holder := map[string][]string{field: append(existing, ids...)}
+
+	bytes, err := json.Marshal(holder)
+	if err != nil { return errors.New("Error creating " + key + " record") }
+
+	err = stub.PutState(key, bytes)
+	if err != nil { return errors.New("Unable to put the state") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 remove_ids - Takes the ids passed out of the ID holder stored at key.
+//==============================================================================================================================
+func (t *SimpleChaincode) remove_ids(stub shim.ChaincodeStubInterface, key string, field string, ids []string) error {
+
+	existing, err := t.retrieve_ids(stub, key, field)
+	if err != nil { return err }
+
+	removed := make(map[string]bool)
+	for _, id := range ids { removed[id] = true }
+
+	kept := []string{}
+	for _, id := range existing {
+		if !removed[id] { kept = append(kept, id) }
+	}
+
+	bytes, err := json.Marshal(map[string][]string{field: kept})
+	if err != nil { return errors.New("Error creating " + key + " record") }
+
+	err = stub.PutState(key, bytes)
+	if err != nil { return errors.New("Unable to put the state") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 add_row_result - Records the outcome of a row on the report.
+//==============================================================================================================================
+func (r *Bulk_Report) add_row_result(row int, id string, err error) {
+
+	result := Bulk_Row_Result{Row: row, ID: id, Success: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+		r.Failed++
+	} else {
+		r.Created++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+//=================================================================================================================================
+//	 bulk_create_customers - Validates every row of the payload and creates the valid customers in this transaction.
+//							 The customerIDs index is rewritten once for the batch rather than once per customer.
+//							 Opening balances are only accepted from the regulator, and the cashback is awarded by
+//							 partnerID out of its float as if the customers had earned it there.
+//=================================================================================================================================
+func (t *SimpleChaincode) bulk_create_customers(stub shim.ChaincodeStubInterface, format string, payload string, partnerID string) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+	if !config.feature_enabled(FEATURE_BULK_IMPORT) { return nil, errors.New("Bulk import is disabled") }
+
+	funded := false
+	if partnerID != "" {
+		err = t.check_authority(stub)
+		if err != nil { return nil, errors.New("Permission Denied. Only the regulator may import opening balances") }
+		_, err = t.retrieve_partner(stub, partnerID)
+		if err != nil { return nil, errors.New("Unknown partnerId " + partnerID) }
+		funded = true
+	}
+
+	rows, err := parse_bulk_rows(format, payload, []string{"cashback"})
+	if err != nil { fmt.Printf("BULK_CREATE_CUSTOMERS: %s", err); return nil, err }
+
+	report := Bulk_Report{Total: len(rows)}
+	seen := make(map[string]bool)
+	var valid []Customer
+
+	for r, row := range rows {
+		var v Customer
+		err = json.Unmarshal(row, &v)
+		if err == nil { err = t.validate_bulk_customer(stub, config, &v, seen, funded) }
+		if err == nil { valid = append(valid, v) }
+		report.add_row_result(r+1, v.CustomerID, err)
+	}
+
+	var ids []string
+	opening := 0
+	p := PoS{PartnerID: partnerID}
+	for _, v := range valid {
+		err = t.draw_float(stub, p, v.CustomerID, v.Cashback)						// Opening balances are paid for like any earn
+		if err != nil { fmt.Printf("BULK_CREATE_CUSTOMERS: %s", err); return nil, err }
+		_, err = t.save_changes(stub, v)
+		if err != nil { fmt.Printf("BULK_CREATE_CUSTOMERS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		ids = append(ids, v.CustomerID)
+		opening = opening + v.Cashback
+	}
+
+	if opening > 0 {
+		err = t.settle_earn(stub, p, opening)
+		if err == nil { err = t.record_liability(stub, partnerID, Liability_Movement{Issued: opening}) }
+		if err != nil { fmt.Printf("BULK_CREATE_CUSTOMERS: %s", err); return nil, err }
+	}
+
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "customerIDs", "customers", ids)
+		if err != nil { return nil, err }
+	}
+
+	return json.Marshal(report)
+}
+
+//=================================================================================================================================
+//	 validate_bulk_customer - funded is set when the import may carry opening balances.
+//=================================================================================================================================
+func (t *SimpleChaincode) validate_bulk_customer(stub shim.ChaincodeStubInterface, config Program_Config, v *Customer, seen map[string]bool, funded bool) error {
+
+	var err error
+
+	if v.CustomerID == "" {
+		v.CustomerID, err = t.generate_id(stub, config, ENTITY_CUSTOMER, seen)
+		if err != nil { return err }
+	}
+
+	err = config.check_id(ENTITY_CUSTOMER, v.CustomerID, "")
+	if err != nil { return err }
+	if seen[v.CustomerID] { return errors.New("Duplicate customerID in payload") }
+	if v.Cashback < 0 { return errors.New("Cashback cannot be negative") }
+	if (v.Cashback > 0 || len(v.Balances) > 0) && !funded { return errors.New("Opening balances need the regulator and a partnerId to award them") }
+
+	record, err := stub.GetState(v.CustomerID)
+	if err != nil { return errors.New("Unable to check customerID") }
+	if record != nil { return errors.New("Customer already exists") }
+
+	if v.Name == "" { v.Name = v.CustomerID }
+	if v.Address == "" { v.Address = "UNDEFINED" }
+	if v.Email == "" { v.Email = "UNDEFINED" }
+	if v.Phone == "" { v.Phone = "UNDEFINED" }
+	v.Status = true
+	v.ReferredBy = ""															// Referrals are only recorded by create_customer
+	v.PoolID = ""
+
+	for programID, points := range v.Balances {
+		if points < 0 { return errors.New("Balance in program " + programID + " cannot be negative") }
+		_, err = t.retrieve_loyalty_program(stub, programID)
+		if err != nil || programID == CASHBACK_PROGRAM { return errors.New("Unknown program " + programID + " in balances") }
+	}
+
+	seen[v.CustomerID] = true
+	return nil
+}
+
+//=================================================================================================================================
+//	 bulk_create_pos - Validates every row of the payload and creates the valid PoS records in this transaction. A
+//					   partner may only create PoS it operates.
+//=================================================================================================================================
+func (t *SimpleChaincode) bulk_create_pos(stub shim.ChaincodeStubInterface, format string, payload string) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+	if !config.feature_enabled(FEATURE_BULK_IMPORT) { return nil, errors.New("Bulk import is disabled") }
+
+	rows, err := parse_bulk_rows(format, payload, []string{"percentage"})
+	if err != nil { fmt.Printf("BULK_CREATE_POS: %s", err); return nil, err }
+
+	report := Bulk_Report{Total: len(rows)}
+	seen := make(map[string]bool)
+	var valid []PoS
+
+	for r, row := range rows {
+		var in bulk_pos_row
+		var p PoS
+		err = json.Unmarshal(row, &in)
+		if err == nil { err = t.check_acts_for(stub, in.PartnerID) }
+		if err == nil { p, err = t.validate_bulk_pos(stub, config, in, seen, nil) }
+		if err == nil { valid = append(valid, p) }
+		if p.PoSID == "" { p.PoSID = in.PoSID }
+		report.add_row_result(r+1, p.PoSID, err)
+	}
+
+	var ids []string
+	for _, p := range valid {
+		_, err = t.save_changes_pos(stub, p)
+		if err != nil { fmt.Printf("BULK_CREATE_POS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		ids = append(ids, p.PoSID)
+	}
+
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "posIDs", "posIDs", ids)
+		if err != nil { return nil, err }
+	}
+
+	return json.Marshal(report)
+}
+
+//=================================================================================================================================
+//	 validate_bulk_pos - pending_partners holds partners that are being created alongside the PoS and so are not yet
+//						 on the ledger.
+//=================================================================================================================================
+func (t *SimpleChaincode) validate_bulk_pos(stub shim.ChaincodeStubInterface, config Program_Config, in bulk_pos_row, seen map[string]bool, pending_partners map[string]bool) (PoS, error) {
+
+	var p PoS
+	var err error
+
+	if in.PoSID == "" {
+		in.PoSID, err = t.generate_id(stub, config, ENTITY_POS, seen)
+		if err != nil { return p, err }
+	}
+
+	err = config.check_id(ENTITY_POS, in.PoSID, in.PartnerID)
+	if err != nil { return p, err }
+	if seen[in.PoSID] { return p, errors.New("Duplicate posID in payload") }
+
+	record, err := stub.GetState(in.PoSID)
+	if err != nil { return p, errors.New("Unable to check posID") }
+	if record != nil { return p, errors.New("POS already exists") }
+
+	p.PoSID = in.PoSID
+	p.PoSName = in.PoSName
+	p.PartnerID = in.PartnerID
+	p.Status = true
+	p.LoyaltyPercentage = config.DefaultEarnRate
+
+	if p.PoSName == "" { p.PoSName = in.PoSID }
+	if in.LoyaltyPercentage != nil { p.LoyaltyPercentage = *in.LoyaltyPercentage }
+	if p.LoyaltyPercentage < 0 || p.LoyaltyPercentage > 100 { return p, errors.New("Percentage must be between 0 and 100") }
+
+	if p.PartnerID != "" && !pending_partners[p.PartnerID] {
+		_, err = t.retrieve_partner(stub, p.PartnerID)
+		if err != nil { return p, errors.New("Unknown partnerId " + p.PartnerID) }
+	}
+
+	seen[in.PoSID] = true
+	return p, nil
+}
+
+//=================================================================================================================================
+//	 bulk_create_items - Validates every row of the payload and creates the valid items in this transaction. Each item
+//						 must reference a PoS that already exists on the ledger and that the caller's partner operates.
+//=================================================================================================================================
+func (t *SimpleChaincode) bulk_create_items(stub shim.ChaincodeStubInterface, format string, payload string) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+	if !config.feature_enabled(FEATURE_BULK_IMPORT) { return nil, errors.New("Bulk import is disabled") }
+
+	rows, err := parse_bulk_rows(format, payload, []string{"price"})
+	if err != nil { fmt.Printf("BULK_CREATE_ITEMS: %s", err); return nil, err }
+
+	report := Bulk_Report{Total: len(rows)}
+	seen := make(map[string]bool)
+	var valid []Item
+
+	for r, row := range rows {
+		var i Item
+		err = json.Unmarshal(row, &i)
+		if err == nil { err = t.check_pos_partner(stub, i.PoSID) }
+		if err == nil { err = t.validate_bulk_item(stub, config, &i, seen, nil) }
+		if err == nil { valid = append(valid, i) }
+		report.add_row_result(r+1, i.ItemID, err)
+	}
+
+	var ids []string
+	for _, i := range valid {
+		_, err = t.save_changes_item(stub, i)
+		if err != nil { fmt.Printf("BULK_CREATE_ITEMS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		ids = append(ids, i.ItemID)
+	}
+
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "itemIDs", "itemIDIDs", ids)
+		if err != nil { return nil, err }
+	}
+
+	return json.Marshal(report)
+}
+
+//==============================================================================================================================
+//	 check_pos_partner - Returns an error unless the caller may manage the records of the partner that operates posID.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_pos_partner(stub shim.ChaincodeStubInterface, posID string) error {
+
+	p, err := t.retrieve_pos(stub, posID)
+	if err != nil { return errors.New("Unknown posId " + posID) }
+	return t.check_acts_for(stub, p.PartnerID)
+}
+
+//=================================================================================================================================
+//	 validate_bulk_item - pending_pos maps PoS that are being created alongside the items, and so are not yet on the
+//						  ledger, to the partner that operates them.
+//=================================================================================================================================
+func (t *SimpleChaincode) validate_bulk_item(stub shim.ChaincodeStubInterface, config Program_Config, i *Item, seen map[string]bool, pending_pos map[string]string) error {
+
+	partnerID, ok := pending_pos[i.PoSID]
+	if !ok {
+		p, err := t.retrieve_pos(stub, i.PoSID)
+		if err != nil { return errors.New("Unknown posId " + i.PoSID) }
+		partnerID = p.PartnerID
+	}
+
+	var err error
+
+	if i.ItemID == "" {
+		i.ItemID, err = t.generate_id(stub, config, ENTITY_ITEM, seen)
+		if err != nil { return err }
+	}
+
+	err = config.check_id(ENTITY_ITEM, i.ItemID, partnerID)
+	if err != nil { return err }
+	if seen[i.ItemID] { return errors.New("Duplicate itemID in payload") }
+	if i.Price <= 0 { return errors.New("Price must be greater than 0") }
+
+	if i.CategoryID != "" {
+		_, err = t.retrieve_category(stub, i.CategoryID)
+		if err != nil { return errors.New("Unknown categoryId " + i.CategoryID) }
+	}
+
+	record, err := stub.GetState(i.ItemID)
+	if err != nil { return errors.New("Unable to check itemID") }
+	if record != nil { return errors.New("Item already exists") }
+
+	if i.ItemName == "" { i.ItemName = i.ItemID }
+
+	seen[i.ItemID] = true
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Campaign - A time boxed promotion. A purchase is targeted when it matches every target list that is not empty; a
+//			   campaign with no targets applies to every purchase. Points earned under the campaign are the PoS
+//			   points times MultiplierPercent / 100 plus FlatBonus, so 200 doubles the points and 100 leaves them as
+//			   they are. Start and End are seconds since the epoch, compared with the transaction timestamp. A
+//			   campaign with a PartnerID is that partner's own and only applies at its PoS; one without is run by
+//			   the program and applies everywhere.
+//==============================================================================================================================
+type Campaign struct {
+	CampaignID			string		`json:"campaignId"`
+	Name				string		`json:"name"`
+	PartnerID			string		`json:"partnerId,omitempty"`
+	Start				int64		`json:"start"`
+	End					int64		`json:"end"`
+	PoSIDs				[]string	`json:"posIds"`
+	ItemIDs				[]string	`json:"itemIds"`
+	PartnerTypes		[]string	`json:"partnerTypes"`
+	Tiers				[]string	`json:"tiers"`
+	MultiplierPercent	int			`json:"multiplierPercent"`
+	FlatBonus			int			`json:"flatBonus"`
+	Status				bool		`json:"status"`
+	CreatedBy			string		`json:"createdBy"`
+}
+
+//==============================================================================================================================
+//	CampaignID Holder - Defines the structure that holds all the campaignIDs for Campaigns that have been created.
+//==============================================================================================================================
+type CampaignID_Holder struct {
+	CampaignIDs		[]string	`json:"campaignIDs"`
+}
+
+const   CAMPAIGN_PREFIX		=  "campaign_"
+
+//==============================================================================================================================
+//	 retrieve_campaign - Gets the campaign stored for campaignID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_campaign(stub shim.ChaincodeStubInterface, campaignID string) (Campaign, error) {
+
+	var v Campaign
+
+	bytes, err := stub.GetState(CAMPAIGN_PREFIX + campaignID)
+	if err != nil { return v, errors.New("RETRIEVE_CAMPAIGN: Error retrieving Campaign with campaignID = " + campaignID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_CAMPAIGN: No Campaign with campaignID = " + campaignID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_CAMPAIGN: Corrupt Campaign record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_campaign - Writes the campaign to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_campaign(stub shim.ChaincodeStubInterface, v Campaign) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting campaign record: %s", err); return errors.New("Error converting campaign record") }
+
+	err = stub.PutState(CAMPAIGN_PREFIX + v.CampaignID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing campaign record: %s", err); return errors.New("Error storing campaign record") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 create_campaign - Creates a campaign from its JSON definition. A partner creates campaigns for its own PoS and
+//					   items; program wide campaigns are created by the airline or the regulator.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_campaign(stub shim.ChaincodeStubInterface, campaign_json string) ([]byte, error) {
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	v := Campaign{MultiplierPercent: 100}
+	err = json.Unmarshal([]byte(campaign_json), &v)
+	if err != nil { return nil, errors.New("Invalid campaign JSON") }
+
+	err = t.check_campaign_owner(stub, v)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if v.CampaignID == "" { return nil, errors.New("Campaign must have a campaignId") }
+	if v.End <= v.Start { return nil, errors.New("Campaign must end after it starts") }
+	if v.MultiplierPercent < 0 { return nil, errors.New("multiplierPercent cannot be negative") }
+	if v.FlatBonus < 0 { return nil, errors.New("flatBonus cannot be negative") }
+	if v.MultiplierPercent <= 100 && v.FlatBonus == 0 { return nil, errors.New("Campaign must have a multiplierPercent above 100 or a flatBonus") }
+	if v.MultiplierPercent > config.MaxCampaignMultiplier { return nil, errors.New(fmt.Sprintf("multiplierPercent cannot be above %d", config.MaxCampaignMultiplier)) }
+	if v.FlatBonus > config.MaxCampaignBonus { return nil, errors.New(fmt.Sprintf("flatBonus cannot be above %d", config.MaxCampaignBonus)) }
+
+	for _, partner_type := range v.PartnerTypes {
+		if partner_type != AIRLINES && partner_type != HOTEL && partner_type != VENDOR { return nil, errors.New("Unknown partner type " + partner_type) }
+	}
+
+	if v.PartnerID != "" {
+		for _, posID := range v.PoSIDs {
+			p, err := t.retrieve_pos(stub, posID)
+			if err != nil || p.PartnerID != v.PartnerID { return nil, errors.New("PoS " + posID + " does not belong to partner " + v.PartnerID) }
+		}
+		for _, itemID := range v.ItemIDs {
+			i, err := t.retrieve_item(stub, itemID)
+			if err != nil { return nil, errors.New("Unknown item " + itemID) }
+			p, err := t.retrieve_pos(stub, i.PoSID)
+			if err != nil || p.PartnerID != v.PartnerID { return nil, errors.New("Item " + itemID + " does not belong to partner " + v.PartnerID) }
+		}
+	}
+
+	_, err = t.retrieve_campaign(stub, v.CampaignID)
+	if err == nil { return nil, errors.New("Campaign already exists") }
+
+	v.Status = true
+	v.CreatedBy = caller
+
+	err = t.save_changes_campaign(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "campaignIDs", "campaignIDs", []string{v.CampaignID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 end_campaign - Stops a campaign before its end date.
+//=================================================================================================================================
+func (t *SimpleChaincode) end_campaign(stub shim.ChaincodeStubInterface, campaignID string) ([]byte, error) {
+
+	v, err := t.retrieve_campaign(stub, campaignID)
+	if err != nil { return nil, err }
+
+	err = t.check_campaign_owner(stub, v)
+	if err != nil { return nil, err }
+
+	v.Status = false
+
+	err = t.save_changes_campaign(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 get_campaigns
+//=================================================================================================================================
+func (t *SimpleChaincode) get_campaigns(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	ids, err := t.retrieve_ids(stub, "campaignIDs", "campaignIDs")
+	if err != nil { return nil, err }
+
+	campaigns := []Campaign{}
+	for _, id := range ids {
+		v, err := t.retrieve_campaign(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Campaign") }
+		campaigns = append(campaigns, v)
+	}
+	return json.Marshal(campaigns)
+}
+
+//==============================================================================================================================
+//	 check_campaign_owner - A partner's campaign is managed by that partner, a program wide one by the airline or the
+//							regulator.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_campaign_owner(stub shim.ChaincodeStubInterface, v Campaign) error {
+
+	if v.PartnerID == "" { return t.check_program_caller(stub) }
+
+	err := t.check_acts_for(stub, v.PartnerID)
+	if err != nil { return err }
+
+	_, err = t.retrieve_partner(stub, v.PartnerID)
+	if err != nil { return errors.New("Unknown partner " + v.PartnerID) }
+	return nil
+}
+
+//==============================================================================================================================
+//	 matches - true if the campaign is running at now and targets the purchase.
+//==============================================================================================================================
+func (c Campaign) matches(now int64, v Customer, i Item, p PoS, partner_type string) bool {
+
+	if !c.Status || now < c.Start || now > c.End { return false }
+	if c.PartnerID != "" && c.PartnerID != p.PartnerID { return false }
+
+	return in_list(c.PoSIDs, i.PoSID) && in_list(c.ItemIDs, i.ItemID) && in_list(c.PartnerTypes, partner_type) && in_list(c.Tiers, v.Tier)
+}
+
+//==============================================================================================================================
+//	 in_list - An empty list matches everything.
+//==============================================================================================================================
+func in_list(list []string, value string) bool {
+
+	if len(list) == 0 { return true }
+	for _, entry := range list {
+		if entry == value { return true }
+	}
+	return false
+}
+
+//==============================================================================================================================
+//	 apply_campaigns - Returns the points for the purchase under the campaign that gives the most, and that campaign's
+//					   ID. When no campaign beats the PoS rate the points are returned unchanged with an empty ID.
+//					   Campaigns are checked in creation order and the first of equal campaigns wins. now is the time
+//					   of the purchase.
+//==============================================================================================================================
+func (t *SimpleChaincode) apply_campaigns(stub shim.ChaincodeStubInterface, v Customer, i Item, p PoS, points int, now int64) (int, string, error) {
+
+	ids, err := t.retrieve_ids(stub, "campaignIDs", "campaignIDs")
+	if err != nil { return points, "", err }
+	if len(ids) == 0 { return points, "", nil }
+
+	partner_type := ""
+	if p.PartnerID != "" {
+		partner, err := t.retrieve_partner(stub, p.PartnerID)
+		if err == nil { partner_type = partner.Type }
+	}
+
+	best := points
+	best_id := ""
+
+	for _, id := range ids {
+		c, err := t.retrieve_campaign(stub, id)
+		if err != nil { return points, "", err }
+		if !c.matches(now, v, i, p, partner_type) { continue }
+
+		campaign_points := points * c.MultiplierPercent / 100 + c.FlatBonus
+		if campaign_points > best {
+			best = campaign_points
+			best_id = c.CampaignID
+		}
+	}
+	return best, best_id, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Category - A node in the item category tree. EarnRate overrides the PoS loyalty percentage for items in the
+//			   category, and Redeemable set to false stops them being bought with points. A category that leaves a
+//			   rule out inherits it from the nearest parent that sets it.
+//==============================================================================================================================
+type Category struct {
+	CategoryID		string	`json:"categoryId"`
+	Name			string	`json:"name"`
+	ParentID		string	`json:"parentId,omitempty"`
+	EarnRate		*int	`json:"earnRate,omitempty"`
+	Redeemable		*bool	`json:"redeemable,omitempty"`
+}
+
+//==============================================================================================================================
+//	CategoryID Holder - Defines the structure that holds all the categoryIDs for Categories that have been created.
+//==============================================================================================================================
+type CategoryID_Holder struct {
+	CategoryIDs		[]string	`json:"categoryIDs"`
+}
+
+//==============================================================================================================================
+//	Category Rules - The rules in force for an item once inheritance has been resolved.
+//==============================================================================================================================
+type Category_Rules struct {
+	EarnRate		*int
+	Redeemable		bool
+	CategoryID		string
+}
+
+const   CATEGORY_PREFIX		=  "category_"
+const   MAX_CATEGORY_DEPTH	=  32
+
+//==============================================================================================================================
+//	 retrieve_category - Gets the category stored for categoryID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_category(stub shim.ChaincodeStubInterface, categoryID string) (Category, error) {
+
+	var v Category
+
+	bytes, err := stub.GetState(CATEGORY_PREFIX + categoryID)
+	if err != nil { return v, errors.New("RETRIEVE_CATEGORY: Error retrieving Category with categoryID = " + categoryID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_CATEGORY: No Category with categoryID = " + categoryID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_CATEGORY: Corrupt Category record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_category - Writes the category to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_category(stub shim.ChaincodeStubInterface, v Category) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting category record: %s", err); return errors.New("Error converting category record") }
+
+	err = stub.PutState(CATEGORY_PREFIX + v.CategoryID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing category record: %s", err); return errors.New("Error storing category record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 validate_category - Checks the rules and that the parent exists and isn't the category itself or one of its
+//						 descendants.
+//==============================================================================================================================
+func (t *SimpleChaincode) validate_category(stub shim.ChaincodeStubInterface, v Category) error {
+
+	if v.EarnRate != nil && (*v.EarnRate < 0 || *v.EarnRate > 100) { return errors.New("earnRate must be between 0 and 100") }
+
+	parentID := v.ParentID
+	for depth := 0; parentID != ""; depth++ {
+		if parentID == v.CategoryID { return errors.New("Category " + v.CategoryID + " cannot be its own ancestor") }
+		if depth >= MAX_CATEGORY_DEPTH { return errors.New(fmt.Sprintf("Category tree cannot be deeper than %d", MAX_CATEGORY_DEPTH)) }
+
+		parent, err := t.retrieve_category(stub, parentID)
+		if err != nil { return errors.New("Unknown parentId " + parentID) }
+		parentID = parent.ParentID
+	}
+	return nil
+}
+
+//=================================================================================================================================
+//	 create_category - Creates a category from its JSON definition.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_category(stub shim.ChaincodeStubInterface, category_json string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	var v Category
+	err = json.Unmarshal([]byte(category_json), &v)
+	if err != nil { return nil, errors.New("Invalid category JSON") }
+
+	if v.CategoryID == "" { return nil, errors.New("Category must have a categoryId") }
+	if v.Name == "" { v.Name = v.CategoryID }
+
+	_, err = t.retrieve_category(stub, v.CategoryID)
+	if err == nil { return nil, errors.New("Category already exists") }
+
+	err = t.validate_category(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.save_changes_category(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "categoryIDs", "categoryIDs", []string{v.CategoryID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 update_category - Replaces the name, parent and rules of an existing category.
+//=================================================================================================================================
+func (t *SimpleChaincode) update_category(stub shim.ChaincodeStubInterface, category_json string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	var v Category
+	err = json.Unmarshal([]byte(category_json), &v)
+	if err != nil { return nil, errors.New("Invalid category JSON") }
+
+	existing, err := t.retrieve_category(stub, v.CategoryID)
+	if err != nil { return nil, err }
+	if v.Name == "" { v.Name = existing.Name }
+
+	err = t.validate_category(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.save_changes_category(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 assign_item_category - Puts an item in a category. An empty categoryID takes the item out of its category.
+//=================================================================================================================================
+func (t *SimpleChaincode) assign_item_category(stub shim.ChaincodeStubInterface, itemID string, categoryID string) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	i, err := t.retrieve_item(stub, itemID)
+	if err != nil { return nil, err }
+
+	if categoryID != "" {
+		_, err = t.retrieve_category(stub, categoryID)
+		if err != nil { return nil, err }
+	}
+
+	i.CategoryID = categoryID
+
+	_, err = t.save_changes_item(stub, i)
+	if err != nil { fmt.Printf("ASSIGN_ITEM_CATEGORY: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 get_categories
+//=================================================================================================================================
+func (t *SimpleChaincode) get_categories(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	ids, err := t.retrieve_ids(stub, "categoryIDs", "categoryIDs")
+	if err != nil { return nil, err }
+
+	categories := []Category{}
+	for _, id := range ids {
+		v, err := t.retrieve_category(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Category") }
+		categories = append(categories, v)
+	}
+	return json.Marshal(categories)
+}
+
+//==============================================================================================================================
+//	 category_rules - Resolves the rules for an item by walking up from its category. Items with no category can be
+//					  redeemed and earn at the PoS rate.
+//==============================================================================================================================
+func (t *SimpleChaincode) category_rules(stub shim.ChaincodeStubInterface, i Item) (Category_Rules, error) {
+
+	rules := Category_Rules{Redeemable: true, CategoryID: i.CategoryID}
+	redeemable_set := false
+
+	categoryID := i.CategoryID
+	for depth := 0; categoryID != "" && depth < MAX_CATEGORY_DEPTH; depth++ {
+		c, err := t.retrieve_category(stub, categoryID)
+		if err != nil { return rules, err }
+
+		if rules.EarnRate == nil && c.EarnRate != nil { rules.EarnRate = c.EarnRate }
+		if !redeemable_set && c.Redeemable != nil { rules.Redeemable = *c.Redeemable; redeemable_set = true }
+
+		categoryID = c.ParentID
+	}
+	return rules, nil
+}
+
+//==============================================================================================================================
+//	 category_path - Returns the item's category followed by its ancestors, nearest first.
+//==============================================================================================================================
+func (t *SimpleChaincode) category_path(stub shim.ChaincodeStubInterface, i Item) ([]string, error) {
+
+	var path []string
+
+	categoryID := i.CategoryID
+	for depth := 0; categoryID != "" && depth < MAX_CATEGORY_DEPTH; depth++ {
+		c, err := t.retrieve_category(stub, categoryID)
+		if err != nil { return nil, err }
+
+		path = append(path, categoryID)
+		categoryID = c.ParentID
+	}
+	return path, nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Charity status
+//==============================================================================================================================
+const   CHARITY_PENDING		=  "pending"
+const   CHARITY_APPROVED	=  "approved"
+
+const   CHARITY_PREFIX		=  "charity_"
+const   DONATION_PREFIX		=  "donation_"
+const   CHARITY_AUTH_PREFIX	=  "charityauth_"
+
+const   CHARITY_AUTH_PENDING	=  "pending"
+const   CHARITY_AUTH_REDEEMED	=  "redeemed"
+
+//==============================================================================================================================
+//	Charity - A registered charity. Customers can donate points to it once the regulator has approved it, and the
+//			  charity spends its Balance at partner PoS. Manager is the username allowed to act for the charity.
+//==============================================================================================================================
+type Charity struct {
+	CharityID		string	`json:"charityId"`
+	Name			string	`json:"name"`
+	Manager			string	`json:"manager"`
+	Status			string	`json:"status"`
+	Balance			int		`json:"balance"`
+	TotalRaised		int		`json:"totalRaised"`
+}
+
+//==============================================================================================================================
+//	Donation - Points a customer gave to a charity. Year is the calendar year of the donation in UTC.
+//==============================================================================================================================
+type Donation struct {
+	DonationID		string	`json:"donationId"`
+	CustomerID		string	`json:"customerId"`
+	CharityID		string	`json:"charityId"`
+	Points			int		`json:"points"`
+	Year			int		`json:"year"`
+	Timestamp		int64	`json:"timestamp"`
+	TxID			string	`json:"txId"`
+}
+
+//==============================================================================================================================
+//	Charity Authorisation - The charity's go-ahead for one redemption, stored under charityauth_<AuthID> where AuthID is
+//							the TxID that created it. The operator of the item's PoS completes it with charity_redeem.
+//							Requested is as for buy_item_by_wallet.
+//==============================================================================================================================
+type Charity_Authorisation struct {
+	AuthID			string	`json:"authId"`
+	CharityID		string	`json:"charityId"`
+	ItemID			string	`json:"itemId"`
+	Requested		int		`json:"requested"`
+	AuthorisedBy	string	`json:"authorisedBy"`
+	AuthorisedAt	int64	`json:"authorisedAt"`
+	Status			string	`json:"status"`
+	RedeemedAt		int64	`json:"redeemedAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	Donation Receipt - A customer's donations for one year.
+//==============================================================================================================================
+type Donation_Receipt struct {
+	CustomerID		string			`json:"customerId"`
+	Year			int				`json:"year"`
+	Total			int				`json:"total"`
+	ByCharity		map[string]int	`json:"byCharity"`
+	Donations		[]Donation		`json:"donations"`
+}
+
+//==============================================================================================================================
+//	CharityID Holder - Defines the structure that holds all the charityIDs for Charities that have registered.
+//==============================================================================================================================
+type CharityID_Holder struct {
+	CharityIDs		[]string	`json:"charityIDs"`
+}
+
+//==============================================================================================================================
+//	DonationID Holder - Defines the structure that holds all the donationIDs for Donations that have been made.
+//==============================================================================================================================
+type DonationID_Holder struct {
+	DonationIDs		[]string	`json:"donationIDs"`
+}
+
+//==============================================================================================================================
+//	 retrieve_charity - Gets the charity stored for charityID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_charity(stub shim.ChaincodeStubInterface, charityID string) (Charity, error) {
+
+	var v Charity
+
+	bytes, err := stub.GetState(CHARITY_PREFIX + charityID)
+	if err != nil { return v, errors.New("RETRIEVE_CHARITY: Error retrieving Charity with charityID = " + charityID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_CHARITY: No Charity with charityID = " + charityID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_CHARITY: Corrupt Charity record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_charity - Writes the charity to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_charity(stub shim.ChaincodeStubInterface, v Charity) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting charity record: %s", err); return errors.New("Error converting charity record") }
+
+	err = stub.PutState(CHARITY_PREFIX + v.CharityID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing charity record: %s", err); return errors.New("Error storing charity record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_donation - Gets the donation stored for donationID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_donation(stub shim.ChaincodeStubInterface, donationID string) (Donation, error) {
+
+	var v Donation
+
+	bytes, err := stub.GetState(DONATION_PREFIX + donationID)
+	if err != nil { return v, errors.New("RETRIEVE_DONATION: Error retrieving Donation with donationID = " + donationID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_DONATION: No Donation with donationID = " + donationID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_DONATION: Corrupt Donation record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 check_charity_caller - Returns an error unless the caller is the charity's manager or the regulator.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_charity_caller(stub shim.ChaincodeStubInterface, v Charity) error {
+
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+
+	if caller_affiliation == AUTHORITY { return nil }
+	if caller_affiliation == CHARITY && caller == v.Manager { return nil }
+	return errors.New("Permission Denied. Only the manager of charity " + v.CharityID + " may perform this operation")
+}
+
+//=================================================================================================================================
+//	 register_charity - Registers a charity awaiting approval. A caller with the charity role becomes its manager.
+//=================================================================================================================================
+func (t *SimpleChaincode) register_charity(stub shim.ChaincodeStubInterface, charityID string, name string, manager string) ([]byte, error) {
+
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	if caller_affiliation == CHARITY {
+		manager = caller
+	} else if caller_affiliation != AUTHORITY {
+		return nil, errors.New("Permission Denied. register_charity is not allowed for role " + caller_affiliation)
+	}
+
+	if charityID == "" { return nil, errors.New("Charity must have a charityId") }
+	if manager == "" { return nil, errors.New("Charity must have a manager") }
+	if name == "" { name = charityID }
+
+	_, err = t.retrieve_charity(stub, charityID)
+	if err == nil { return nil, errors.New("Charity already exists") }
+
+	err = t.save_changes_charity(stub, Charity{CharityID: charityID, Name: name, Manager: manager, Status: CHARITY_PENDING})
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "charityIDs", "charityIDs", []string{charityID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 approve_charity - Lets a registered charity receive donations.
+//=================================================================================================================================
+func (t *SimpleChaincode) approve_charity(stub shim.ChaincodeStubInterface, charityID string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	v, err := t.retrieve_charity(stub, charityID)
+	if err != nil { return nil, err }
+
+	v.Status = CHARITY_APPROVED
+
+	err = t.save_changes_charity(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 donate_points - Moves points from a customer's wallet to an approved charity. Returns the donationID.
+//=================================================================================================================================
+func (t *SimpleChaincode) donate_points(stub shim.ChaincodeStubInterface, customerID string, charityID string, points_arg string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, customerID)
+	if err != nil { return nil, err }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points <= 0 { return nil, errors.New("Invalid points " + points_arg) }
+
+	c, err := t.retrieve_charity(stub, charityID)
+	if err != nil { return nil, err }
+	if c.Status != CHARITY_APPROVED { return nil, errors.New("Charity " + charityID + " has not been approved") }
+
+	v, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+	if !v.Status { return nil, errors.New(" Customer Not Active.") }
+	if v.Cashback < points { return nil, errors.New(" Not enough balance.") }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, customerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "donationIDs", "donationIDs")
+	if err != nil { return nil, err }
+
+	now := t.get_tx_time(stub)
+	d := Donation{DonationID: fmt.Sprintf("DN%08d", len(ids) + 1), CustomerID: customerID, CharityID: charityID, Points: points, Year: time.Unix(now, 0).UTC().Year(), Timestamp: now, TxID: stub.GetTxID()}
+
+	v.Cashback = v.Cashback - points
+	c.Balance = c.Balance + points
+	c.TotalRaised = c.TotalRaised + points
+
+	_, err = t.save_changes(stub, v)
+	if err != nil { fmt.Printf("DONATE_POINTS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	err = t.save_changes_charity(stub, c)
+	if err != nil { return nil, err }
+
+	bytes, err := json.Marshal(d)
+	if err != nil { return nil, errors.New("Error converting donation record") }
+
+	err = stub.PutState(DONATION_PREFIX + d.DonationID, bytes)
+	if err != nil { return nil, errors.New("Error storing donation record") }
+
+	err = t.append_ids(stub, "donationIDs", "donationIDs", []string{d.DonationID})
+	if err != nil { return nil, err }
+
+	return []byte(d.DonationID), nil
+}
+
+//==============================================================================================================================
+//	 retrieve_charity_authorisation - Gets the authorisation stored for authID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_charity_authorisation(stub shim.ChaincodeStubInterface, authID string) (Charity_Authorisation, error) {
+
+	var v Charity_Authorisation
+
+	bytes, err := stub.GetState(CHARITY_AUTH_PREFIX + authID)
+	if err != nil { return v, errors.New("RETRIEVE_CHARITY_AUTHORISATION: Error retrieving Authorisation with authID = " + authID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_CHARITY_AUTHORISATION: No Authorisation with authID = " + authID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_CHARITY_AUTHORISATION: Corrupt Authorisation record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_charity_authorisation - Writes the authorisation to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_charity_authorisation(stub shim.ChaincodeStubInterface, v Charity_Authorisation) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting charity authorisation record: %s", err); return errors.New("Error converting charity authorisation record") }
+
+	err = stub.PutState(CHARITY_AUTH_PREFIX + v.AuthID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing charity authorisation record: %s", err); return errors.New("Error storing charity authorisation record") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 authorise_charity_redemption - The charity's manager authorises spending its points on an item. Returns the
+//									authID to hand to the PoS.
+//=================================================================================================================================
+func (t *SimpleChaincode) authorise_charity_redemption(stub shim.ChaincodeStubInterface, charityID string, itemID string, requested int) ([]byte, error) {
+
+	c, err := t.retrieve_charity(stub, charityID)
+	if err != nil { return nil, err }
+
+	err = t.check_charity_caller(stub, c)
+	if err != nil { return nil, err }
+	if c.Status != CHARITY_APPROVED { return nil, errors.New("Charity " + charityID + " has not been approved") }
+
+	_, err = t.retrieve_item(stub, itemID)
+	if err != nil { return nil, errors.New("Unknown itemId " + itemID) }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	v := Charity_Authorisation{AuthID: stub.GetTxID(), CharityID: charityID, ItemID: itemID, Requested: requested, AuthorisedBy: caller, AuthorisedAt: t.get_tx_time(stub), Status: CHARITY_AUTH_PENDING}
+
+	err = t.save_changes_charity_authorisation(stub, v)
+	if err != nil { return nil, err }
+
+	return []byte(v.AuthID), nil
+}
+
+//=================================================================================================================================
+//	 charity_redeem - Pays for the authorised item at a partner PoS with the charity's points, under the same
+//					  redemption rules as a customer. The caller must be an operator of the PoS, as for any purchase,
+//					  and each authorisation is used once.
+//=================================================================================================================================
+func (t *SimpleChaincode) charity_redeem(stub shim.ChaincodeStubInterface, authID string) ([]byte, error) {
+
+	a, err := t.retrieve_charity_authorisation(stub, authID)
+	if err != nil { return nil, err }
+	if a.Status != CHARITY_AUTH_PENDING { return nil, errors.New("Authorisation " + authID + " has already been " + a.Status) }
+
+	c, err := t.retrieve_charity(stub, a.CharityID)
+	if err != nil { return nil, err }
+	if c.Status != CHARITY_APPROVED { return nil, errors.New("Charity " + a.CharityID + " has not been approved") }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	i, err := t.retrieve_item(stub, a.ItemID)
+	if err != nil { return nil, errors.New("Unknown itemId " + a.ItemID) }
+
+	rules, err := t.category_rules(stub, i)
+	if err != nil { return nil, errors.New("Error retrieving category") }
+	if !rules.Redeemable { return nil, errors.New(" Items in category " + rules.CategoryID + " cannot be bought with points.") }
+
+	p, err := t.retrieve_pos(stub, i.PoSID)
+	if err != nil { return nil, errors.New("Unknown posId " + i.PoSID) }
+
+	err = t.check_pos_operator(stub, p)
+	if err != nil { return nil, err }
+
+	err = t.check_pos_not_frozen(stub, p)
+	if err != nil { return nil, err }
+
+	r, err := plan_redemption(config, p, i.Price, a.Requested)
+	if err != nil { return nil, err }
+	if c.Balance < r.Points { return nil, errors.New(" Not enough balance.") }
+
+	c.Balance = c.Balance - r.Points
+
+	err = t.save_changes_charity(stub, c)
+	if err != nil { return nil, err }
+
+	a.Status = CHARITY_AUTH_REDEEMED
+	a.RedeemedAt = t.get_tx_time(stub)
+
+	err = t.save_changes_charity_authorisation(stub, a)
+	if err != nil { return nil, err }
+
+	err = t.settle_burn(stub, p, r.Points, r.Value)
+	if err == nil { err = t.charge_fee(stub, p, FEE_REDEMPTION, r.Points, r.Value) }
+	if err == nil { err = t.record_liability(stub, p.PartnerID, Liability_Movement{Redeemed: r.Points}) }
+	if err != nil { return nil, err }
+
+	return t.save_purchase(stub, Purchase{CharityID: a.CharityID, ItemID: i.ItemID, PoSID: i.PoSID, Price: i.Price, Method: PURCHASE_BY_WALLET, PointsRedeemed: r.Points, CashPaid: r.Cash})
+}
+
+//=================================================================================================================================
+//	 get_charities - Returns every approved charity and the points it has raised. Anyone may call this.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_charities(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	ids, err := t.retrieve_ids(stub, "charityIDs", "charityIDs")
+	if err != nil { return nil, err }
+
+	type charity_total struct {
+		CharityID		string	`json:"charityId"`
+		Name			string	`json:"name"`
+		TotalRaised		int		`json:"totalRaised"`
+	}
+
+	totals := []charity_total{}
+	for _, id := range ids {
+		c, err := t.retrieve_charity(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Charity") }
+		if c.Status == CHARITY_APPROVED { totals = append(totals, charity_total{c.CharityID, c.Name, c.TotalRaised}) }
+	}
+	return json.Marshal(totals)
+}
+
+//=================================================================================================================================
+//	 get_donation_receipt - Returns a customer's donations for a year.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_donation_receipt(stub shim.ChaincodeStubInterface, customerID string, year_arg string) ([]byte, error) {
+
+	err := t.check_customer_view(stub, customerID)
+	if err != nil { return nil, err }
+
+	year, err := strconv.Atoi(year_arg)
+	if err != nil { return nil, errors.New("Invalid year " + year_arg) }
+
+	ids, err := t.retrieve_ids(stub, "donationIDs", "donationIDs")
+	if err != nil { return nil, err }
+
+	receipt := Donation_Receipt{CustomerID: customerID, Year: year, ByCharity: map[string]int{}, Donations: []Donation{}}
+	for _, id := range ids {
+		d, err := t.retrieve_donation(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Donation") }
+		if d.CustomerID != customerID || d.Year != year { continue }
+
+		receipt.Donations = append(receipt.Donations, d)
+		receipt.ByCharity[d.CharityID] = receipt.ByCharity[d.CharityID] + d.Points
+		receipt.Total = receipt.Total + d.Points
+	}
+	return json.Marshal(receipt)
+}
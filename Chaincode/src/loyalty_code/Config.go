@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Entity types - Used as keys into the ID policies of the program config.
+//==============================================================================================================================
+const   ENTITY_CUSTOMER		=  "customer"
+const   ENTITY_POS			=  "pos"
+const   ENTITY_ITEM			=  "item"
+const   ENTITY_PARTNER		=  "partner"
+const   ENTITY_GIFT			=  "gift"
+const   ENTITY_PROGRAM		=  "program"
+
+//==============================================================================================================================
+//	 Feature toggles - Named switches in the program config. A feature that is not listed is enabled.
+//==============================================================================================================================
+const   FEATURE_BULK_IMPORT			=  "bulkImport"
+const   FEATURE_WALLET_PURCHASE		=  "walletPurchase"
+const   FEATURE_REFERRALS			=  "referrals"
+const   FEATURE_PARTNER_FLOATS		=  "partnerFloats"
+const   FEATURE_POS_AUTHORISATION	=  "posAuthorisation"
+
+const   DEFAULT_LOYALTY_PERCENTAGE	=  5
+
+const   DEFAULT_MAX_CAMPAIGN_MULTIPLIER	=  300
+const   DEFAULT_MAX_CAMPAIGN_BONUS		=  1000
+const   DEFAULT_MAX_VOUCHER_BONUS		=  1000
+const   DEFAULT_MAX_FREQUENCY_DISCOUNT		=  50
+const   DEFAULT_MAX_FREQUENCY_MULTIPLIER	=  300
+
+//==============================================================================================================================
+//	Program Config - Program wide business rules, stored under programConfig. Every version is also kept under
+//					 programConfig_<version> so the regulator can see who changed what and when.
+//
+//					 MaxTransfer of 0 means transfers are not limited. MinBalanceAfterRedemption is the balance a
+//					 customer must keep after paying with points. MaxRedemptionShare is the percentage of a price that
+//					 may be paid with points, and the burn caps limit the points a customer redeems per day and per
+//					 month, 0 meaning no limit. PoSPointRates sets the points needed for 100 of value at a PoS,
+//					 otherwise 100. GiftExpiry is the seconds a gift waits to be accepted before it goes back to the
+//					 sender. ReferrerBonus and RefereeBonus are paid once a referred customer first buys something
+//					 priced at ReferralMinPurchase or more. IssuerPartnerID is the partner that issues the points;
+//					 earns and burns at other partners' PoS are settled with it, PointCost being what a partner pays
+//					 the issuer for 100 points it awards. PaymentsChaincode names the chaincode that takes money
+//					 purchases before points are earned, called with PaymentsFunction; see Payments.go. A campaign may
+//					 multiply points by at most MaxCampaignMultiplier percent and add at most MaxCampaignBonus, and a
+//					 points bonus voucher is worth at most MaxVoucherBonus. A frequency program level may take at most
+//					 MaxFrequencyDiscount percent off and multiply points by at most MaxFrequencyMultiplier percent.
+//==============================================================================================================================
+type Program_Config struct {
+	Version						int					`json:"version"`
+	ProgramName					string				`json:"programName"`
+	DefaultEarnRate				int					`json:"defaultEarnRate"`
+	MinRedemption				int					`json:"minRedemption"`
+	MaxTransfer					int					`json:"maxTransfer"`
+	MinBalanceAfterRedemption	int					`json:"minBalanceAfterRedemption"`
+	MaxRedemptionShare			int					`json:"maxRedemptionShare"`
+	DailyBurnCap				int					`json:"dailyBurnCap"`
+	MonthlyBurnCap				int					`json:"monthlyBurnCap"`
+	PoSPointRates				map[string]int		`json:"posPointRates"`
+	GiftExpiry					int64				`json:"giftExpiry"`
+	ReferrerBonus				int					`json:"referrerBonus"`
+	RefereeBonus				int					`json:"refereeBonus"`
+	ReferralMinPurchase			int					`json:"referralMinPurchase"`
+	IssuerPartnerID				string				`json:"issuerPartnerId"`
+	PointCost					int					`json:"pointCost"`
+	PaymentsChaincode			string				`json:"paymentsChaincode"`
+	PaymentsFunction			string				`json:"paymentsFunction"`
+	MaxCampaignMultiplier		int					`json:"maxCampaignMultiplier"`
+	MaxCampaignBonus			int					`json:"maxCampaignBonus"`
+	MaxVoucherBonus				int					`json:"maxVoucherBonus"`
+	MaxFrequencyDiscount		int					`json:"maxFrequencyDiscount"`
+	MaxFrequencyMultiplier		int					`json:"maxFrequencyMultiplier"`
+	IDPolicies					map[string]ID_Policy	`json:"idPolicies"`
+	Features					map[string]bool		`json:"features"`
+	UpdatedBy					string				`json:"updatedBy"`
+	UpdatedAt					int64				`json:"updatedAt"`
+	TxID						string				`json:"txId"`
+}
+
+//==============================================================================================================================
+//	 default_program_config - The rules the chaincode used before they were moved on to the ledger.
+//==============================================================================================================================
+func default_program_config() Program_Config {
+
+	return Program_Config{
+		DefaultEarnRate:			DEFAULT_LOYALTY_PERCENTAGE,
+		MinBalanceAfterRedemption:	1,
+		MaxRedemptionShare:			100,
+		GiftExpiry:					DEFAULT_GIFT_EXPIRY,
+		PointCost:					100,
+		MaxCampaignMultiplier:		DEFAULT_MAX_CAMPAIGN_MULTIPLIER,
+		MaxCampaignBonus:			DEFAULT_MAX_CAMPAIGN_BONUS,
+		MaxVoucherBonus:			DEFAULT_MAX_VOUCHER_BONUS,
+		MaxFrequencyDiscount:		DEFAULT_MAX_FREQUENCY_DISCOUNT,
+		MaxFrequencyMultiplier:		DEFAULT_MAX_FREQUENCY_MULTIPLIER,
+		IDPolicies:					default_id_policies(),
+		Features:	map[string]bool{},
+	}
+}
+
+//==============================================================================================================================
+//	 feature_enabled - Features are on unless the config switches them off.
+//==============================================================================================================================
+func (c Program_Config) feature_enabled(feature string) bool {
+
+	enabled, ok := c.Features[feature]
+	return !ok || enabled
+}
+
+//==============================================================================================================================
+//	 validate - Checks a config before it is written.
+//==============================================================================================================================
+func (c Program_Config) validate() error {
+
+	if c.DefaultEarnRate < 0 || c.DefaultEarnRate > 100 { return errors.New("defaultEarnRate must be between 0 and 100") }
+	if c.MinRedemption < 0 { return errors.New("minRedemption cannot be negative") }
+	if c.MaxTransfer < 0 { return errors.New("maxTransfer cannot be negative") }
+	if c.MinBalanceAfterRedemption < 0 { return errors.New("minBalanceAfterRedemption cannot be negative") }
+	if c.MaxRedemptionShare < 0 || c.MaxRedemptionShare > 100 { return errors.New("maxRedemptionShare must be between 0 and 100") }
+	if c.DailyBurnCap < 0 || c.MonthlyBurnCap < 0 { return errors.New("Burn caps cannot be negative") }
+
+	for posID, rate := range c.PoSPointRates {
+		if rate <= 0 { return errors.New("Points rate for PoS " + posID + " must be greater than 0") }
+	}
+
+	if c.GiftExpiry <= 0 { return errors.New("giftExpiry must be greater than 0") }
+
+	if c.ReferrerBonus < 0 || c.RefereeBonus < 0 { return errors.New("Referral bonuses cannot be negative") }
+	if c.ReferralMinPurchase < 0 { return errors.New("referralMinPurchase cannot be negative") }
+	if c.PointCost < 0 { return errors.New("pointCost cannot be negative") }
+	if c.MaxCampaignMultiplier < 100 { return errors.New("maxCampaignMultiplier must be at least 100") }
+	if c.MaxCampaignBonus < 0 { return errors.New("maxCampaignBonus cannot be negative") }
+	if c.MaxVoucherBonus < 0 { return errors.New("maxVoucherBonus cannot be negative") }
+	if c.MaxFrequencyDiscount < 0 || c.MaxFrequencyDiscount > 100 { return errors.New("maxFrequencyDiscount must be between 0 and 100") }
+	if c.MaxFrequencyMultiplier < 100 { return errors.New("maxFrequencyMultiplier must be at least 100") }
+
+	for entity, policy := range c.IDPolicies {
+		if entity != ENTITY_CUSTOMER && entity != ENTITY_POS && entity != ENTITY_ITEM && entity != ENTITY_PARTNER && entity != ENTITY_GIFT && entity != ENTITY_PROGRAM { return errors.New("Unknown entity type " + entity + " in idPolicies") }
+		err := policy.validate(entity)
+		if err != nil { return errors.New("Invalid idPolicies: " + err.Error()) }
+		if entity == ENTITY_GIFT && !policy.Generate { return errors.New("Invalid idPolicies: gift IDs are always generated") }
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_program_config - Returns the program config, or the defaults if none has been written.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_program_config(stub shim.ChaincodeStubInterface) (Program_Config, error) {
+
+	v := default_program_config()
+
+	bytes, err := stub.GetState("programConfig")
+	if err != nil { return v, errors.New("Unable to get programConfig") }
+	if bytes == nil { return v, nil }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("Corrupt programConfig record") }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_program_config - Stamps the config with the caller, time and transaction, then writes it as the current
+//						   config and as the next entry in its history.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_program_config(stub shim.ChaincodeStubInterface, v Program_Config) error {
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { caller = "" }												// Init may run without the deployer's attributes
+
+	v.UpdatedBy = caller
+	v.UpdatedAt = t.get_tx_time(stub)
+	v.TxID = stub.GetTxID()
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return errors.New("Error converting programConfig record") }
+
+	err = stub.PutState("programConfig", bytes)
+	if err != nil { return errors.New("Error storing programConfig record") }
+
+	err = stub.PutState("programConfig_" + strconv.Itoa(v.Version), bytes)
+	if err != nil { return errors.New("Error storing programConfig history") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 update_program_config - Replaces the program config. expected_version must be the version the caller read, so two
+//							 regulators editing at once can't silently overwrite each other.
+//=================================================================================================================================
+func (t *SimpleChaincode) update_program_config(stub shim.ChaincodeStubInterface, expected_version string, config_json string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	current, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	version, err := strconv.Atoi(expected_version)
+	if err != nil { return nil, errors.New("Invalid version " + expected_version) }
+	if version != current.Version { return nil, errors.New(fmt.Sprintf("Program config has changed, expected version %d but the current version is %d", version, current.Version)) }
+
+	v := default_program_config()
+	err = json.Unmarshal([]byte(config_json), &v)
+	if err != nil { return nil, errors.New("Invalid program config JSON") }
+
+	err = v.validate()
+	if err != nil { return nil, errors.New("Invalid program config: " + err.Error()) }
+
+	if v.IssuerPartnerID != "" {
+		_, err = t.retrieve_partner(stub, v.IssuerPartnerID)
+		if err != nil { return nil, errors.New("Invalid program config: unknown issuer partner " + v.IssuerPartnerID) }
+	}
+
+	v.Version = current.Version + 1
+
+	err = t.save_program_config(stub, v)
+	if err != nil { fmt.Printf("UPDATE_PROGRAM_CONFIG: Error saving changes: %s", err); return nil, err }
+
+	return []byte(strconv.Itoa(v.Version)), nil
+}
+
+//=================================================================================================================================
+//	 get_program_config
+//=================================================================================================================================
+func (t *SimpleChaincode) get_program_config(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	v, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	return json.Marshal(v)
+}
+
+//=================================================================================================================================
+//	 get_program_config_history - Returns every recorded version of the program config, oldest first.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_program_config_history(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	current, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	history := []Program_Config{}
+
+	for version := 1; version <= current.Version; version++ {
+		bytes, err := stub.GetState("programConfig_" + strconv.Itoa(version))
+		if err != nil { return nil, errors.New("Unable to get programConfig history") }
+		if bytes == nil { continue }											// Versions from before a restore aren't exported
+
+		var v Program_Config
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt programConfig history record") }
+		history = append(history, v)
+	}
+
+	return json.Marshal(history)
+}
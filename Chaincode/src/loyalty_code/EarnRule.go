@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Earn rule actions
+//==============================================================================================================================
+const   EARN_PERCENTAGE		=  "percentage"
+const   EARN_MULTIPLIER		=  "multiplier"
+const   EARN_BONUS			=  "bonus"
+const   EARN_CAP			=  "cap"
+
+const   EARN_RULE_PREFIX	=  "earnrule_"
+
+//==============================================================================================================================
+//	Earn Rule - One rule for the points a money purchase earns. A rule fires when the purchase matches every condition
+//				that is set: an empty list, a zero amount or a zero date matches everything. Weekdays are 0 for Sunday
+//				to 6 for Saturday, in UTC. MaxAmount, End and the amounts are inclusive.
+//
+//				Value is the earn rate for percentage, the percent to scale the points by for multiplier (200
+//				doubles them), the points added for bonus and the most points the purchase may earn for cap.
+//==============================================================================================================================
+type Earn_Rule struct {
+	RuleID			string		`json:"ruleId"`
+	Name			string		`json:"name"`
+	Priority		int			`json:"priority"`
+	Exclusive		bool		`json:"exclusive"`
+	PoSIDs			[]string	`json:"posIds"`
+	ItemIDs			[]string	`json:"itemIds"`
+	CategoryIDs		[]string	`json:"categoryIds"`
+	Tiers			[]string	`json:"tiers"`
+	MinAmount		int			`json:"minAmount"`
+	MaxAmount		int			`json:"maxAmount"`
+	Weekdays		[]int		`json:"weekdays"`
+	Start			int64		`json:"start"`
+	End				int64		`json:"end"`
+	Action			string		`json:"action"`
+	Value			int			`json:"value"`
+	Status			bool		`json:"status"`
+}
+
+//==============================================================================================================================
+//	EarnRuleID Holder - Defines the structure that holds all the ruleIDs for Earn Rules that have been created.
+//==============================================================================================================================
+type EarnRuleID_Holder struct {
+	RuleIDs			[]string	`json:"ruleIDs"`
+}
+
+//==============================================================================================================================
+//	Earn Result - How the points for a purchase were worked out. Rules lists the rules that fired in the order they
+//				  were applied, each with the points once it had been applied.
+//==============================================================================================================================
+type Earn_Result struct {
+	Price			int				`json:"price"`
+	Rate			int				`json:"rate"`
+	Points			int				`json:"points"`
+	Rules			[]Fired_Rule	`json:"rules"`
+	CampaignID		string			`json:"campaignId,omitempty"`
+}
+
+type Fired_Rule struct {
+	RuleID			string		`json:"ruleId"`
+	Action			string		`json:"action"`
+	Value			int			`json:"value"`
+	Points			int			`json:"points"`
+}
+
+//==============================================================================================================================
+//	 rule_ids - The IDs of the rules that fired, for the purchase record.
+//==============================================================================================================================
+func (r Earn_Result) rule_ids() []string {
+
+	var ids []string
+	for _, fired := range r.Rules {
+		ids = append(ids, fired.RuleID)
+	}
+	return ids
+}
+
+//==============================================================================================================================
+//	Earn Simulation - The hypothetical purchase passed to simulate_earn. Price defaults to the item's price, Tier to
+//					  the customer's tier and Timestamp to the transaction time.
+//==============================================================================================================================
+type Earn_Simulation struct {
+	ItemID			string		`json:"itemId"`
+	CustomerID		string		`json:"customerId"`
+	Tier			string		`json:"tier"`
+	Price			int			`json:"price"`
+	Timestamp		int64		`json:"timestamp"`
+}
+
+//==============================================================================================================================
+//	 retrieve_earn_rule - Gets the earn rule stored for ruleID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_earn_rule(stub shim.ChaincodeStubInterface, ruleID string) (Earn_Rule, error) {
+
+	var v Earn_Rule
+
+	bytes, err := stub.GetState(EARN_RULE_PREFIX + ruleID)
+	if err != nil { return v, errors.New("RETRIEVE_EARN_RULE: Error retrieving Earn Rule with ruleID = " + ruleID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_EARN_RULE: No Earn Rule with ruleID = " + ruleID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_EARN_RULE: Corrupt Earn Rule record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_earn_rule - Writes the earn rule to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_earn_rule(stub shim.ChaincodeStubInterface, v Earn_Rule) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting earn rule record: %s", err); return errors.New("Error converting earn rule record") }
+
+	err = stub.PutState(EARN_RULE_PREFIX + v.RuleID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing earn rule record: %s", err); return errors.New("Error storing earn rule record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 validate - Checks a rule before it is written.
+//==============================================================================================================================
+func (r Earn_Rule) validate() error {
+
+	if r.RuleID == "" { return errors.New("Earn rule must have a ruleId") }
+
+	if r.Action == EARN_PERCENTAGE {
+		if r.Value < 0 || r.Value > 100 { return errors.New("Percentage must be between 0 and 100") }
+	} else if r.Action == EARN_MULTIPLIER || r.Action == EARN_BONUS || r.Action == EARN_CAP {
+		if r.Value < 0 { return errors.New("Value for " + r.Action + " cannot be negative") }
+	} else {
+		return errors.New("Unknown earn rule action " + r.Action)
+	}
+
+	if r.MinAmount < 0 || r.MaxAmount < 0 { return errors.New("Amounts cannot be negative") }
+	if r.MaxAmount != 0 && r.MaxAmount < r.MinAmount { return errors.New("maxAmount cannot be below minAmount") }
+	if r.End != 0 && r.End < r.Start { return errors.New("Earn rule must end after it starts") }
+
+	for _, day := range r.Weekdays {
+		if day < 0 || day > 6 { return errors.New(fmt.Sprintf("Invalid weekday %d, expected 0 (Sunday) to 6 (Saturday)", day)) }
+	}
+	return nil
+}
+
+//=================================================================================================================================
+//	 create_earn_rule - Creates an earn rule from its JSON definition.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_earn_rule(stub shim.ChaincodeStubInterface, rule_json string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	v := Earn_Rule{Status: true}
+	err = json.Unmarshal([]byte(rule_json), &v)
+	if err != nil { return nil, errors.New("Invalid earn rule JSON") }
+
+	err = v.validate()
+	if err != nil { return nil, err }
+
+	_, err = t.retrieve_earn_rule(stub, v.RuleID)
+	if err == nil { return nil, errors.New("Earn rule already exists") }
+
+	err = t.save_changes_earn_rule(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "earnRuleIDs", "ruleIDs", []string{v.RuleID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 update_earn_rule - Replaces an existing earn rule. Set status to false to switch it off.
+//=================================================================================================================================
+func (t *SimpleChaincode) update_earn_rule(stub shim.ChaincodeStubInterface, rule_json string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	v := Earn_Rule{Status: true}
+	err = json.Unmarshal([]byte(rule_json), &v)
+	if err != nil { return nil, errors.New("Invalid earn rule JSON") }
+
+	_, err = t.retrieve_earn_rule(stub, v.RuleID)
+	if err != nil { return nil, err }
+
+	err = v.validate()
+	if err != nil { return nil, err }
+
+	err = t.save_changes_earn_rule(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 get_earn_rules - Returns every earn rule in the order they are evaluated.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_earn_rules(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	rules, err := t.retrieve_earn_rules(stub)
+	if err != nil { return nil, err }
+
+	return json.Marshal(rules)
+}
+
+//==============================================================================================================================
+//	 retrieve_earn_rules - Returns every earn rule sorted by priority, lowest first, then by ruleID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_earn_rules(stub shim.ChaincodeStubInterface) ([]Earn_Rule, error) {
+
+	ids, err := t.retrieve_ids(stub, "earnRuleIDs", "ruleIDs")
+	if err != nil { return nil, err }
+
+	rules := []Earn_Rule{}
+	for _, id := range ids {
+		r, err := t.retrieve_earn_rule(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Earn Rule") }
+		rules = append(rules, r)
+	}
+
+	sort.SliceStable(rules, func(a, b int) bool {
+		if rules[a].Priority != rules[b].Priority { return rules[a].Priority < rules[b].Priority }
+		return rules[a].RuleID < rules[b].RuleID
+	})
+	return rules, nil
+}
+
+//==============================================================================================================================
+//	 matches - true if the rule is on and the purchase meets every condition it sets. categories is the item's
+//			   category path, so a rule for a category also covers its sub-categories.
+//==============================================================================================================================
+func (r Earn_Rule) matches(now int64, tier string, i Item, categories []string, price int) bool {
+
+	if !r.Status { return false }
+	if r.Start != 0 && now < r.Start { return false }
+	if r.End != 0 && now > r.End { return false }
+	if price < r.MinAmount || (r.MaxAmount != 0 && price > r.MaxAmount) { return false }
+	if !in_list(r.PoSIDs, i.PoSID) || !in_list(r.ItemIDs, i.ItemID) || !in_list(r.Tiers, tier) { return false }
+
+	if len(r.CategoryIDs) > 0 {
+		found := false
+		for _, categoryID := range categories {
+			if in_list(r.CategoryIDs, categoryID) { found = true; break }
+		}
+		if !found { return false }
+	}
+
+	if len(r.Weekdays) > 0 {
+		weekday := int(time.Unix(now, 0).UTC().Weekday())
+		found := false
+		for _, day := range r.Weekdays {
+			if day == weekday { found = true; break }
+		}
+		if !found { return false }
+	}
+	return true
+}
+
+//==============================================================================================================================
+//	 evaluate_earn_rules - Works out the points a money purchase of i at price earns.
+//
+//						   The rules are checked in priority order; an exclusive rule that fires stops any later rule
+//						   from firing. The rules that fired are then applied in stages, in priority order within a
+//						   stage:
+//
+//						   1. The first percentage rule sets the earn rate. Without one the rate is the category's
+//							  earn rate, or else the PoS loyalty percentage. Later percentage rules are ignored.
+//						   2. Points are the rate times the price, over 100.
+//						   3. Every multiplier scales the points.
+//						   4. Every bonus is added.
+//						   5. The lowest cap limits the total.
+//==============================================================================================================================
+func (t *SimpleChaincode) evaluate_earn_rules(stub shim.ChaincodeStubInterface, tier string, i Item, p PoS, price int, now int64) (Earn_Result, error) {
+
+	result := Earn_Result{Price: price, Rate: p.LoyaltyPercentage, Rules: []Fired_Rule{}}
+
+	category, err := t.category_rules(stub, i)
+	if err != nil { return result, err }
+	if category.EarnRate != nil { result.Rate = *category.EarnRate }			// Category rate overrides the PoS rate
+
+	categories, err := t.category_path(stub, i)
+	if err != nil { return result, err }
+
+	rules, err := t.retrieve_earn_rules(stub)
+	if err != nil { return result, err }
+
+	var fired []Earn_Rule
+	for _, r := range rules {
+		if !r.matches(now, tier, i, categories, price) { continue }
+		fired = append(fired, r)
+		if r.Exclusive { break }
+	}
+
+	for _, r := range fired {
+		if r.Action == EARN_PERCENTAGE {
+			result.Rate = r.Value
+			result.Rules = append(result.Rules, Fired_Rule{r.RuleID, r.Action, r.Value, r.Value * price / 100})
+			break
+		}
+	}
+
+	result.Points = result.Rate * price / 100
+
+	for _, action := range []string{EARN_MULTIPLIER, EARN_BONUS, EARN_CAP} {
+		for _, r := range fired {
+			if r.Action != action { continue }
+
+			if action == EARN_MULTIPLIER {
+				result.Points = result.Points * r.Value / 100
+			} else if action == EARN_BONUS {
+				result.Points = result.Points + r.Value
+			} else if result.Points > r.Value {
+				result.Points = r.Value
+			}
+			result.Rules = append(result.Rules, Fired_Rule{r.RuleID, r.Action, r.Value, result.Points})
+		}
+	}
+	return result, nil
+}
+
+//=================================================================================================================================
+//	 simulate_earn - Shows the points a hypothetical money purchase would earn and which rules and campaign would
+//					 apply, without changing the ledger.
+//=================================================================================================================================
+func (t *SimpleChaincode) simulate_earn(stub shim.ChaincodeStubInterface, simulation_json string) ([]byte, error) {
+
+	var sim Earn_Simulation
+	err := json.Unmarshal([]byte(simulation_json), &sim)
+	if err != nil { return nil, errors.New("Invalid simulation JSON") }
+
+	i, err := t.retrieve_item(stub, sim.ItemID)
+	if err != nil { return nil, errors.New("Unknown itemId " + sim.ItemID) }
+
+	p, err := t.retrieve_pos(stub, i.PoSID)
+	if err != nil { return nil, errors.New("Unknown posId " + i.PoSID) }
+
+	v := Customer{Tier: sim.Tier}
+	if sim.CustomerID != "" {
+		v, err = t.retrieve_customer(stub, sim.CustomerID)
+		if err != nil { return nil, err }
+		if sim.Tier != "" { v.Tier = sim.Tier }
+	}
+
+	if sim.Price == 0 { sim.Price = i.Price }
+	if sim.Price < 0 { return nil, errors.New("Price cannot be negative") }
+	if sim.Timestamp == 0 { sim.Timestamp = t.get_tx_time(stub) }
+
+	result, err := t.evaluate_earn_rules(stub, v.Tier, i, p, sim.Price, sim.Timestamp)
+	if err != nil { return nil, err }
+
+	result.Points, result.CampaignID, err = t.apply_campaigns(stub, v, i, p, result.Points, sim.Timestamp)
+	if err != nil { return nil, err }
+
+	return json.Marshal(result)
+}
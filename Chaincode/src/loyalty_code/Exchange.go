@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Loyalty programs - CASHBACK_PROGRAM is the program the chaincode has always run, whose points are a customer's
+//						Cashback. Every other program keeps its points in the customer's Balances.
+//==============================================================================================================================
+const   CASHBACK_PROGRAM		=  "cashback"
+
+const   LOYALTY_PROGRAM_PREFIX	=  "program_"
+const   EXCHANGE_RATE_PREFIX	=  "exrate_"
+const   EXCHANGE_PREFIX			=  "exchange_"
+const   EXCHANGED_PREFIX		=  "exchanged_"
+
+const   RATE_PENDING			=  "pending"
+const   RATE_ACTIVE				=  "active"
+
+//==============================================================================================================================
+//	Loyalty Program - A partner's own points program, e.g. a hotel's. Manager is the username that runs it.
+//==============================================================================================================================
+type Loyalty_Program struct {
+	ProgramID		string	`json:"programId"`
+	Name			string	`json:"name"`
+	PartnerID		string	`json:"partnerId"`
+	Manager			string	`json:"manager,omitempty"`
+	Status			bool	`json:"status"`
+}
+
+//==============================================================================================================================
+//	Exchange Rate - How points of FromProgram convert into ToProgram: every FromPoints, after FeePercent is taken off,
+//					become ToPoints. A customer must exchange at least MinPoints at a time and at most DailyLimit a
+//					day (0 for no limit). The rate is active once the owners of both programs have approved the
+//					same terms; proposing new terms withdraws the approvals.
+//==============================================================================================================================
+type Exchange_Rate struct {
+	FromProgram		string	`json:"fromProgram"`
+	ToProgram		string	`json:"toProgram"`
+	FromPoints		int		`json:"fromPoints"`
+	ToPoints		int		`json:"toPoints"`
+	FeePercent		int		`json:"feePercent"`
+	MinPoints		int		`json:"minPoints"`
+	DailyLimit		int		`json:"dailyLimit"`
+	ApprovedFrom	bool	`json:"approvedFrom"`
+	ApprovedTo		bool	`json:"approvedTo"`
+	Status			string	`json:"status"`
+}
+
+//==============================================================================================================================
+//	Exchange - One conversion made by a customer. Fee is in points of the program exchanged from and is booked to
+//			   that program's liability as fees.
+//==============================================================================================================================
+type Exchange struct {
+	ExchangeID		string	`json:"exchangeId"`
+	CustomerID		string	`json:"customerId"`
+	FromProgram		string	`json:"fromProgram"`
+	ToProgram		string	`json:"toProgram"`
+	Points			int		`json:"points"`
+	Fee				int		`json:"fee"`
+	Received		int		`json:"received"`
+	Timestamp		int64	`json:"timestamp"`
+	TxID			string	`json:"txId"`
+}
+
+type Exchange_Counter struct {
+	Day				int64	`json:"day"`
+	Points			int		`json:"points"`
+}
+
+//==============================================================================================================================
+//	Exchange Holders - Define the structures that hold the programIDs, the keys of exchange rates and the exchangeIDs.
+//==============================================================================================================================
+type LoyaltyProgramID_Holder struct {
+	ProgramIDs		[]string	`json:"programIDs"`
+}
+
+type ExchangeRate_Holder struct {
+	Rates			[]string	`json:"rates"`
+}
+
+type ExchangeID_Holder struct {
+	ExchangeIDs		[]string	`json:"exchangeIDs"`
+}
+
+//==============================================================================================================================
+//	 retrieve_loyalty_program - Gets the program stored for programID. The cashback program is not stored.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_loyalty_program(stub shim.ChaincodeStubInterface, programID string) (Loyalty_Program, error) {
+
+	var v Loyalty_Program
+
+	if programID == CASHBACK_PROGRAM {
+		config, err := t.retrieve_program_config(stub)
+		if err != nil { return v, err }
+		return Loyalty_Program{ProgramID: CASHBACK_PROGRAM, Name: config.ProgramName, PartnerID: config.IssuerPartnerID, Status: true}, nil
+	}
+
+	bytes, err := stub.GetState(LOYALTY_PROGRAM_PREFIX + programID)
+	if err != nil { return v, errors.New("RETRIEVE_LOYALTY_PROGRAM: Error retrieving Program with programID = " + programID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_LOYALTY_PROGRAM: No Program with programID = " + programID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_LOYALTY_PROGRAM: Corrupt Program record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_loyalty_program - Writes the program to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_loyalty_program(stub shim.ChaincodeStubInterface, v Loyalty_Program) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting program record: %s", err); return errors.New("Error converting program record") }
+
+	err = stub.PutState(LOYALTY_PROGRAM_PREFIX + v.ProgramID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing program record: %s", err); return errors.New("Error storing program record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_exchange_rate - Gets the rate from one program into another.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_exchange_rate(stub shim.ChaincodeStubInterface, from string, to string) (Exchange_Rate, error) {
+
+	var v Exchange_Rate
+
+	bytes, err := stub.GetState(EXCHANGE_RATE_PREFIX + from + "_" + to)
+	if err != nil { return v, errors.New("Unable to get exchange rate " + from + " to " + to) }
+	if bytes == nil { return v, errors.New("No exchange rate from " + from + " to " + to) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("Corrupt exchange rate " + from + " to " + to) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_exchange_rate - Writes the rate to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_exchange_rate(stub shim.ChaincodeStubInterface, v Exchange_Rate) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting exchange rate: %s", err); return errors.New("Error converting exchange rate") }
+
+	err = stub.PutState(EXCHANGE_RATE_PREFIX + v.FromProgram + "_" + v.ToProgram, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing exchange rate: %s", err); return errors.New("Error storing exchange rate") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 owns_program - true if the caller runs the program. The cashback program is run by the regulator and the airline.
+//==============================================================================================================================
+func (t *SimpleChaincode) owns_program(stub shim.ChaincodeStubInterface, v Loyalty_Program) bool {
+
+	if v.ProgramID == CASHBACK_PROGRAM { return t.check_program_caller(stub) == nil }
+
+	caller, _, err := t.get_caller_data(stub)
+	return err == nil && caller == v.Manager
+}
+
+//==============================================================================================================================
+//	 program_balance - The customer's points in a program.
+//==============================================================================================================================
+func (c Customer) program_balance(programID string) int {
+
+	if programID == CASHBACK_PROGRAM { return c.Cashback }
+	return c.Balances[programID]
+}
+
+//==============================================================================================================================
+//	 add_program_points - Adds points, which may be negative, to the customer's balance in a program.
+//==============================================================================================================================
+func (c *Customer) add_program_points(programID string, points int) {
+
+	if programID == CASHBACK_PROGRAM { c.Cashback = c.Cashback + points; return }
+	if c.Balances == nil { c.Balances = map[string]int{} }
+	c.Balances[programID] = c.Balances[programID] + points
+}
+
+//=================================================================================================================================
+//	 create_loyalty_program - Registers a partner's points program, run by manager.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_loyalty_program(stub shim.ChaincodeStubInterface, programID string, name string, partnerID string, manager string) ([]byte, error) {
+
+	err := t.check_admin(stub)
+	if err != nil { return nil, err }
+
+	if programID == CASHBACK_PROGRAM { return nil, errors.New("Invalid programId " + programID) }
+	if manager == "" { return nil, errors.New("Program must have a manager") }
+	if name == "" { name = programID }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	err = config.check_id(ENTITY_PROGRAM, programID, partnerID)
+	if err != nil { return nil, err }
+
+	_, err = t.retrieve_partner(stub, partnerID)
+	if err != nil { return nil, errors.New("Unknown partnerId " + partnerID) }
+
+	_, err = t.retrieve_loyalty_program(stub, programID)
+	if err == nil { return nil, errors.New("Program already exists") }
+
+	err = t.save_changes_loyalty_program(stub, Loyalty_Program{ProgramID: programID, Name: name, PartnerID: partnerID, Manager: manager, Status: true})
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "loyaltyProgramIDs", "programIDs", []string{programID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 award_program_points - Credits points the customer has earned with a partner program.
+//=================================================================================================================================
+func (t *SimpleChaincode) award_program_points(stub shim.ChaincodeStubInterface, programID string, customerID string, points_arg string) ([]byte, error) {
+
+	if programID == CASHBACK_PROGRAM { return nil, errors.New("Cashback points are earned through purchases") }
+
+	p, err := t.retrieve_loyalty_program(stub, programID)
+	if err != nil { return nil, err }
+	if !t.owns_program(stub, p) { return nil, errors.New("Permission Denied. Only the manager of program " + programID + " may award its points") }
+	if !p.Status { return nil, errors.New("Program " + programID + " is not active") }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points <= 0 { return nil, errors.New("Invalid points " + points_arg) }
+
+	v, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+	if !v.Status { return nil, errors.New(" Customer Not Active.") }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, customerID)
+	if err != nil { return nil, err }
+	err = t.check_not_frozen(stub, ENTITY_PARTNER, p.PartnerID)
+	if err != nil { return nil, err }
+
+	v.add_program_points(programID, points)
+
+	_, err = t.save_changes(stub, v)
+	if err != nil { fmt.Printf("AWARD_PROGRAM_POINTS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	return nil, t.record_program_liability(stub, programID, p.PartnerID, Liability_Movement{Issued: points})
+}
+
+//=================================================================================================================================
+//	 propose_exchange_rate - Sets the terms for exchanging between two programs. The proposer approves them for the
+//							 programs they run; the owner of the other program must approve before the rate is used.
+//=================================================================================================================================
+func (t *SimpleChaincode) propose_exchange_rate(stub shim.ChaincodeStubInterface, rate_json string) ([]byte, error) {
+
+	var v Exchange_Rate
+	err := json.Unmarshal([]byte(rate_json), &v)
+	if err != nil { return nil, errors.New("Invalid exchange rate JSON") }
+
+	if v.FromProgram == v.ToProgram { return nil, errors.New("Cannot exchange a program's points for its own") }
+	if v.FromPoints <= 0 || v.ToPoints <= 0 { return nil, errors.New("fromPoints and toPoints must be greater than 0") }
+	if v.FeePercent < 0 || v.FeePercent >= 100 { return nil, errors.New("feePercent must be between 0 and 99") }
+	if v.MinPoints < 0 || v.DailyLimit < 0 { return nil, errors.New("minPoints and dailyLimit cannot be negative") }
+
+	from, err := t.retrieve_loyalty_program(stub, v.FromProgram)
+	if err != nil { return nil, err }
+	to, err := t.retrieve_loyalty_program(stub, v.ToProgram)
+	if err != nil { return nil, err }
+
+	v.ApprovedFrom = t.owns_program(stub, from)
+	v.ApprovedTo = t.owns_program(stub, to)
+	if !v.ApprovedFrom && !v.ApprovedTo { return nil, errors.New("Permission Denied. Only the owners of the two programs may set their exchange rate") }
+
+	v.Status = RATE_PENDING
+	if v.ApprovedFrom && v.ApprovedTo { v.Status = RATE_ACTIVE }
+
+	_, err = t.retrieve_exchange_rate(stub, v.FromProgram, v.ToProgram)
+	exists := err == nil
+
+	err = t.save_changes_exchange_rate(stub, v)
+	if err != nil { return nil, err }
+
+	if exists { return nil, nil }
+	return nil, t.append_ids(stub, "exchangeRateIDs", "rates", []string{v.FromProgram + "_" + v.ToProgram})
+}
+
+//=================================================================================================================================
+//	 approve_exchange_rate - Approves the proposed terms for the programs the caller runs.
+//=================================================================================================================================
+func (t *SimpleChaincode) approve_exchange_rate(stub shim.ChaincodeStubInterface, from_id string, to_id string) ([]byte, error) {
+
+	v, err := t.retrieve_exchange_rate(stub, from_id, to_id)
+	if err != nil { return nil, err }
+
+	from, err := t.retrieve_loyalty_program(stub, from_id)
+	if err != nil { return nil, err }
+	to, err := t.retrieve_loyalty_program(stub, to_id)
+	if err != nil { return nil, err }
+
+	owns_from, owns_to := t.owns_program(stub, from), t.owns_program(stub, to)
+	if !owns_from && !owns_to { return nil, errors.New("Permission Denied. Only the owners of the two programs may approve their exchange rate") }
+
+	v.ApprovedFrom = v.ApprovedFrom || owns_from
+	v.ApprovedTo = v.ApprovedTo || owns_to
+	if v.ApprovedFrom && v.ApprovedTo { v.Status = RATE_ACTIVE }
+
+	err = t.save_changes_exchange_rate(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 exchange_points - Converts a customer's points from one program into another at the active rate. Returns the
+//					   exchange.
+//=================================================================================================================================
+func (t *SimpleChaincode) exchange_points(stub shim.ChaincodeStubInterface, customerID string, from_id string, to_id string, points_arg string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, customerID)
+	if err != nil { return nil, err }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points <= 0 { return nil, errors.New("Invalid points " + points_arg) }
+
+	r, err := t.retrieve_exchange_rate(stub, from_id, to_id)
+	if err != nil { return nil, err }
+	if r.Status != RATE_ACTIVE { return nil, errors.New("The exchange rate from " + from_id + " to " + to_id + " has not been agreed") }
+
+	partners := map[string]string{}
+	for _, id := range []string{from_id, to_id} {
+		p, err := t.retrieve_loyalty_program(stub, id)
+		if err != nil { return nil, err }
+		if !p.Status { return nil, errors.New("Program " + id + " is not active") }
+		err = t.check_not_frozen(stub, ENTITY_PARTNER, p.PartnerID)
+		if err != nil { return nil, err }
+		partners[id] = p.PartnerID
+	}
+
+	if points < r.MinPoints { return nil, errors.New(fmt.Sprintf(" At least %d points must be exchanged at a time.", r.MinPoints)) }
+
+	now := t.get_tx_time(stub)
+	key := EXCHANGED_PREFIX + customerID + "_" + from_id + "_" + to_id
+
+	var counter Exchange_Counter
+	bytes, err := stub.GetState(key)
+	if err != nil { return nil, errors.New("Unable to get exchange counter for " + customerID) }
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &counter)
+		if err != nil { return nil, errors.New("Corrupt exchange counter for " + customerID) }
+	}
+	if counter.Day != now / 86400 { counter = Exchange_Counter{Day: now / 86400} }
+	if r.DailyLimit > 0 && counter.Points + points > r.DailyLimit { return nil, errors.New(fmt.Sprintf(" Daily exchange limit of %d points reached, %d left today.", r.DailyLimit, r.DailyLimit - counter.Points)) }
+
+	fee := points * r.FeePercent / 100
+	received := (points - fee) * r.ToPoints / r.FromPoints
+	if received <= 0 { return nil, errors.New(" Too few points to exchange.") }
+
+	v, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+	if !v.Status { return nil, errors.New(" Customer Not Active.") }
+	if v.program_balance(from_id) < points { return nil, errors.New(" Not enough balance.") }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, customerID)
+	if err != nil { return nil, err }
+
+	v.add_program_points(from_id, -points)
+	v.add_program_points(to_id, received)
+
+	_, err = t.save_changes(stub, v)
+	if err != nil { fmt.Printf("EXCHANGE_POINTS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	accepted_by, issued_by := partners[from_id], partners[to_id]
+	if from_id == CASHBACK_PROGRAM { accepted_by = partners[to_id] }		// Cashback exchanged away is redeemed with the other program,
+	if to_id == CASHBACK_PROGRAM { issued_by = partners[from_id] }			// and cashback received is issued by it
+
+	err = t.record_program_liability(stub, from_id, accepted_by, Liability_Movement{Redeemed: points - fee})
+	if err == nil { err = t.record_program_liability(stub, from_id, partners[from_id], Liability_Movement{Fees: fee}) }
+	if err == nil { err = t.record_program_liability(stub, to_id, issued_by, Liability_Movement{Issued: received}) }
+	if err != nil { return nil, err }
+
+	counter.Points = counter.Points + points
+	bytes, err = json.Marshal(counter)
+	if err != nil { return nil, errors.New("Error converting exchange counter") }
+
+	err = stub.PutState(key, bytes)
+	if err != nil { return nil, errors.New("Error storing exchange counter") }
+
+	ids, err := t.retrieve_ids(stub, "exchangeIDs", "exchangeIDs")
+	if err != nil { return nil, err }
+
+	e := Exchange{ExchangeID: fmt.Sprintf("EX%08d", len(ids) + 1), CustomerID: customerID, FromProgram: from_id, ToProgram: to_id, Points: points, Fee: fee, Received: received, Timestamp: now, TxID: stub.GetTxID()}
+
+	bytes, err = json.Marshal(e)
+	if err != nil { return nil, errors.New("Error converting exchange record") }
+
+	err = stub.PutState(EXCHANGE_PREFIX + e.ExchangeID, bytes)
+	if err != nil { return nil, errors.New("Error storing exchange record") }
+
+	err = t.append_ids(stub, "exchangeIDs", "exchangeIDs", []string{e.ExchangeID})
+	if err != nil { return nil, err }
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 get_exchange_rates - Returns every program and every exchange rate between them.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_exchange_rates(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	ids, err := t.retrieve_ids(stub, "loyaltyProgramIDs", "programIDs")
+	if err != nil { return nil, err }
+
+	programs := []Loyalty_Program{}
+	for _, id := range append([]string{CASHBACK_PROGRAM}, ids...) {
+		p, err := t.retrieve_loyalty_program(stub, id)
+		if err != nil { return nil, err }
+		p.Manager = ""
+		programs = append(programs, p)
+	}
+
+	keys, err := t.retrieve_ids(stub, "exchangeRateIDs", "rates")
+	if err != nil { return nil, err }
+
+	rates := []Exchange_Rate{}
+	for _, key := range keys {
+		bytes, err := stub.GetState(EXCHANGE_RATE_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Unable to get exchange rate " + key) }
+
+		var r Exchange_Rate
+		err = json.Unmarshal(bytes, &r)
+		if err != nil { return nil, errors.New("Corrupt exchange rate " + key) }
+		rates = append(rates, r)
+	}
+
+	return json.Marshal(struct {
+		Programs		[]Loyalty_Program	`json:"programs"`
+		Rates			[]Exchange_Rate		`json:"rates"`
+	}{programs, rates})
+}
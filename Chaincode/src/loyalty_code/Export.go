@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Export format - Bump EXPORT_VERSION whenever the shape of an exported record changes so an older snapshot is
+//					 rejected rather than restored into the wrong structs.
+//==============================================================================================================================
+const   EXPORT_VERSION			=  1
+const   EXPORT_DEFAULT_PAGE		=  100
+const   EXPORT_MAX_PAGE			=  500
+
+const   IMPORT_PROGRESS_KEY		=  "importProgress"
+
+//==============================================================================================================================
+//	Export Section - One kind of record in the snapshot. Records are listed through the ID holder at HolderKey, or
+//					 for a Ranged section by a range query over Prefix, and stored under Prefix followed by the ID;
+//					 a section with a Key is the single record stored there. A ListOnly section shares another
+//					 section's holder, so import leaves the holder alone. A section with no prefix names the field
+//					 of the record that must equal its ID in IDField. A NoImport section is exported but never
+//					 restored.
+//==============================================================================================================================
+type Export_Section struct {
+	Type		string
+	HolderKey	string
+	Field		string
+	Prefix		string
+	Key			string
+	IDField		string
+	ListOnly	bool
+	Ranged		bool
+	NoImport	bool
+}
+
+var export_sections = []Export_Section{
+	{Type: "program",	Key: "programConfig"},
+	{Type: "admins",	Key: "adminIDs"},
+	{Type: "partner",	HolderKey: "partnerIDs",	Field: "partnerIDs",	IDField: "partnerId"},
+	{Type: "customer",	HolderKey: "customerIDs",	Field: "customers",	IDField: "customerID"},
+	{Type: "pos",		HolderKey: "posIDs",		Field: "posIDs",	IDField: "posId"},
+	{Type: "merchant",	HolderKey: "merchantIDs",	Field: "merchantIDs",	Prefix: MERCHANT_PREFIX},
+	{Type: "category",	HolderKey: "categoryIDs",	Field: "categoryIDs",	Prefix: CATEGORY_PREFIX},
+	{Type: "item",		HolderKey: "itemIDs",		Field: "itemIDIDs",	IDField: "itemId"},
+	{Type: "campaign",	HolderKey: "campaignIDs",	Field: "campaignIDs",	Prefix: CAMPAIGN_PREFIX},
+	{Type: "earn_rule",	HolderKey: "earnRuleIDs",	Field: "ruleIDs",	Prefix: EARN_RULE_PREFIX},
+	{Type: "frequency",	HolderKey: "frequencyIDs",	Field: "programIDs",	Prefix: FREQUENCY_PREFIX},
+	{Type: "loyalty_program",	HolderKey: "loyaltyProgramIDs",	Field: "programIDs",	Prefix: LOYALTY_PROGRAM_PREFIX},
+	{Type: "voucher",	HolderKey: "voucherIDs",	Field: "voucherIDs",	Prefix: VOUCHER_PREFIX},
+	{Type: "referral",	HolderKey: "referralIDs",	Field: "referees",	Prefix: REFERRAL_PREFIX},
+	{Type: "pool",		HolderKey: "poolIDs",		Field: "poolIDs",	Prefix: POOL_PREFIX},
+	{Type: "pool_journal",	HolderKey: "poolIDs",	Field: "poolIDs",	Prefix: JOURNAL_PREFIX + POOL_PREFIX,	ListOnly: true},
+	{Type: "gift",		HolderKey: "giftIDs",		Field: "giftIDs",	Prefix: GIFT_PREFIX},
+	{Type: "charity",	HolderKey: "charityIDs",	Field: "charityIDs",	Prefix: CHARITY_PREFIX},
+	{Type: "donation",	HolderKey: "donationIDs",	Field: "donationIDs",	Prefix: DONATION_PREFIX},
+	{Type: "charity_auth",	Prefix: CHARITY_AUTH_PREFIX,	Ranged: true},
+	{Type: "settlement",	HolderKey: "settlementAccounts",	Field: "accounts",	Prefix: SETTLEMENT_PREFIX},
+	{Type: "float",		HolderKey: "floatIDs",		Field: "partnerIDs",	Prefix: FLOAT_PREFIX},
+	{Type: "float_journal",	HolderKey: "floatJournalIDs",	Field: "ledgers",	Prefix: JOURNAL_PREFIX + FLOAT_PREFIX},
+	{Type: "float_purchase",	HolderKey: "floatPurchaseIDs",	Field: "purchaseIDs",	Prefix: FLOAT_PURCHASE_PREFIX},
+	{Type: "exchange_rate",	HolderKey: "exchangeRateIDs",	Field: "rates",	Prefix: EXCHANGE_RATE_PREFIX},
+	{Type: "exchange",	HolderKey: "exchangeIDs",	Field: "exchangeIDs",	Prefix: EXCHANGE_PREFIX},
+	{Type: "fee_schedule",	HolderKey: "feeScheduleIDs",	Field: "partnerIDs",	Prefix: FEE_SCHEDULE_PREFIX},
+	{Type: "fees",		HolderKey: "feeLedgerIDs",	Field: "ledgers",	Prefix: FEES_PREFIX},
+	{Type: "merchant_sales",	HolderKey: "merchantSalesIDs",	Field: "sales",	Prefix: MERCHANT_SALES_PREFIX},
+	{Type: "freeze",	HolderKey: "freezeIDs",		Field: "freezes",	Prefix: FREEZE_PREFIX},
+	{Type: "freeze_action",	HolderKey: "freezeActionIDs",	Field: "actionIDs",	Prefix: FREEZE_ACTION_PREFIX},
+	{Type: "liability",	HolderKey: "liabilityIDs",	Field: "ledgers",	Prefix: LIABILITY_PREFIX},
+	{Type: "audit",		Prefix: AUDIT_PREFIX,	Ranged: true,	NoImport: true},
+	{Type: "statement",	HolderKey: "statementIDs",	Field: "statementIDs",	Prefix: STATEMENT_PREFIX},
+	{Type: "voucher_journal",	HolderKey: "voucherIDs",	Field: "voucherIDs",	Prefix: JOURNAL_PREFIX + VOUCHER_PREFIX,	ListOnly: true},
+}
+
+//==============================================================================================================================
+//	Export Page - A page of the snapshot. Checksum is the SHA-256 of the records on the page and is checked again on
+//				  import, so a page that was edited or truncated in transit is refused.
+//==============================================================================================================================
+type Export_Page struct {
+	Version			int				`json:"version"`
+	Page			int				`json:"page"`
+	PageSize		int				`json:"pageSize"`
+	TotalRecords	int				`json:"totalRecords"`
+	NextPage		int				`json:"nextPage"`
+	Records			[]Export_Record	`json:"records"`
+	Checksum		string			`json:"checksum"`
+	Snapshot		string			`json:"snapshot,omitempty"`
+}
+
+//==============================================================================================================================
+//	Import Progress - How far a snapshot has been restored, stored under importProgress. Pages must be imported in
+//					  order, and Chain is export_chain over every record imported so far, so a page that is skipped
+//					  or swapped for one from another snapshot fails the check on the last page.
+//
+//					  Imported lists the records written by the pages so far and Replaced holds what single records,
+//					  such as the program config, held before the import, so abort_import can undo an import that
+//					  cannot be finished.
+//==============================================================================================================================
+type Import_Progress struct {
+	NextPage		int							`json:"nextPage"`
+	PageSize		int							`json:"pageSize"`
+	TotalRecords	int							`json:"totalRecords"`
+	Records			int							`json:"records"`
+	Chain			string						`json:"chain"`
+	Imported		[]Import_Ref				`json:"imported,omitempty"`
+	Replaced		map[string]json.RawMessage	`json:"replaced,omitempty"`
+}
+
+type Import_Ref struct {
+	Type		string	`json:"type"`
+	ID			string	`json:"id"`
+}
+
+type Export_Record struct {
+	Type		string			`json:"type"`
+	ID			string			`json:"id"`
+	Data		json.RawMessage	`json:"data"`
+}
+
+type export_ref struct {
+	section		Export_Section
+	id			string
+}
+
+//==============================================================================================================================
+//	 export_checksum - SHA-256 over the type, id and data of each record in order.
+//==============================================================================================================================
+func export_checksum(records []Export_Record) string {
+
+	h := sha256.New()
+	for _, r := range records {
+		h.Write([]byte(r.Type + "\n" + r.ID + "\n"))
+		h.Write(r.Data)
+		h.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//==============================================================================================================================
+//	 export_chain - Extends the snapshot digest chain over records. The last page of an export carries the chain over
+//					every record in the snapshot as Snapshot.
+//==============================================================================================================================
+func export_chain(chain string, records []Export_Record) string {
+
+	for _, r := range records {
+		h := sha256.New()
+		h.Write([]byte(chain + "\n" + r.Type + "\n" + r.ID + "\n"))
+		h.Write(r.Data)
+		chain = hex.EncodeToString(h.Sum(nil))
+	}
+	return chain
+}
+
+//==============================================================================================================================
+//	 new_export_record - Returns a pointer to the struct records of the section are stored as. The program config starts
+//						 from the defaults so exports taken before a setting existed still import.
+//==============================================================================================================================
+func new_export_record(section_type string) interface{} {
+
+	switch section_type {
+		case "program":			c := default_program_config(); return &c
+		case "admins":			return &struct{ Admins []string `json:"admins"` }{}
+		case "partner":			return &Partner{}
+		case "customer":		return &Customer{}
+		case "pos":				return &PoS{}
+		case "merchant":		return &Merchant{}
+		case "category":		return &Category{}
+		case "item":			return &Item{}
+		case "campaign":		return &Campaign{}
+		case "earn_rule":		return &Earn_Rule{}
+		case "frequency":		return &Frequency_Program{}
+		case "loyalty_program":	return &Loyalty_Program{}
+		case "voucher":			return &Voucher{}
+		case "referral":		return &Referral{}
+		case "pool":			return &Pool{}
+		case "gift":			return &Gift{}
+		case "charity":			return &Charity{}
+		case "donation":		return &Donation{}
+		case "charity_auth":	return &Charity_Authorisation{}
+		case "settlement":		return &Settlement_Account{}
+		case "float":			return &Float{}
+		case "float_purchase":	return &Float_Purchase{}
+		case "exchange_rate":	return &Exchange_Rate{}
+		case "exchange":		return &Exchange{}
+		case "fee_schedule":	return &Fee_Schedule{}
+		case "fees":			return &[]Fee_Entry{}
+		case "merchant_sales":	return &Merchant_Sales{}
+		case "freeze":			return &Freeze{}
+		case "freeze_action":	return &Freeze_Action{}
+		case "liability":		return &Liability{}
+		case "audit":			return &Audit_Entry{}
+		case "statement":		return &Statement{}
+		case "pool_journal", "float_journal", "voucher_journal":	return &[]Journal_Entry{}
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 reserved_key - true if id cannot be stored as a bare key because the chaincode uses it, or a key like it, for
+//					something else. Every key prefix ends in an underscore.
+//==============================================================================================================================
+func reserved_key(id string) bool {
+
+	if strings.Contains(id, "_") || id == "programConfig" || id == IMPORT_PROGRESS_KEY { return true }
+	for _, section := range export_sections {
+		if id == section.HolderKey || id == section.Key { return true }
+	}
+	return false
+}
+
+//==============================================================================================================================
+//	 decode_export_record - Checks the record is a well formed record of its section and returns it re-encoded, so
+//							only fields the chaincode knows are written.
+//==============================================================================================================================
+func decode_export_record(section Export_Section, r Export_Record) ([]byte, error) {
+
+	v := new_export_record(section.Type)
+	if v == nil { return nil, errors.New("Unknown record type " + section.Type) }
+
+	decoder := json.NewDecoder(bytes.NewReader(r.Data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil { return nil, errors.New("Invalid " + section.Type + " " + r.ID + ": " + err.Error()) }
+
+	if section.IDField != "" {
+		var fields map[string]interface{}
+		err = json.Unmarshal(r.Data, &fields)
+		if err != nil || fields[section.IDField] != r.ID { return nil, errors.New(section.Type + " " + r.ID + " does not carry its own id") }
+		if reserved_key(r.ID) { return nil, errors.New(section.Type + " id " + r.ID + " is reserved") }
+	}
+
+	return json.Marshal(v)
+}
+
+//==============================================================================================================================
+//	 list_export_refs - Lists every record in the snapshot in export order.
+//==============================================================================================================================
+func (t *SimpleChaincode) list_export_refs(stub shim.ChaincodeStubInterface) ([]export_ref, error) {
+
+	var refs []export_ref
+
+	for _, section := range export_sections {
+		if section.Key != "" {
+			bytes, err := stub.GetState(section.Key)
+			if err != nil { return nil, errors.New("Unable to get " + section.Key) }
+			if bytes != nil { refs = append(refs, export_ref{section, section.Key}) }
+			continue
+		}
+
+		var ids []string
+		var err error
+		if section.Ranged { ids, err = t.retrieve_range_ids(stub, section.Prefix) } else { ids, err = t.retrieve_ids(stub, section.HolderKey, section.Field) }
+		if err != nil { return nil, err }
+		for _, id := range ids {
+			refs = append(refs, export_ref{section, id})
+		}
+	}
+	return refs, nil
+}
+
+//=================================================================================================================================
+//	 export_ledger - Returns one page of the snapshot. Call with page 0 and follow NextPage until it is -1.
+//=================================================================================================================================
+func (t *SimpleChaincode) export_ledger(stub shim.ChaincodeStubInterface, page_arg string, size_arg string) ([]byte, error) {
+
+	err := t.check_admin(stub)
+	if err != nil { return nil, err }
+
+	page, err := strconv.Atoi(page_arg)
+	if err != nil || page < 0 { return nil, errors.New("Invalid page " + page_arg) }
+
+	size := EXPORT_DEFAULT_PAGE
+	if size_arg != "" {
+		size, err = strconv.Atoi(size_arg)
+		if err != nil || size <= 0 || size > EXPORT_MAX_PAGE { return nil, errors.New(fmt.Sprintf("Invalid page size %s, expected 1 to %d", size_arg, EXPORT_MAX_PAGE)) }
+	}
+
+	refs, err := t.list_export_refs(stub)
+	if err != nil { return nil, err }
+
+	result := Export_Page{Version: EXPORT_VERSION, Page: page, PageSize: size, TotalRecords: len(refs), NextPage: -1, Records: []Export_Record{}}
+
+	start := page * size
+	end := start + size
+	if end > len(refs) { end = len(refs) }
+	if end < len(refs) { result.NextPage = page + 1 }
+
+	for r := start; r < end; r++ {
+		bytes, err := stub.GetState(refs[r].section.Prefix + refs[r].id)
+		if err != nil || bytes == nil { return nil, errors.New("Unable to read " + refs[r].section.Type + " " + refs[r].id) }
+		result.Records = append(result.Records, Export_Record{Type: refs[r].section.Type, ID: refs[r].id, Data: bytes})
+	}
+
+	result.Checksum = export_checksum(result.Records)
+
+	if result.NextPage == -1 {														// The last page seals the whole snapshot
+		var all []Export_Record
+		for _, ref := range refs {
+			bytes, err := stub.GetState(ref.section.Prefix + ref.id)
+			if err != nil || bytes == nil { return nil, errors.New("Unable to read " + ref.section.Type + " " + ref.id) }
+			all = append(all, Export_Record{Type: ref.section.Type, ID: ref.id, Data: bytes})
+		}
+		result.Snapshot = export_chain("", all)
+	}
+
+	return json.Marshal(result)
+}
+
+//==============================================================================================================================
+//	 export_section_map - The export sections by type.
+//==============================================================================================================================
+func export_section_map() map[string]Export_Section {
+
+	sections := make(map[string]Export_Section)
+	for _, section := range export_sections {
+		sections[section.Type] = section
+	}
+	return sections
+}
+
+//==============================================================================================================================
+//	 retrieve_import_progress - Gets the progress of the import under way. No import is under way if NextPage is 0.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_import_progress(stub shim.ChaincodeStubInterface) (Import_Progress, error) {
+
+	var progress Import_Progress
+
+	bytes, err := stub.GetState(IMPORT_PROGRESS_KEY)
+	if err != nil { return progress, errors.New("Unable to get " + IMPORT_PROGRESS_KEY) }
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &progress)
+		if err != nil { return progress, errors.New("Corrupt " + IMPORT_PROGRESS_KEY + " record") }
+	}
+	return progress, nil
+}
+
+func (t *SimpleChaincode) save_import_progress(stub shim.ChaincodeStubInterface, progress Import_Progress) error {
+
+	bytes, err := json.Marshal(progress)
+	if err != nil { return errors.New("Error converting " + IMPORT_PROGRESS_KEY + " record") }
+
+	err = stub.PutState(IMPORT_PROGRESS_KEY, bytes)
+	if err != nil { return errors.New("Error storing " + IMPORT_PROGRESS_KEY + " record") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 import_ledger - Restores one exported page onto this deployment. Pages must be imported in order; page 0 starts
+//					 a new import, once any import that was not finished has been aborted.
+//					 The whole page is checked before anything is written: the version and checksum must match, every
+//					 record must decode as its section's struct and none of the listed records may already exist. The
+//					 last page must carry the snapshot digest of every page imported. The audit log is not restored;
+//					 it belongs to the network that recorded it.
+//=================================================================================================================================
+func (t *SimpleChaincode) import_ledger(stub shim.ChaincodeStubInterface, page_json string) ([]byte, error) {
+
+	err := t.check_admin(stub)
+	if err != nil { return nil, err }
+
+	var p Export_Page
+	err = json.Unmarshal([]byte(page_json), &p)
+	if err != nil { return nil, errors.New("Invalid export page") }
+
+	if p.Version != EXPORT_VERSION { return nil, errors.New(fmt.Sprintf("Unsupported export version %d, expected %d", p.Version, EXPORT_VERSION)) }
+	if export_checksum(p.Records) != p.Checksum { return nil, errors.New("Checksum mismatch, export page has been altered") }
+
+	progress, err := t.retrieve_import_progress(stub)
+	if err != nil { return nil, err }
+
+	if p.Page != progress.NextPage {
+		if p.Page == 0 { return nil, errors.New(fmt.Sprintf("An import is under way at page %d, finish it or call abort_import", progress.NextPage)) }
+		return nil, errors.New(fmt.Sprintf("Expected export page %d, got page %d", progress.NextPage, p.Page))
+	}
+	if p.Page == 0 {
+		progress = Import_Progress{PageSize: p.PageSize, TotalRecords: p.TotalRecords, Replaced: map[string]json.RawMessage{}}
+	} else if p.PageSize != progress.PageSize || p.TotalRecords != progress.TotalRecords {
+		return nil, errors.New("Export page belongs to a different snapshot")
+	}
+
+	sections := export_section_map()
+
+	data := make([][]byte, len(p.Records))
+
+	for n, r := range p.Records {
+		section, ok := sections[r.Type]
+		if !ok { return nil, errors.New("Unknown record type " + r.Type) }
+		if r.ID == "" { return nil, errors.New("Record of type " + r.Type + " has no id") }
+		if section.NoImport { continue }
+
+		data[n], err = decode_export_record(section, r)
+		if err != nil { return nil, err }
+
+		if section.Key != "" {														// Single records such as configuration replace whatever Init wrote
+			if r.ID != section.Key { return nil, errors.New(r.Type + " must have id " + section.Key) }
+			if r.Type == "program" {
+				var config Program_Config
+				err = json.Unmarshal(data[n], &config)
+				if err != nil { return nil, errors.New("Invalid program config") }
+				err = config.validate()
+				if err != nil { return nil, errors.New("Invalid program config: " + err.Error()) }
+			}
+			continue
+		}
+
+		record, err := stub.GetState(section.Prefix + r.ID)
+		if err != nil { return nil, errors.New("Unable to check " + r.ID) }
+		if record != nil { return nil, errors.New(r.Type + " " + r.ID + " already exists") }
+	}
+
+	progress.Chain = export_chain(progress.Chain, p.Records)
+	progress.Records = progress.Records + len(p.Records)
+	progress.NextPage = p.NextPage
+
+	if p.NextPage == -1 {
+		if progress.Records != progress.TotalRecords || p.Snapshot != progress.Chain { return nil, errors.New("Snapshot digest mismatch, a page is missing or from another export, call abort_import to undo the pages imported") }
+	}
+
+	ids := make(map[string][]string)
+
+	for n, r := range p.Records {
+		section := sections[r.Type]
+		if section.NoImport { continue }
+
+		key := section.Prefix + r.ID
+		if section.Key != "" {
+			key = section.Key
+			if _, ok := progress.Replaced[key]; !ok {
+				previous, err := stub.GetState(key)
+				if err != nil { return nil, errors.New("Unable to get " + key) }
+				progress.Replaced[key] = previous
+			}
+		}
+
+		err = stub.PutState(key, data[n])
+		if err != nil { fmt.Printf("IMPORT_LEDGER: Error storing record: %s", err); return nil, errors.New("Error storing " + r.Type + " " + r.ID) }
+		if section.HolderKey != "" && !section.ListOnly { ids[r.Type] = append(ids[r.Type], r.ID) }
+		progress.Imported = append(progress.Imported, Import_Ref{r.Type, r.ID})
+	}
+
+	for _, section := range export_sections {
+		if len(ids[section.Type]) == 0 { continue }
+		err = t.append_ids(stub, section.HolderKey, section.Field, ids[section.Type])
+		if err != nil { return nil, err }
+	}
+
+	if p.NextPage == -1 { progress = Import_Progress{} }								// Ready for the next snapshot
+
+	err = t.save_import_progress(stub, progress)
+	if err != nil { return nil, err }
+
+	return []byte(strconv.Itoa(len(p.Records))), nil
+}
+
+//=================================================================================================================================
+//	 abort_import - Undoes an import that cannot be finished: deletes every record its pages wrote, takes them out of
+//					their ID holders and puts back the single records they replaced. Returns the number of records
+//					removed.
+//=================================================================================================================================
+func (t *SimpleChaincode) abort_import(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	progress, err := t.retrieve_import_progress(stub)
+	if err != nil { return nil, err }
+	if progress.NextPage == 0 { return nil, errors.New("No import is under way") }
+
+	sections := export_section_map()
+	ids := make(map[string][]string)
+	removed := 0
+
+	for _, r := range progress.Imported {
+		section := sections[r.Type]
+		if section.Key != "" { continue }
+
+		err = stub.DelState(section.Prefix + r.ID)
+		if err != nil { return nil, errors.New("Error deleting " + r.Type + " " + r.ID) }
+		if section.HolderKey != "" && !section.ListOnly { ids[r.Type] = append(ids[r.Type], r.ID) }
+		removed++
+	}
+
+	for _, section := range export_sections {
+		if len(ids[section.Type]) == 0 { continue }
+		err = t.remove_ids(stub, section.HolderKey, section.Field, ids[section.Type])
+		if err != nil { return nil, err }
+	}
+
+	for key, previous := range progress.Replaced {
+		if previous == nil || string(previous) == "null" { err = stub.DelState(key) } else { err = stub.PutState(key, previous) }
+		if err != nil { return nil, errors.New("Error restoring " + key) }
+	}
+
+	err = t.save_import_progress(stub, Import_Progress{})
+	if err != nil { return nil, err }
+
+	return []byte(strconv.Itoa(removed)), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Fee types - What a fee is charged for and how it is worked out.
+//==============================================================================================================================
+const   FEE_ISSUE				=  "issue"
+const   FEE_REDEMPTION			=  "redemption"
+
+const   FEE_PERCENTAGE			=  "percentage"
+const   FEE_FLAT				=  "flat"
+const   FEE_TIERED				=  "tiered"
+
+const   FEE_SCHEDULE_PREFIX		=  "feeschedule_"
+const   FEES_PREFIX				=  "fees_"
+
+//==============================================================================================================================
+//	Fee Schedule - What the issuer charges a partner for the points it issues and the redemptions it accepts. A rule
+//				   left empty charges nothing.
+//==============================================================================================================================
+type Fee_Schedule struct {
+	PartnerID		string		`json:"partnerId"`
+	Issue			Fee_Rule	`json:"issue"`
+	Redemption		Fee_Rule	`json:"redemption"`
+	UpdatedBy		string		`json:"updatedBy"`
+	UpdatedAt		int64		`json:"updatedAt"`
+}
+
+//==============================================================================================================================
+//	Fee Rule - A percentage rule charges Percent of the value of the points, a flat rule charges Flat per transaction
+//			   and a tiered rule charges the Percent of the highest tier whose MinVolume the partner's points of that
+//			   kind so far this month have reached.
+//==============================================================================================================================
+type Fee_Rule struct {
+	Type			string		`json:"type"`
+	Percent			int			`json:"percent,omitempty"`
+	Flat			int			`json:"flat,omitempty"`
+	Tiers			[]Fee_Tier	`json:"tiers,omitempty"`
+}
+
+type Fee_Tier struct {
+	MinVolume		int		`json:"minVolume"`
+	Percent			int		`json:"percent"`
+}
+
+//==============================================================================================================================
+//	Fee Entry - A fee charged on one purchase. Value is the value of the points the fee was worked out on.
+//==============================================================================================================================
+type Fee_Entry struct {
+	TxID			string	`json:"txId"`
+	Timestamp		int64	`json:"timestamp"`
+	PartnerID		string	`json:"partnerId"`
+	Type			string	`json:"type"`
+	PoSID			string	`json:"posId"`
+	Points			int		`json:"points"`
+	Value			int		`json:"value"`
+	Fee				int		`json:"fee"`
+}
+
+//==============================================================================================================================
+//	Fee Statement - A partner's fees for one month, given as YYYY-MM.
+//==============================================================================================================================
+type Fee_Statement struct {
+	PartnerID		string			`json:"partnerId"`
+	Period			string			`json:"period"`
+	Schedule		*Fee_Schedule	`json:"schedule"`
+	IssueFees		int				`json:"issueFees"`
+	RedemptionFees	int				`json:"redemptionFees"`
+	Total			int				`json:"total"`
+	Entries			[]Fee_Entry		`json:"entries"`
+}
+
+//==============================================================================================================================
+//	Fee Holders - Define the structures that hold the partnerIDs with a fee schedule and the <partnerID>_<period> keys
+//				  of the monthly fee ledgers.
+//==============================================================================================================================
+type FeeSchedule_Holder struct {
+	PartnerIDs		[]string	`json:"partnerIDs"`
+}
+
+type FeeLedger_Holder struct {
+	Ledgers			[]string	`json:"ledgers"`
+}
+
+//==============================================================================================================================
+//	 validate - Checks a fee rule is complete.
+//==============================================================================================================================
+func (r Fee_Rule) validate() error {
+
+	if r.Type == "" { return nil }
+	if r.Percent < 0 || r.Percent > 100 { return errors.New("percent must be between 0 and 100") }
+	if r.Flat < 0 { return errors.New("flat cannot be negative") }
+
+	if r.Type == FEE_TIERED {
+		if len(r.Tiers) == 0 { return errors.New("A tiered fee must have at least one tier") }
+		for n, tier := range r.Tiers {
+			if tier.Percent < 0 || tier.Percent > 100 { return errors.New("Tier percent must be between 0 and 100") }
+			if n == 0 && tier.MinVolume != 0 { return errors.New("The first tier must start at minVolume 0") }
+			if n > 0 && tier.MinVolume <= r.Tiers[n-1].MinVolume { return errors.New("Tiers must be in ascending order of minVolume") }
+		}
+	} else if r.Type != FEE_PERCENTAGE && r.Type != FEE_FLAT {
+		return errors.New("Unknown fee type " + r.Type + ", expected percentage, flat or tiered")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 fee - Works out the fee on points worth value, given the partner's volume so far this month.
+//==============================================================================================================================
+func (r Fee_Rule) fee(value int, volume int) int {
+
+	if r.Type == FEE_FLAT { return r.Flat }
+	if r.Type == FEE_PERCENTAGE { return value * r.Percent / 100 }
+	if r.Type != FEE_TIERED { return 0 }
+
+	percent := 0
+	for _, tier := range r.Tiers {
+		if volume >= tier.MinVolume { percent = tier.Percent }
+	}
+	return value * percent / 100
+}
+
+//==============================================================================================================================
+//	 retrieve_fee_schedule - Gets the partner's fee schedule, or nil if it has none.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_fee_schedule(stub shim.ChaincodeStubInterface, partnerID string) (*Fee_Schedule, error) {
+
+	bytes, err := stub.GetState(FEE_SCHEDULE_PREFIX + partnerID)
+	if err != nil { return nil, errors.New("Unable to get fee schedule for " + partnerID) }
+	if bytes == nil { return nil, nil }
+
+	var v Fee_Schedule
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return nil, errors.New("Corrupt fee schedule for " + partnerID) }
+	return &v, nil
+}
+
+//==============================================================================================================================
+//	 retrieve_fee_entries - Gets the fees charged to the partner in a period.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_fee_entries(stub shim.ChaincodeStubInterface, partnerID string, period string) ([]Fee_Entry, error) {
+
+	entries := []Fee_Entry{}
+
+	bytes, err := stub.GetState(FEES_PREFIX + partnerID + "_" + period)
+	if err != nil { return nil, errors.New("Unable to get fees for " + partnerID) }
+	if bytes == nil { return entries, nil }
+
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil { return nil, errors.New("Corrupt fees for " + partnerID) }
+	return entries, nil
+}
+
+//=================================================================================================================================
+//	 set_fee_schedule - Sets the fees charged to a partner.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_fee_schedule(stub shim.ChaincodeStubInterface, schedule_json string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	var v Fee_Schedule
+	err = json.Unmarshal([]byte(schedule_json), &v)
+	if err != nil { return nil, errors.New("Invalid fee schedule JSON") }
+
+	_, err = t.retrieve_partner(stub, v.PartnerID)
+	if err != nil { return nil, errors.New("Unknown partnerId " + v.PartnerID) }
+
+	err = v.Issue.validate()
+	if err != nil { return nil, errors.New("Invalid issue fee: " + err.Error()) }
+	err = v.Redemption.validate()
+	if err != nil { return nil, errors.New("Invalid redemption fee: " + err.Error()) }
+
+	current, err := t.retrieve_fee_schedule(stub, v.PartnerID)
+	if err != nil { return nil, err }
+
+	v.UpdatedBy = caller
+	v.UpdatedAt = t.get_tx_time(stub)
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return nil, errors.New("Error converting fee schedule") }
+
+	err = stub.PutState(FEE_SCHEDULE_PREFIX + v.PartnerID, bytes)
+	if err != nil { return nil, errors.New("Error storing fee schedule") }
+
+	if current != nil { return nil, nil }
+	return nil, t.append_ids(stub, "feeScheduleIDs", "partnerIDs", []string{v.PartnerID})
+}
+
+//==============================================================================================================================
+//	 charge_fee - Works out the fee on points of kind fee_type issued or redeemed at p and worth value, and adds it to
+//				  the partner's fees for the month. The fee is owed to the issuer through the partners' settlement
+//				  account. The issuer does not charge itself.
+//==============================================================================================================================
+func (t *SimpleChaincode) charge_fee(stub shim.ChaincodeStubInterface, p PoS, fee_type string, points int, value int) error {
+
+	if p.PartnerID == "" || points <= 0 { return nil }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return err }
+	if p.PartnerID == config.IssuerPartnerID { return nil }
+
+	schedule, err := t.retrieve_fee_schedule(stub, p.PartnerID)
+	if err != nil || schedule == nil { return err }
+
+	rule := schedule.Issue
+	if fee_type == FEE_REDEMPTION { rule = schedule.Redemption }
+	if rule.Type == "" { return nil }
+
+	now := t.get_tx_time(stub)
+	period := time.Unix(now, 0).UTC().Format("2006-01")
+
+	entries, err := t.retrieve_fee_entries(stub, p.PartnerID, period)
+	if err != nil { return err }
+
+	volume := 0
+	for _, e := range entries {
+		if e.Type == fee_type { volume = volume + e.Points }
+	}
+
+	e := Fee_Entry{TxID: stub.GetTxID(), Timestamp: now, PartnerID: p.PartnerID, Type: fee_type, PoSID: p.PoSID, Points: points, Value: value, Fee: rule.fee(value, volume)}
+
+	bytes, err := json.Marshal(append(entries, e))
+	if err != nil { return errors.New("Error converting fees for " + p.PartnerID) }
+
+	err = stub.PutState(FEES_PREFIX + p.PartnerID + "_" + period, bytes)
+	if err != nil { return errors.New("Error storing fees for " + p.PartnerID) }
+
+	if len(entries) == 0 {
+		err = t.append_ids(stub, "feeLedgerIDs", "ledgers", []string{p.PartnerID + "_" + period})
+		if err != nil { return err }
+	}
+
+	if config.IssuerPartnerID == "" || e.Fee == 0 { return nil }
+	return t.post_settlement(stub, Settlement_Entry{Type: SETTLE_FEE, PayerID: p.PartnerID, PayeeID: config.IssuerPartnerID, PoSID: p.PoSID, Points: points, Amount: e.Fee})
+}
+
+//=================================================================================================================================
+//	 get_fee_statement - Returns a partner's fees for a month given as YYYY-MM. Only the regulator and the partner
+//						 itself may see them.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_fee_statement(stub shim.ChaincodeStubInterface, partnerID string, period string) ([]byte, error) {
+
+	err := t.check_partner_self(stub, partnerID)
+	if err != nil { return nil, err }
+
+	_, err = time.Parse("2006-01", period)
+	if err != nil { return nil, errors.New("Invalid period " + period + ", expected YYYY-MM") }
+
+	v := Fee_Statement{PartnerID: partnerID, Period: period}
+
+	v.Schedule, err = t.retrieve_fee_schedule(stub, partnerID)
+	if err != nil { return nil, err }
+
+	v.Entries, err = t.retrieve_fee_entries(stub, partnerID, period)
+	if err != nil { return nil, err }
+
+	for _, e := range v.Entries {
+		if e.Type == FEE_ISSUE { v.IssueFees = v.IssueFees + e.Fee } else { v.RedemptionFees = v.RedemptionFees + e.Fee }
+	}
+	v.Total = v.IssueFees + v.RedemptionFees
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("GET_FEE_STATEMENT: Error converting statement: %s", err); return nil, errors.New("Error converting fee statement") }
+	return bytes, nil
+}
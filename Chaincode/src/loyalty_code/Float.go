@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   FLOAT_PREFIX			=  "float_"
+const   FLOAT_PURCHASE_PREFIX	=  "floatpurchase_"
+
+const   LOW_FLOAT_EVENT			=  "low_float"
+
+//==============================================================================================================================
+//	Float - The points a partner has bought from the issuer and not yet awarded. Every earn at one of the partner's
+//			PoS is paid out of its float, and a partner with too few points left cannot award any. LowThreshold is the
+//			balance below which a low_float event warns the partner, 0 for no warning. Purchases and awards are
+//			journaled by month, given as YYYY-MM, under journal_float_<partnerID>_<period>.
+//==============================================================================================================================
+type Float struct {
+	PartnerID		string	`json:"partnerId"`
+	Balance			int		`json:"balance"`
+	LowThreshold	int		`json:"lowThreshold"`
+	Purchased		int		`json:"purchased"`
+	Awarded			int		`json:"awarded"`
+}
+
+//==============================================================================================================================
+//	Float Purchase - Points credited to a partner's float by the issuer. Amount is what the partner owes for them.
+//==============================================================================================================================
+type Float_Purchase struct {
+	PurchaseID		string	`json:"purchaseId"`
+	PartnerID		string	`json:"partnerId"`
+	Points			int		`json:"points"`
+	Amount			int		`json:"amount"`
+	Reference		string	`json:"reference"`
+	CreditedBy		string	`json:"creditedBy"`
+	Timestamp		int64	`json:"timestamp"`
+	TxID			string	`json:"txId"`
+}
+
+//==============================================================================================================================
+//	Float Holders - Define the structures that hold the partnerIDs with a float, the purchaseIDs of float purchases and
+//					the <partnerID>_<period> keys of the float journals.
+//==============================================================================================================================
+type FloatID_Holder struct {
+	PartnerIDs		[]string	`json:"partnerIDs"`
+}
+
+type FloatPurchaseID_Holder struct {
+	PurchaseIDs		[]string	`json:"purchaseIDs"`
+}
+
+type FloatJournalID_Holder struct {
+	Ledgers			[]string	`json:"ledgers"`
+}
+
+//==============================================================================================================================
+//	 retrieve_float - Gets the partner's float, or an empty one if it has never bought points.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_float(stub shim.ChaincodeStubInterface, partnerID string) (Float, bool, error) {
+
+	v := Float{PartnerID: partnerID}
+
+	bytes, err := stub.GetState(FLOAT_PREFIX + partnerID)
+	if err != nil { return v, false, errors.New("Unable to get float for " + partnerID) }
+	if bytes == nil { return v, false, nil }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, false, errors.New("Corrupt float for " + partnerID) }
+	return v, true, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_float - Writes the float to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_float(stub shim.ChaincodeStubInterface, v Float) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting float record: %s", err); return errors.New("Error converting float record") }
+
+	err = stub.PutState(FLOAT_PREFIX + v.PartnerID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing float record: %s", err); return errors.New("Error storing float record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 append_float_journal - Adds the entry to the partner's float journal for the current month.
+//==============================================================================================================================
+func (t *SimpleChaincode) append_float_journal(stub shim.ChaincodeStubInterface, partnerID string, entry Journal_Entry) error {
+
+	key := partnerID + "_" + time.Unix(t.get_tx_time(stub), 0).UTC().Format("2006-01")
+
+	bytes, err := stub.GetState(JOURNAL_PREFIX + FLOAT_PREFIX + key)
+	if err != nil { return errors.New("Unable to get float journal for " + partnerID) }
+
+	err = t.append_journal(stub, FLOAT_PREFIX + key, entry)
+	if err != nil { return err }
+
+	if bytes == nil { return t.append_ids(stub, "floatJournalIDs", "ledgers", []string{key}) }
+	return nil
+}
+
+//==============================================================================================================================
+//	 uses_float - true if earns at p must be paid from its partner's float, which is the case for every partner but
+//				  the issuer while partner floats are enabled.
+//==============================================================================================================================
+func (c Program_Config) uses_float(p PoS) bool {
+
+	return c.feature_enabled(FEATURE_PARTNER_FLOATS) && c.IssuerPartnerID != "" && p.PartnerID != "" && p.PartnerID != c.IssuerPartnerID
+}
+
+//=================================================================================================================================
+//	 purchase_float - Credits points the partner has bought to its float. The purchase is owed to the issuer through
+//					  the partners' settlement account. Returns the purchaseID.
+//=================================================================================================================================
+func (t *SimpleChaincode) purchase_float(stub shim.ChaincodeStubInterface, partnerID string, points_arg string, reference string) ([]byte, error) {
+
+	err := t.check_program_caller(stub)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+	if config.IssuerPartnerID == "" { return nil, errors.New("The program config has no issuerPartnerId") }
+	if partnerID == config.IssuerPartnerID { return nil, errors.New("The issuer does not need a float") }
+
+	_, err = t.retrieve_partner(stub, partnerID)
+	if err != nil { return nil, errors.New("Unknown partnerId " + partnerID) }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points <= 0 { return nil, errors.New("Invalid points " + points_arg) }
+
+	f, exists, err := t.retrieve_float(stub, partnerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "floatPurchaseIDs", "purchaseIDs")
+	if err != nil { return nil, err }
+
+	v := Float_Purchase{PurchaseID: fmt.Sprintf("FP%08d", len(ids) + 1), PartnerID: partnerID, Points: points, Amount: points * config.PointCost / 100, Reference: reference, CreditedBy: caller, Timestamp: t.get_tx_time(stub), TxID: stub.GetTxID()}
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return nil, errors.New("Error converting float purchase record") }
+
+	err = stub.PutState(FLOAT_PURCHASE_PREFIX + v.PurchaseID, bytes)
+	if err != nil { return nil, errors.New("Error storing float purchase record") }
+
+	err = t.append_ids(stub, "floatPurchaseIDs", "purchaseIDs", []string{v.PurchaseID})
+	if err != nil { return nil, err }
+
+	f.Balance = f.Balance + points
+	f.Purchased = f.Purchased + points
+
+	err = t.save_changes_float(stub, f)
+	if err != nil { return nil, err }
+
+	if !exists {
+		err = t.append_ids(stub, "floatIDs", "partnerIDs", []string{partnerID})
+		if err != nil { return nil, err }
+	}
+
+	err = t.append_float_journal(stub, partnerID, Journal_Entry{Action: "purchase", Points: points, Note: v.PurchaseID})
+	if err != nil { return nil, err }
+
+	err = t.post_settlement(stub, Settlement_Entry{Type: SETTLE_FLOAT, PayerID: partnerID, PayeeID: config.IssuerPartnerID, Points: points, Amount: v.Amount})
+	if err != nil { return nil, err }
+
+	return []byte(v.PurchaseID), nil
+}
+
+//=================================================================================================================================
+//	 set_float_threshold - Sets the balance below which the partner is warned that its float is running low. Only the
+//						   regulator and the partner itself may set it.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_float_threshold(stub shim.ChaincodeStubInterface, partnerID string, points_arg string) ([]byte, error) {
+
+	err := t.check_partner_self(stub, partnerID)
+	if err != nil { return nil, err }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points < 0 { return nil, errors.New("Invalid points " + points_arg) }
+
+	f, exists, err := t.retrieve_float(stub, partnerID)
+	if err != nil { return nil, err }
+	if !exists { return nil, errors.New("Partner " + partnerID + " has no float") }
+
+	f.LowThreshold = points
+
+	err = t.save_changes_float(stub, f)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 draw_float - Pays points awarded to customerID at p out of the partner's float, if it needs one. Raises a
+//				  low_float event when the draw takes the balance below the partner's threshold.
+//==============================================================================================================================
+func (t *SimpleChaincode) draw_float(stub shim.ChaincodeStubInterface, p PoS, customerID string, points int) error {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return err }
+	if !config.uses_float(p) || points <= 0 { return nil }
+
+	f, _, err := t.retrieve_float(stub, p.PartnerID)
+	if err != nil { return err }
+	if f.Balance < points { return errors.New(" Partner " + p.PartnerID + " does not have enough points in its float.") }
+
+	was_low := f.Balance < f.LowThreshold
+
+	f.Balance = f.Balance - points
+	f.Awarded = f.Awarded + points
+
+	err = t.save_changes_float(stub, f)
+	if err != nil { return err }
+
+	err = t.append_float_journal(stub, p.PartnerID, Journal_Entry{Action: "award", CustomerID: customerID, Points: points, Note: p.PoSID})
+	if err != nil { return err }
+
+	if was_low || f.Balance >= f.LowThreshold { return nil }
+
+	bytes, err := json.Marshal(f)
+	if err != nil { return errors.New("Error converting float record") }
+	return stub.SetEvent(LOW_FLOAT_EVENT, bytes)
+}
+
+//=================================================================================================================================
+//	 get_float_statement - Returns the partner's float with its purchases and its journal for a month given as YYYY-MM.
+//						   Only the regulator and the partner itself may see them.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_float_statement(stub shim.ChaincodeStubInterface, partnerID string, period string) ([]byte, error) {
+
+	err := t.check_partner_self(stub, partnerID)
+	if err != nil { return nil, err }
+
+	_, err = time.Parse("2006-01", period)
+	if err != nil { return nil, errors.New("Invalid period " + period + ", expected YYYY-MM") }
+
+	f, _, err := t.retrieve_float(stub, partnerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "floatPurchaseIDs", "purchaseIDs")
+	if err != nil { return nil, err }
+
+	purchases := []Float_Purchase{}
+	for _, id := range ids {
+		bytes, err := stub.GetState(FLOAT_PURCHASE_PREFIX + id)
+		if err != nil || bytes == nil { return nil, errors.New("Failed to retrieve Float Purchase " + id) }
+
+		var v Float_Purchase
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt Float Purchase " + id) }
+		if v.PartnerID == partnerID && time.Unix(v.Timestamp, 0).UTC().Format("2006-01") == period { purchases = append(purchases, v) }
+	}
+
+	journal, err := t.retrieve_journal(stub, FLOAT_PREFIX + partnerID + "_" + period)
+	if err != nil { return nil, err }
+
+	return json.Marshal(struct {
+		Float			Float				`json:"float"`
+		Purchases		[]Float_Purchase	`json:"purchases"`
+		Journal			[]Journal_Entry		`json:"journal"`
+	}{f, purchases, journal})
+}
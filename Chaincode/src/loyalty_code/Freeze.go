@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   FREEZE_PREFIX			=  "freeze_"
+const   FREEZE_ACTION_PREFIX	=  "freezeaction_"
+
+const   FREEZE_ACTIVE			=  "active"
+const   FREEZE_LIFTED			=  "lifted"
+
+//==============================================================================================================================
+//	Freeze - A hold the regulator has put on a customer, PoS or partner, stored under freeze_<entityType>_<entityID>.
+//			 While it is active and not past Expiry the entity cannot earn, burn or transfer points. Expiry of 0
+//			 never expires.
+//==============================================================================================================================
+type Freeze struct {
+	EntityType		string	`json:"entityType"`
+	EntityID		string	`json:"entityId"`
+	Reason			string	`json:"reason"`
+	CaseRef			string	`json:"caseRef"`
+	Expiry			int64	`json:"expiry"`
+	FrozenBy		string	`json:"frozenBy"`
+	FrozenAt		int64	`json:"frozenAt"`
+	Status			string	`json:"status"`
+	LiftedBy		string	`json:"liftedBy,omitempty"`
+	LiftedAt		int64	`json:"liftedAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	Freeze Action - One freeze or unfreeze in the regulator's audit log.
+//==============================================================================================================================
+type Freeze_Action struct {
+	ActionID		string	`json:"actionId"`
+	Action			string	`json:"action"`
+	EntityType		string	`json:"entityType"`
+	EntityID		string	`json:"entityId"`
+	Reason			string	`json:"reason"`
+	CaseRef			string	`json:"caseRef"`
+	Expiry			int64	`json:"expiry"`
+	By				string	`json:"by"`
+	Timestamp		int64	`json:"timestamp"`
+	TxID			string	`json:"txId"`
+}
+
+//==============================================================================================================================
+//	Freeze Holders - Define the structures that hold the <entityType>_<entityID> keys of freezes and the actionIDs of
+//					 the audit log.
+//==============================================================================================================================
+type FreezeID_Holder struct {
+	Freezes			[]string	`json:"freezes"`
+}
+
+type FreezeActionID_Holder struct {
+	ActionIDs		[]string	`json:"actionIDs"`
+}
+
+//==============================================================================================================================
+//	 retrieve_freeze - Gets the freeze on an entity, or nil if it has never been frozen.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_freeze(stub shim.ChaincodeStubInterface, entity_type string, entityID string) (*Freeze, error) {
+
+	bytes, err := stub.GetState(FREEZE_PREFIX + entity_type + "_" + entityID)
+	if err != nil { return nil, errors.New("Unable to get freeze for " + entityID) }
+	if bytes == nil { return nil, nil }
+
+	var v Freeze
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return nil, errors.New("Corrupt freeze for " + entityID) }
+	return &v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_freeze - Writes the freeze to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_freeze(stub shim.ChaincodeStubInterface, v Freeze) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting freeze record: %s", err); return errors.New("Error converting freeze record") }
+
+	err = stub.PutState(FREEZE_PREFIX + v.EntityType + "_" + v.EntityID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing freeze record: %s", err); return errors.New("Error storing freeze record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 in_force - true if the freeze still holds at time now.
+//==============================================================================================================================
+func (v Freeze) in_force(now int64) bool {
+
+	return v.Status == FREEZE_ACTIVE && (v.Expiry == 0 || now < v.Expiry)
+}
+
+//==============================================================================================================================
+//	 check_not_frozen - Returns an error if the entity is frozen.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_not_frozen(stub shim.ChaincodeStubInterface, entity_type string, entityID string) error {
+
+	if entityID == "" { return nil }
+
+	v, err := t.retrieve_freeze(stub, entity_type, entityID)
+	if err != nil || v == nil { return err }
+	if !v.in_force(t.get_tx_time(stub)) { return nil }
+
+	return errors.New(" " + entity_type + " " + entityID + " is frozen under case " + v.CaseRef + ".")
+}
+
+//==============================================================================================================================
+//	 check_pos_not_frozen - Returns an error if p or the partner that operates it is frozen.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_pos_not_frozen(stub shim.ChaincodeStubInterface, p PoS) error {
+
+	err := t.check_not_frozen(stub, ENTITY_POS, p.PoSID)
+	if err != nil { return err }
+	return t.check_not_frozen(stub, ENTITY_PARTNER, p.PartnerID)
+}
+
+//==============================================================================================================================
+//	 log_freeze_action - Adds a freeze or unfreeze to the audit log.
+//==============================================================================================================================
+func (t *SimpleChaincode) log_freeze_action(stub shim.ChaincodeStubInterface, v Freeze_Action) error {
+
+	ids, err := t.retrieve_ids(stub, "freezeActionIDs", "actionIDs")
+	if err != nil { return err }
+
+	v.ActionID = fmt.Sprintf("FA%08d", len(ids) + 1)
+	v.Timestamp = t.get_tx_time(stub)
+	v.TxID = stub.GetTxID()
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return errors.New("Error converting freeze action record") }
+
+	err = stub.PutState(FREEZE_ACTION_PREFIX + v.ActionID, bytes)
+	if err != nil { return errors.New("Error storing freeze action record") }
+
+	return t.append_ids(stub, "freezeActionIDs", "actionIDs", []string{v.ActionID})
+}
+
+//=================================================================================================================================
+//	 freeze_entity - Freezes a customer, PoS or partner. expiry_arg is a Unix time, or empty for a freeze that lasts
+//					 until it is lifted. Freezing an entity again replaces the reason, case and expiry.
+//=================================================================================================================================
+func (t *SimpleChaincode) freeze_entity(stub shim.ChaincodeStubInterface, entity_type string, entityID string, reason string, case_ref string, expiry_arg string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	if entity_type == ENTITY_CUSTOMER {
+		_, err = t.retrieve_customer(stub, entityID)
+	} else if entity_type == ENTITY_POS {
+		_, err = t.retrieve_pos(stub, entityID)
+	} else if entity_type == ENTITY_PARTNER {
+		_, err = t.retrieve_partner(stub, entityID)
+	} else {
+		return nil, errors.New("Unknown entity type " + entity_type + ", expected customer, pos or partner")
+	}
+	if err != nil { return nil, errors.New("Unknown " + entity_type + " " + entityID) }
+
+	if reason == "" || case_ref == "" { return nil, errors.New("A freeze needs a reason and a case reference") }
+
+	now := t.get_tx_time(stub)
+
+	var expiry int64
+	if expiry_arg != "" {
+		expiry, err = strconv.ParseInt(expiry_arg, 10, 64)
+		if err != nil || expiry <= now { return nil, errors.New("Invalid expiry " + expiry_arg + ", expected a future Unix time") }
+	}
+
+	current, err := t.retrieve_freeze(stub, entity_type, entityID)
+	if err != nil { return nil, err }
+
+	v := Freeze{EntityType: entity_type, EntityID: entityID, Reason: reason, CaseRef: case_ref, Expiry: expiry, FrozenBy: caller, FrozenAt: now, Status: FREEZE_ACTIVE}
+
+	err = t.save_changes_freeze(stub, v)
+	if err != nil { return nil, err }
+
+	if current == nil {
+		err = t.append_ids(stub, "freezeIDs", "freezes", []string{entity_type + "_" + entityID})
+		if err != nil { return nil, err }
+	}
+
+	return nil, t.log_freeze_action(stub, Freeze_Action{Action: "freeze", EntityType: entity_type, EntityID: entityID, Reason: reason, CaseRef: case_ref, Expiry: expiry, By: caller})
+}
+
+//=================================================================================================================================
+//	 unfreeze_entity - Lifts the freeze on a customer, PoS or partner.
+//=================================================================================================================================
+func (t *SimpleChaincode) unfreeze_entity(stub shim.ChaincodeStubInterface, entity_type string, entityID string, reason string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	v, err := t.retrieve_freeze(stub, entity_type, entityID)
+	if err != nil { return nil, err }
+	if v == nil || v.Status != FREEZE_ACTIVE { return nil, errors.New(entity_type + " " + entityID + " is not frozen") }
+
+	v.Status = FREEZE_LIFTED
+	v.LiftedBy = caller
+	v.LiftedAt = t.get_tx_time(stub)
+
+	err = t.save_changes_freeze(stub, *v)
+	if err != nil { return nil, err }
+
+	return nil, t.log_freeze_action(stub, Freeze_Action{Action: "unfreeze", EntityType: entity_type, EntityID: entityID, Reason: reason, CaseRef: v.CaseRef, By: caller})
+}
+
+//=================================================================================================================================
+//	 get_freezes - Returns the freezes in force.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_freezes(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	keys, err := t.retrieve_ids(stub, "freezeIDs", "freezes")
+	if err != nil { return nil, err }
+
+	now := t.get_tx_time(stub)
+
+	result := []Freeze{}
+	for _, key := range keys {
+		bytes, err := stub.GetState(FREEZE_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Failed to retrieve Freeze " + key) }
+
+		var v Freeze
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt Freeze " + key) }
+		if v.in_force(now) { result = append(result, v) }
+	}
+	return json.Marshal(result)
+}
+
+//=================================================================================================================================
+//	 get_freeze_log - Returns the freeze audit log, optionally only for one entity type or one entity.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_freeze_log(stub shim.ChaincodeStubInterface, entity_type string, entityID string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "freezeActionIDs", "actionIDs")
+	if err != nil { return nil, err }
+
+	result := []Freeze_Action{}
+	for _, id := range ids {
+		bytes, err := stub.GetState(FREEZE_ACTION_PREFIX + id)
+		if err != nil || bytes == nil { return nil, errors.New("Failed to retrieve Freeze Action " + id) }
+
+		var v Freeze_Action
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt Freeze Action " + id) }
+		if entity_type != "" && v.EntityType != entity_type { continue }
+		if entityID != "" && v.EntityID != entityID { continue }
+		result = append(result, v)
+	}
+	return json.Marshal(result)
+}
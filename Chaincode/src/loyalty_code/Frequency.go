@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Frequency scopes - What a frequency program counts visits at.
+//==============================================================================================================================
+const   FREQUENCY_POS			=  "pos"
+const   FREQUENCY_PARTNER		=  "partner"
+
+const   FREQUENCY_PREFIX		=  "frequency_"
+const   VISITS_PREFIX			=  "visits_"
+
+//==============================================================================================================================
+//	Frequency Program - Rewards customers who buy often. Every money purchase of at least MinAmount at the PoS or
+//						partner named by Scope and ScopeID is a visit. A customer whose visits in the last Window
+//						seconds reach a level's Visits gets that level's reward on the purchase: DiscountPercent off
+//						the price and the points scaled by MultiplierPercent (100 leaves them as they are). Levels
+//						are kept in ascending order of Visits and the highest one reached applies. A program belongs
+//						to the partner it counts visits at, or that operates the PoS it counts visits at.
+//==============================================================================================================================
+type Frequency_Program struct {
+	ProgramID		string				`json:"programId"`
+	Name			string				`json:"name"`
+	Scope			string				`json:"scope"`
+	ScopeID			string				`json:"scopeId"`
+	Window			int64				`json:"window"`
+	MinAmount		int					`json:"minAmount"`
+	Levels			[]Frequency_Level	`json:"levels"`
+	Status			bool				`json:"status"`
+}
+
+type Frequency_Level struct {
+	Visits				int		`json:"visits"`
+	DiscountPercent		int		`json:"discountPercent"`
+	MultiplierPercent	int		`json:"multiplierPercent"`
+}
+
+//==============================================================================================================================
+//	FrequencyID Holder - Defines the structure that holds all the programIDs for Frequency Programs.
+//==============================================================================================================================
+type FrequencyID_Holder struct {
+	ProgramIDs		[]string	`json:"programIDs"`
+}
+
+//==============================================================================================================================
+//	Frequency Reward - The reward a purchase gets from the frequency programs, before its visit is counted.
+//==============================================================================================================================
+type Frequency_Reward struct {
+	DiscountPercent		int
+	MultiplierPercent	int
+	ProgramIDs			[]string
+}
+
+//==============================================================================================================================
+//	Frequency Progress - Where a customer stands in one program.
+//==============================================================================================================================
+type Frequency_Progress struct {
+	ProgramID		string				`json:"programId"`
+	Name			string				`json:"name"`
+	Visits			int					`json:"visits"`
+	Level			*Frequency_Level	`json:"level"`
+	NextLevel		*Frequency_Level	`json:"nextLevel"`
+	VisitsToNext	int					`json:"visitsToNext"`
+}
+
+//==============================================================================================================================
+//	 retrieve_frequency_program - Gets the frequency program stored for programID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_frequency_program(stub shim.ChaincodeStubInterface, programID string) (Frequency_Program, error) {
+
+	var v Frequency_Program
+
+	bytes, err := stub.GetState(FREQUENCY_PREFIX + programID)
+	if err != nil { return v, errors.New("RETRIEVE_FREQUENCY_PROGRAM: Error retrieving Frequency Program with programID = " + programID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_FREQUENCY_PROGRAM: No Frequency Program with programID = " + programID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_FREQUENCY_PROGRAM: Corrupt Frequency Program record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_frequency_program - Writes the frequency program to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_frequency_program(stub shim.ChaincodeStubInterface, v Frequency_Program) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting frequency program record: %s", err); return errors.New("Error converting frequency program record") }
+
+	err = stub.PutState(FREQUENCY_PREFIX + v.ProgramID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing frequency program record: %s", err); return errors.New("Error storing frequency program record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_visits - Returns the times of the customer's visits for the program that fall in its window at now.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_visits(stub shim.ChaincodeStubInterface, f Frequency_Program, customerID string, now int64) ([]int64, error) {
+
+	var visits []int64
+
+	bytes, err := stub.GetState(VISITS_PREFIX + f.ProgramID + "_" + customerID)
+	if err != nil { return nil, errors.New("Unable to get visits for " + customerID) }
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &visits)
+		if err != nil { return nil, errors.New("Corrupt visits for " + customerID) }
+	}
+
+	var recent []int64
+	for _, visit := range visits {
+		if visit > now - f.Window { recent = append(recent, visit) }
+	}
+	return recent, nil
+}
+
+//==============================================================================================================================
+//	 level - Returns the position of the highest level reached with visits, or -1.
+//==============================================================================================================================
+func (f Frequency_Program) level(visits int) int {
+
+	reached := -1
+	for l, level := range f.Levels {
+		if visits >= level.Visits { reached = l }
+	}
+	return reached
+}
+
+//==============================================================================================================================
+//	 covers - true if the program counts purchases at PoS p.
+//==============================================================================================================================
+func (f Frequency_Program) covers(p PoS) bool {
+
+	if f.Scope == FREQUENCY_POS { return f.ScopeID == p.PoSID }
+	return p.PartnerID != "" && f.ScopeID == p.PartnerID
+}
+
+//==============================================================================================================================
+//	 check_frequency_owner - Returns an error unless the caller acts for the partner the program belongs to.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_frequency_owner(stub shim.ChaincodeStubInterface, f Frequency_Program) error {
+
+	partnerID := f.ScopeID
+
+	if f.Scope == FREQUENCY_POS {
+		p, err := t.retrieve_pos(stub, f.ScopeID)
+		if err != nil { return errors.New("Unknown pos " + f.ScopeID) }
+		partnerID = p.PartnerID
+	} else if f.Scope == FREQUENCY_PARTNER {
+		_, err := t.retrieve_partner(stub, f.ScopeID)
+		if err != nil { return errors.New("Unknown partner " + f.ScopeID) }
+	} else {
+		return errors.New("Unknown scope " + f.Scope + ", expected pos or partner")
+	}
+
+	if partnerID == "" { return t.check_program_caller(stub) }
+	return t.check_acts_for(stub, partnerID)
+}
+
+//=================================================================================================================================
+//	 create_frequency_program - Creates a frequency program from its JSON definition.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_frequency_program(stub shim.ChaincodeStubInterface, program_json string) ([]byte, error) {
+
+	var v Frequency_Program
+	err := json.Unmarshal([]byte(program_json), &v)
+	if err != nil { return nil, errors.New("Invalid frequency program JSON") }
+
+	err = t.check_frequency_owner(stub, v)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if v.ProgramID == "" { return nil, errors.New("Frequency program must have a programId") }
+	if v.Name == "" { v.Name = v.ProgramID }
+	if v.Window <= 0 { return nil, errors.New("window must be greater than 0") }
+	if v.MinAmount < 0 { return nil, errors.New("minAmount cannot be negative") }
+
+	if len(v.Levels) == 0 { return nil, errors.New("Frequency program must have at least one level") }
+	for l, level := range v.Levels {
+		if level.Visits <= 0 { return nil, errors.New("visits must be greater than 0") }
+		if l > 0 && level.Visits <= v.Levels[l-1].Visits { return nil, errors.New("Levels must be in ascending order of visits") }
+		if level.DiscountPercent < 0 || level.DiscountPercent > config.MaxFrequencyDiscount { return nil, errors.New(fmt.Sprintf("discountPercent must be between 0 and %d", config.MaxFrequencyDiscount)) }
+		if level.MultiplierPercent == 0 { v.Levels[l].MultiplierPercent = 100 }
+		if level.MultiplierPercent < 0 { return nil, errors.New("multiplierPercent cannot be negative") }
+		if level.MultiplierPercent > config.MaxFrequencyMultiplier { return nil, errors.New(fmt.Sprintf("multiplierPercent cannot be above %d", config.MaxFrequencyMultiplier)) }
+	}
+
+	_, err = t.retrieve_frequency_program(stub, v.ProgramID)
+	if err == nil { return nil, errors.New("Frequency program already exists") }
+
+	v.Status = true
+
+	err = t.save_changes_frequency_program(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "frequencyIDs", "programIDs", []string{v.ProgramID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 end_frequency_program - Stops a frequency program counting visits and giving rewards.
+//=================================================================================================================================
+func (t *SimpleChaincode) end_frequency_program(stub shim.ChaincodeStubInterface, programID string) ([]byte, error) {
+
+	v, err := t.retrieve_frequency_program(stub, programID)
+	if err != nil { return nil, err }
+
+	err = t.check_frequency_owner(stub, v)
+	if err != nil { return nil, err }
+
+	v.Status = false
+
+	err = t.save_changes_frequency_program(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 apply_frequency - Works out the reward for a money purchase at p from the visits before it, then counts the
+//					   purchase as a visit if price qualifies. The biggest discount and the biggest multiplier of all
+//					   the programs covering p apply.
+//==============================================================================================================================
+func (t *SimpleChaincode) apply_frequency(stub shim.ChaincodeStubInterface, v Customer, p PoS, price int) (Frequency_Reward, error) {
+
+	reward := Frequency_Reward{MultiplierPercent: 100}
+
+	ids, err := t.retrieve_ids(stub, "frequencyIDs", "programIDs")
+	if err != nil { return reward, err }
+
+	now := t.get_tx_time(stub)
+
+	for _, id := range ids {
+		f, err := t.retrieve_frequency_program(stub, id)
+		if err != nil { return reward, err }
+		if !f.Status || !f.covers(p) { continue }
+
+		visits, err := t.retrieve_visits(stub, f, v.CustomerID, now)
+		if err != nil { return reward, err }
+
+		l := f.level(len(visits))
+		if l >= 0 {
+			if f.Levels[l].DiscountPercent > reward.DiscountPercent { reward.DiscountPercent = f.Levels[l].DiscountPercent }
+			if f.Levels[l].MultiplierPercent > reward.MultiplierPercent { reward.MultiplierPercent = f.Levels[l].MultiplierPercent }
+			reward.ProgramIDs = append(reward.ProgramIDs, f.ProgramID)
+		}
+
+		if price < f.MinAmount { continue }
+
+		bytes, err := json.Marshal(append(visits, now))
+		if err != nil { return reward, errors.New("Error converting visits") }
+
+		err = stub.PutState(VISITS_PREFIX + f.ProgramID + "_" + v.CustomerID, bytes)
+		if err != nil { return reward, errors.New("Error storing visits") }
+	}
+	return reward, nil
+}
+
+//=================================================================================================================================
+//	 get_frequency_progress - Returns the customer's visits, current level and the visits still needed for the next
+//							  level in every running program.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_frequency_progress(stub shim.ChaincodeStubInterface, customerID string) ([]byte, error) {
+
+	err := t.check_customer_view(stub, customerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "frequencyIDs", "programIDs")
+	if err != nil { return nil, err }
+
+	now := t.get_tx_time(stub)
+	progress := []Frequency_Progress{}
+
+	for _, id := range ids {
+		f, err := t.retrieve_frequency_program(stub, id)
+		if err != nil { return nil, err }
+		if !f.Status { continue }
+
+		visits, err := t.retrieve_visits(stub, f, customerID, now)
+		if err != nil { return nil, err }
+
+		v := Frequency_Progress{ProgramID: f.ProgramID, Name: f.Name, Visits: len(visits)}
+		l := f.level(len(visits))
+		if l >= 0 { v.Level = &f.Levels[l] }
+		if l + 1 < len(f.Levels) {
+			v.NextLevel = &f.Levels[l+1]
+			v.VisitsToNext = f.Levels[l+1].Visits - len(visits)
+		}
+		progress = append(progress, v)
+	}
+	return json.Marshal(progress)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Genesis - The document passed to Init to deploy a network ready to use. PoS may name partners, and items may name
+//			  PoS, from the same document.
+//==============================================================================================================================
+type Genesis struct {
+	Program		json.RawMessage	`json:"program"`
+	Partners	[]Partner		`json:"partners"`
+	PoS			[]bulk_pos_row	`json:"pos"`
+	Items		[]Item			`json:"items"`
+	Admins		[]string		`json:"admins"`
+}
+
+//=================================================================================================================================
+//	 apply_genesis - Parses and validates the whole genesis document, then writes it. Every problem found is reported
+//					 together and nothing is written unless the document is valid.
+//=================================================================================================================================
+func (t *SimpleChaincode) apply_genesis(stub shim.ChaincodeStubInterface, genesis_json string) ([]byte, error) {
+
+	var g Genesis
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(genesis_json)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&g)
+	if err != nil { fmt.Printf("INIT: Invalid genesis document: %s", err); return nil, errors.New("Invalid genesis document: " + err.Error()) }
+
+	var problems []string
+
+	config := default_program_config()
+	if g.Program != nil {
+		err = json.Unmarshal(g.Program, &config)
+		if err != nil { problems = append(problems, "program: Invalid program config") }
+	}
+	config.Version = 1
+
+	err = config.validate()
+	if err != nil { problems = append(problems, "program: " + err.Error()) }
+
+	partners := make(map[string]bool)
+	for n := range g.Partners {
+		p := &g.Partners[n]
+		err = config.check_id(ENTITY_PARTNER, p.PartnerID, "")
+		if err != nil { problems = append(problems, fmt.Sprintf("partners[%d]: %s", n, err.Error())); continue }
+		if partners[p.PartnerID] { problems = append(problems, fmt.Sprintf("partners[%d]: Duplicate partnerId %s", n, p.PartnerID)); continue }
+		if p.Type != AIRLINES && p.Type != HOTEL && p.Type != VENDOR { problems = append(problems, fmt.Sprintf("partners[%d]: Unknown partner type %s", n, p.Type)) }
+		if p.Name == "" { p.Name = p.PartnerID }
+		p.Status = true
+		partners[p.PartnerID] = true
+	}
+
+	var pos []PoS
+	pos_seen := make(map[string]bool)
+	pos_partners := make(map[string]string)
+	for n, row := range g.PoS {
+		if partners[row.PoSID] { problems = append(problems, fmt.Sprintf("pos[%d]: posId %s is already used by a partner", n, row.PoSID)); continue }
+		p, err := t.validate_bulk_pos(stub, config, row, pos_seen, partners)
+		if err != nil { problems = append(problems, fmt.Sprintf("pos[%d]: %s", n, err.Error())); continue }
+		pos = append(pos, p)
+		pos_partners[p.PoSID] = p.PartnerID
+	}
+
+	item_seen := make(map[string]bool)
+	for n := range g.Items {
+		if partners[g.Items[n].ItemID] || pos_seen[g.Items[n].ItemID] { problems = append(problems, fmt.Sprintf("items[%d]: itemId %s is already in use", n, g.Items[n].ItemID)); continue }
+		err = t.validate_bulk_item(stub, config, &g.Items[n], item_seen, pos_partners)
+		if err != nil { problems = append(problems, fmt.Sprintf("items[%d]: %s", n, err.Error())) }
+	}
+
+	admin_seen := make(map[string]bool)
+	for n, admin := range g.Admins {
+		if admin == "" { problems = append(problems, fmt.Sprintf("admins[%d]: Username cannot be empty", n)) }
+		if admin_seen[admin] { problems = append(problems, fmt.Sprintf("admins[%d]: Duplicate admin %s", n, admin)) }
+		admin_seen[admin] = true
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("INIT: Invalid genesis document: %s", strings.Join(problems, "; "))
+		return nil, errors.New("Invalid genesis document: " + strings.Join(problems, "; "))
+	}
+
+	err = t.save_program_config(stub, config)
+	if err != nil { return nil, err }
+
+	var ids []string
+	for _, p := range g.Partners {
+		_, err = t.save_changes_partner(stub, p)
+		if err != nil { return nil, err }
+		ids = append(ids, p.PartnerID)
+	}
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "partnerIDs", "partnerIDs", ids)
+		if err != nil { return nil, err }
+	}
+
+	ids = nil
+	for _, p := range pos {
+		_, err = t.save_changes_pos(stub, p)
+		if err != nil { return nil, err }
+		ids = append(ids, p.PoSID)
+	}
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "posIDs", "posIDs", ids)
+		if err != nil { return nil, err }
+	}
+
+	ids = nil
+	for _, i := range g.Items {
+		_, err = t.save_changes_item(stub, i)
+		if err != nil { return nil, err }
+		ids = append(ids, i.ItemID)
+	}
+	if len(ids) > 0 {
+		err = t.append_ids(stub, "itemIDs", "itemIDIDs", ids)
+		if err != nil { return nil, err }
+	}
+
+	if len(g.Admins) > 0 {
+		err = t.append_ids(stub, "adminIDs", "admins", g.Admins)
+		if err != nil { return nil, err }
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Gift status
+//==============================================================================================================================
+const   GIFT_PENDING		=  "pending"
+const   GIFT_ACCEPTED		=  "accepted"
+const   GIFT_DECLINED		=  "declined"
+const   GIFT_RETURNED		=  "returned"
+
+const   GIFT_PREFIX			=  "gift_"
+const   DEFAULT_GIFT_EXPIRY	=  30 * 24 * 60 * 60
+
+//==============================================================================================================================
+//	Gift - Points sent from one customer to another. The points leave the sender's wallet when the gift is sent and
+//		   are held in escrow until the recipient accepts, the recipient declines or the gift expires; the last two
+//		   return them to the sender.
+//
+//		   A gift for someone who is not yet a customer has no RecipientID. It is claimed with a code the sender
+//		   passes on, which is stored only as ClaimHash.
+//==============================================================================================================================
+type Gift struct {
+	GiftID			string	`json:"giftId"`
+	SenderID		string	`json:"senderId"`
+	RecipientID		string	`json:"recipientId,omitempty"`
+	ClaimHash		string	`json:"claimHash,omitempty"`
+	Points			int		`json:"points"`
+	Message			string	`json:"message"`
+	Status			string	`json:"status"`
+	CreatedAt		int64	`json:"createdAt"`
+	ExpiresAt		int64	`json:"expiresAt"`
+	SettledAt		int64	`json:"settledAt,omitempty"`
+}
+
+//==============================================================================================================================
+//	GiftID Holder - Defines the structure that holds all the giftIDs for Gifts that have been sent.
+//==============================================================================================================================
+type GiftID_Holder struct {
+	GiftIDs			[]string	`json:"giftIDs"`
+}
+
+//==============================================================================================================================
+//	 hash_claim_code - Returns the ClaimHash for a code.
+//==============================================================================================================================
+func hash_claim_code(code string) string {
+
+	sum := sha256.Sum256([]byte("gift|" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+//==============================================================================================================================
+//	 retrieve_gift - Gets the gift stored for giftID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_gift(stub shim.ChaincodeStubInterface, giftID string) (Gift, error) {
+
+	var v Gift
+
+	bytes, err := stub.GetState(GIFT_PREFIX + giftID)
+	if err != nil { return v, errors.New("RETRIEVE_GIFT: Error retrieving Gift with giftID = " + giftID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_GIFT: No Gift with giftID = " + giftID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_GIFT: Corrupt Gift record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_gift - Writes the gift to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_gift(stub shim.ChaincodeStubInterface, v Gift) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting gift record: %s", err); return errors.New("Error converting gift record") }
+
+	err = stub.PutState(GIFT_PREFIX + v.GiftID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing gift record: %s", err); return errors.New("Error storing gift record") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 send_gift - Moves points from the sender into escrow. recipientID may be empty if claim_code is given. Returns the
+//				 giftID.
+//=================================================================================================================================
+func (t *SimpleChaincode) send_gift(stub shim.ChaincodeStubInterface, senderID string, recipientID string, points_arg string, message string, claim_code string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, senderID)
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	points, err := strconv.Atoi(points_arg)
+	if err != nil || points <= 0 { return nil, errors.New("Invalid points " + points_arg) }
+	if config.MaxTransfer > 0 && points > config.MaxTransfer { return nil, errors.New(fmt.Sprintf(" Gifts are limited to %d points.", config.MaxTransfer)) }
+
+	sender, err := t.retrieve_customer(stub, senderID)
+	if err != nil { return nil, err }
+	if !sender.Status { return nil, errors.New(" Customer Not Active.") }
+	if sender.Cashback < points { return nil, errors.New(" Not enough balance.") }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, senderID)
+	if err != nil { return nil, err }
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, recipientID)
+	if err != nil { return nil, err }
+
+	v := Gift{SenderID: senderID, Points: points, Message: message, Status: GIFT_PENDING}
+
+	if recipientID != "" {
+		if recipientID == senderID { return nil, errors.New("A customer cannot send a gift to themselves") }
+		_, err = t.retrieve_customer(stub, recipientID)
+		if err != nil { return nil, errors.New("Unknown recipient " + recipientID) }
+		v.RecipientID = recipientID
+	} else {
+		if len(claim_code) < 6 { return nil, errors.New("A gift without a recipient needs a claim code of at least 6 characters") }
+		v.ClaimHash = hash_claim_code(claim_code)
+	}
+
+	v.GiftID, err = t.generate_id(stub, config, ENTITY_GIFT, nil)
+	if err != nil { return nil, err }
+
+	v.CreatedAt = t.get_tx_time(stub)
+	v.ExpiresAt = v.CreatedAt + config.GiftExpiry
+
+	sender.Cashback = sender.Cashback - points
+
+	_, err = t.save_changes(stub, sender)
+	if err != nil { fmt.Printf("SEND_GIFT: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	err = t.save_changes_gift(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "giftIDs", "giftIDs", []string{v.GiftID})
+	if err != nil { return nil, err }
+
+	return []byte(v.GiftID), nil
+}
+
+//=================================================================================================================================
+//	 accept_gift - Pays a pending gift to customerID. A gift sent without a recipient needs its claim code.
+//=================================================================================================================================
+func (t *SimpleChaincode) accept_gift(stub shim.ChaincodeStubInterface, giftID string, customerID string, claim_code string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, customerID)
+	if err != nil { return nil, err }
+
+	v, err := t.retrieve_gift(stub, giftID)
+	if err != nil { return nil, err }
+	if v.Status != GIFT_PENDING { return nil, errors.New("Gift has already been " + v.Status) }
+	if t.get_tx_time(stub) > v.ExpiresAt { return nil, errors.New("Gift has expired") }
+
+	if v.RecipientID == "" {
+		if hash_claim_code(claim_code) != v.ClaimHash { return nil, errors.New("Invalid claim code") }
+		if customerID == v.SenderID { return nil, errors.New("A customer cannot accept their own gift") }
+		v.RecipientID = customerID
+	} else if v.RecipientID != customerID {
+		return nil, errors.New("Gift is for another customer")
+	}
+
+	recipient, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+	if !recipient.Status { return nil, errors.New(" Customer Not Active.") }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, customerID)
+	if err != nil { return nil, err }
+
+	recipient.Cashback = recipient.Cashback + v.Points
+
+	_, err = t.save_changes(stub, recipient)
+	if err != nil { fmt.Printf("ACCEPT_GIFT: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	return nil, t.settle_gift(stub, v, GIFT_ACCEPTED)
+}
+
+//=================================================================================================================================
+//	 decline_gift - Returns a pending gift to its sender. Only the named recipient can decline.
+//=================================================================================================================================
+func (t *SimpleChaincode) decline_gift(stub shim.ChaincodeStubInterface, giftID string, customerID string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, customerID)
+	if err != nil { return nil, err }
+
+	v, err := t.retrieve_gift(stub, giftID)
+	if err != nil { return nil, err }
+	if v.Status != GIFT_PENDING { return nil, errors.New("Gift has already been " + v.Status) }
+	if v.RecipientID != customerID { return nil, errors.New("Gift is for another customer") }
+
+	return nil, t.return_gift(stub, v, GIFT_DECLINED)
+}
+
+//=================================================================================================================================
+//	 return_expired_gifts - Returns every pending gift past its expiry to its sender. Returns the number returned.
+//=================================================================================================================================
+func (t *SimpleChaincode) return_expired_gifts(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "giftIDs", "giftIDs")
+	if err != nil { return nil, err }
+
+	now := t.get_tx_time(stub)
+	returned := 0
+
+	for _, id := range ids {
+		v, err := t.retrieve_gift(stub, id)
+		if err != nil { return nil, err }
+		if v.Status != GIFT_PENDING || now <= v.ExpiresAt { continue }
+
+		err = t.return_gift(stub, v, GIFT_RETURNED)
+		if err != nil { return nil, err }
+		returned++
+	}
+	return []byte(strconv.Itoa(returned)), nil
+}
+
+//==============================================================================================================================
+//	 return_gift - Pays the escrowed points back to the sender and closes the gift with status.
+//==============================================================================================================================
+func (t *SimpleChaincode) return_gift(stub shim.ChaincodeStubInterface, v Gift, status string) error {
+
+	sender, err := t.retrieve_customer(stub, v.SenderID)
+	if err != nil { return err }
+
+	sender.Cashback = sender.Cashback + v.Points
+
+	_, err = t.save_changes(stub, sender)
+	if err != nil { fmt.Printf("RETURN_GIFT: Error saving changes: %s", err); return errors.New("Error saving changes") }
+
+	return t.settle_gift(stub, v, status)
+}
+
+func (t *SimpleChaincode) settle_gift(stub shim.ChaincodeStubInterface, v Gift, status string) error {
+
+	v.Status = status
+	v.SettledAt = t.get_tx_time(stub)
+	return t.save_changes_gift(stub, v)
+}
+
+//=================================================================================================================================
+//	 get_gifts - Returns the gifts a customer has sent or received.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_gifts(stub shim.ChaincodeStubInterface, customerID string) ([]byte, error) {
+
+	err := t.check_customer_view(stub, customerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "giftIDs", "giftIDs")
+	if err != nil { return nil, err }
+
+	gifts := []Gift{}
+	for _, id := range ids {
+		v, err := t.retrieve_gift(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Gift") }
+		if v.SenderID == customerID || v.RecipientID == customerID { gifts = append(gifts, v) }
+	}
+	return json.Marshal(gifts)
+}
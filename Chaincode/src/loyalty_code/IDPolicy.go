@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Default ID format - two ASCII letters followed by seven digits and nothing else.
+//==============================================================================================================================
+const   DEFAULT_ID_PATTERN	=  "^[A-Za-z]{2}[0-9]{7}$"
+const   DEFAULT_ID_LENGTH	=  9
+
+//==============================================================================================================================
+//	ID Policy - How IDs for one entity type are checked, held in the program config.
+//
+//				Length of 0 skips the length check. PartnerPrefixes limits the IDs a partner may use for the PoS and
+//				items it operates, e.g. {"PA0000001": ["HX"]}; partners that are not listed are not limited. With
+//				Generate set, a create that leaves the ID empty gets one derived from the TxID, made of
+//				GeneratePrefix followed by digits up to Length.
+//==============================================================================================================================
+type ID_Policy struct {
+	Pattern				string				`json:"pattern"`
+	Length				int					`json:"length"`
+	PartnerPrefixes		map[string][]string	`json:"partnerPrefixes"`
+	Generate			bool				`json:"generate"`
+	GeneratePrefix		string				`json:"generatePrefix"`
+}
+
+//==============================================================================================================================
+//	 default_id_policies - One policy per entity type, each using the default format apart from gifts, whose IDs are
+//						   always generated, and loyalty programs, whose IDs are short names. No ID may contain an
+//						   underscore, which separates the parts of compound keys.
+//==============================================================================================================================
+func default_id_policies() map[string]ID_Policy {
+
+	return map[string]ID_Policy{
+		ENTITY_CUSTOMER:	{Pattern: DEFAULT_ID_PATTERN, Length: DEFAULT_ID_LENGTH, GeneratePrefix: "CU"},
+		ENTITY_POS:			{Pattern: DEFAULT_ID_PATTERN, Length: DEFAULT_ID_LENGTH, GeneratePrefix: "PS"},
+		ENTITY_ITEM:		{Pattern: DEFAULT_ID_PATTERN, Length: DEFAULT_ID_LENGTH, GeneratePrefix: "IT"},
+		ENTITY_PARTNER:		{Pattern: DEFAULT_ID_PATTERN, Length: DEFAULT_ID_LENGTH, GeneratePrefix: "PA"},
+		ENTITY_GIFT:		{Pattern: "^GF[0-9]{8}$", Length: 10, Generate: true, GeneratePrefix: "GF"},
+		ENTITY_PROGRAM:		{Pattern: "^[A-Za-z0-9]{1,20}$"},
+	}
+}
+
+//==============================================================================================================================
+//	 entity_key - The key a record of the entity type is stored under. Only gifts are stored under a prefix.
+//==============================================================================================================================
+func entity_key(entity string, id string) string {
+
+	if entity == ENTITY_GIFT { return GIFT_PREFIX + id }
+	return id
+}
+
+//==============================================================================================================================
+//	 id_policy - Returns the policy for the entity type, or the default policy if the config has none.
+//==============================================================================================================================
+func (c Program_Config) id_policy(entity string) ID_Policy {
+
+	policy, ok := c.IDPolicies[entity]
+	if !ok { policy = default_id_policies()[entity] }
+	return policy
+}
+
+//==============================================================================================================================
+//	 check_id - Applies the entity's ID policy to id. partnerID is the partner that operates the record, or "" if it
+//				has none.
+//==============================================================================================================================
+func (c Program_Config) check_id(entity string, id string, partnerID string) error {
+
+	policy := c.id_policy(entity)
+
+	if id == "" { return errors.New("Invalid " + entity + " ID provided, ID is empty") }
+	if policy.Length > 0 && len(id) != policy.Length { return errors.New(fmt.Sprintf("Invalid %s ID provided %s, expected %d characters", entity, id, policy.Length)) }
+
+	matched, err := regexp.MatchString(policy.Pattern, id)
+	if err != nil || !matched { return errors.New("Invalid " + entity + " ID provided " + id + ", expected format " + policy.Pattern) }
+
+	prefixes, ok := policy.PartnerPrefixes[partnerID]
+	if partnerID == "" || !ok { return nil }
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(id, prefix) { return nil }
+	}
+	return errors.New("Invalid " + entity + " ID provided " + id + ", partner " + partnerID + " may only use prefixes " + strings.Join(prefixes, ", "))
+}
+
+//==============================================================================================================================
+//	 validate - Checks a policy before it is written, including that the IDs it would generate pass it.
+//==============================================================================================================================
+func (p ID_Policy) validate(entity string) error {
+
+	if p.Pattern == "" { return errors.New("Pattern for " + entity + " cannot be empty") }
+	_, err := regexp.Compile(p.Pattern)
+	if err != nil { return errors.New("Invalid pattern for " + entity + ": " + err.Error()) }
+	if p.Length < 0 { return errors.New("Length for " + entity + " cannot be negative") }
+
+	if p.Generate {
+		digits := p.generated_digits()
+		if digits <= 0 || digits > 18 { return errors.New("Generated IDs for " + entity + " need between 1 and 18 digits after the prefix") }
+
+		sample := Program_Config{IDPolicies: map[string]ID_Policy{entity: p}}
+		err = sample.check_id(entity, p.GeneratePrefix + strings.Repeat("0", digits), "")
+		if err != nil { return errors.New("Generated IDs would not pass the " + entity + " policy: " + err.Error()) }
+	}
+	return nil
+}
+
+func (p ID_Policy) generated_digits() int {
+
+	if p.Length == 0 { return 7 }
+	return p.Length - len(p.GeneratePrefix)
+}
+
+//==============================================================================================================================
+//	 generate_id - Derives an ID for entity from the TxID. Every peer endorsing the transaction derives the same ID.
+//				   taken holds IDs already used in this transaction, which are skipped.
+//==============================================================================================================================
+func (t *SimpleChaincode) generate_id(stub shim.ChaincodeStubInterface, config Program_Config, entity string, taken map[string]bool) (string, error) {
+
+	policy := config.id_policy(entity)
+	if !policy.Generate { return "", errors.New("No " + entity + " ID provided and ID generation is not enabled") }
+
+	digits := policy.generated_digits()
+	modulus := uint64(1)
+	for d := 0; d < digits; d++ { modulus = modulus * 10 }
+
+	for attempt := 0; attempt < 100; attempt++ {
+		sum := sha256.Sum256([]byte(stub.GetTxID() + "|" + entity + "|" + strconv.Itoa(len(taken)) + "|" + strconv.Itoa(attempt)))
+		id := policy.GeneratePrefix + fmt.Sprintf("%0*d", digits, binary.BigEndian.Uint64(sum[:8]) % modulus)
+
+		if taken[id] { continue }
+		record, err := stub.GetState(entity_key(entity, id))
+		if err != nil { return "", errors.New("Unable to check generated ID") }
+		if record != nil { continue }
+
+		return id, nil
+	}
+	return "", errors.New("Unable to generate a unique " + entity + " ID")
+}
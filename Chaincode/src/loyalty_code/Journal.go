@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	Journal Entry - One action against a journaled record, e.g. a voucher being issued or redeemed. Entries are kept
+//					in order under journal_<subject>, where the subject names the record.
+//==============================================================================================================================
+type Journal_Entry struct {
+	TxID			string	`json:"txId"`
+	Timestamp		int64	`json:"timestamp"`
+	Caller			string	`json:"caller"`
+	Role			string	`json:"role"`
+	Action			string	`json:"action"`
+	CustomerID		string	`json:"customerId,omitempty"`
+	Points			int		`json:"points,omitempty"`
+	Note			string	`json:"note,omitempty"`
+}
+
+const   JOURNAL_PREFIX		=  "journal_"
+
+//==============================================================================================================================
+//	 retrieve_journal - Returns the entries recorded for subject, oldest first.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_journal(stub shim.ChaincodeStubInterface, subject string) ([]Journal_Entry, error) {
+
+	entries := []Journal_Entry{}
+
+	bytes, err := stub.GetState(JOURNAL_PREFIX + subject)
+	if err != nil { return nil, errors.New("Unable to get journal for " + subject) }
+	if bytes == nil { return entries, nil }
+
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil { return nil, errors.New("Corrupt journal for " + subject) }
+	return entries, nil
+}
+
+//==============================================================================================================================
+//	 append_journal - Stamps the entry with the transaction and caller and adds it to the subject's journal.
+//==============================================================================================================================
+func (t *SimpleChaincode) append_journal(stub shim.ChaincodeStubInterface, subject string, entry Journal_Entry) error {
+
+	entries, err := t.retrieve_journal(stub, subject)
+	if err != nil { return err }
+
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err == nil {
+		entry.Caller = caller
+		entry.Role = caller_affiliation
+	}
+	entry.TxID = stub.GetTxID()
+	entry.Timestamp = t.get_tx_time(stub)
+
+	bytes, err := json.Marshal(append(entries, entry))
+	if err != nil { return errors.New("Error converting journal for " + subject) }
+
+	err = stub.PutState(JOURNAL_PREFIX + subject, bytes)
+	if err != nil { return errors.New("Error storing journal for " + subject) }
+	return nil
+}
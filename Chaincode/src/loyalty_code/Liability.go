@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   LIABILITY_PREFIX		=  "liability_"
+
+//==============================================================================================================================
+//	 LIABILITY_PROGRAM - Stands in for the issuing partner of points no partner issued, such as opening balances and
+//						 referral bonuses when the program config names no issuer.
+//==============================================================================================================================
+const   LIABILITY_PROGRAM		=  "program"
+
+//==============================================================================================================================
+//	Liability Movement - Points issued, redeemed and expired. Expired counts points taken off balances without being
+//						 redeemed, such as downward balance corrections, and Fees the points charged as fees, such as
+//						 on exchanges between programs.
+//==============================================================================================================================
+type Liability_Movement struct {
+	Issued			int		`json:"issued"`
+	Redeemed		int		`json:"redeemed"`
+	Expired			int		`json:"expired"`
+	Fees			int		`json:"fees,omitempty"`
+}
+
+//==============================================================================================================================
+//	Liability Total - The movements of the whole program and what is still owed to customers.
+//==============================================================================================================================
+type Liability_Total struct {
+	Liability_Movement
+	Outstanding		int		`json:"outstanding"`
+}
+
+//==============================================================================================================================
+//	Liability - A partner's cashback points for one month, given as YYYY-MM, stored under
+//				liability_<partnerID>_<period>. Issued and Expired are the points the partner awarded and wrote off;
+//				Redeemed is the points it accepted, whoever issued them. Customers spend their balance anywhere, so a
+//				partner's own figures do not add up to an amount it owes and only the program as a whole has an
+//				outstanding liability.
+//
+//				A partner's own points program is kept apart, under liability_<partnerID>_<period>_<programID>
+//				with ProgramID set, as its points are only ever owed by the partner that runs it.
+//==============================================================================================================================
+type Liability struct {
+	PartnerID		string			`json:"partnerId"`
+	Period			string			`json:"period"`
+	ProgramID		string			`json:"programId,omitempty"`
+	Liability_Movement
+}
+
+//==============================================================================================================================
+//	Liability Report - The cashback liability of the program in total and by period, what each partner issued and
+//					   accepted, and the liability of each partner program. Total and ByPeriod are left out of a
+//					   report for one partner.
+//==============================================================================================================================
+type Liability_Report struct {
+	Total			*Liability_Total				`json:"total,omitempty"`
+	ByPeriod		map[string]Liability_Total		`json:"byPeriod,omitempty"`
+	ByPartner		map[string]Liability_Movement	`json:"byPartner"`
+	ByProgram		map[string]Liability_Total		`json:"byProgram"`
+	Entries			[]Liability						`json:"entries"`
+}
+
+//==============================================================================================================================
+//	Liability Holder - Defines the structure that holds the <partnerID>_<period> keys of the liability records.
+//==============================================================================================================================
+type LiabilityID_Holder struct {
+	Ledgers			[]string	`json:"ledgers"`
+}
+
+//==============================================================================================================================
+//	 add - Adds o to the movement.
+//==============================================================================================================================
+func (l *Liability_Movement) add(o Liability_Movement) {
+
+	l.Issued = l.Issued + o.Issued
+	l.Redeemed = l.Redeemed + o.Redeemed
+	l.Expired = l.Expired + o.Expired
+	l.Fees = l.Fees + o.Fees
+}
+
+//==============================================================================================================================
+//	 add - Adds o to the total and works out what is still owed.
+//==============================================================================================================================
+func (l *Liability_Total) add(o Liability_Movement) {
+
+	l.Liability_Movement.add(o)
+	l.Outstanding = l.Issued - l.Redeemed - l.Expired - l.Fees
+}
+
+//==============================================================================================================================
+//	 issuer_liability_partner - The partner that issues points on behalf of the program itself.
+//==============================================================================================================================
+func (c Program_Config) issuer_liability_partner() string {
+
+	if c.IssuerPartnerID == "" { return LIABILITY_PROGRAM }
+	return c.IssuerPartnerID
+}
+
+//==============================================================================================================================
+//	 record_liability - Adds change to partnerID's cashback liability for the current month.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_liability(stub shim.ChaincodeStubInterface, partnerID string, change Liability_Movement) error {
+
+	return t.record_program_liability(stub, CASHBACK_PROGRAM, partnerID, change)
+}
+
+//==============================================================================================================================
+//	 record_program_liability - Adds change to partnerID's liability in programID for the current month.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_program_liability(stub shim.ChaincodeStubInterface, programID string, partnerID string, change Liability_Movement) error {
+
+	if change == (Liability_Movement{}) { return nil }
+	if partnerID == "" { partnerID = LIABILITY_PROGRAM }
+
+	period := time.Unix(t.get_tx_time(stub), 0).UTC().Format("2006-01")
+	key := partnerID + "_" + period
+
+	v := Liability{PartnerID: partnerID, Period: period}
+	if programID != CASHBACK_PROGRAM { key, v.ProgramID = key + "_" + programID, programID }
+
+	bytes, err := stub.GetState(LIABILITY_PREFIX + key)
+	if err != nil { return errors.New("Unable to get liability for " + partnerID) }
+	first := bytes == nil
+	if !first {
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return errors.New("Corrupt liability for " + partnerID) }
+	}
+
+	v.add(change)
+
+	bytes, err = json.Marshal(v)
+	if err != nil { return errors.New("Error converting liability for " + partnerID) }
+
+	err = stub.PutState(LIABILITY_PREFIX + key, bytes)
+	if err != nil { return errors.New("Error storing liability for " + partnerID) }
+
+	if first { return t.append_ids(stub, "liabilityIDs", "ledgers", []string{key}) }
+	return nil
+}
+
+//=================================================================================================================================
+//	 get_liability_report - Returns the points liability of the program, optionally only for one partner or one month
+//							given as YYYY-MM. Outstanding is what the selected months add to the liability; with no
+//							filter the total is what the program owes customers today.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_liability_report(stub shim.ChaincodeStubInterface, partnerID string, period string) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	if period != "" {
+		_, err = time.Parse("2006-01", period)
+		if err != nil { return nil, errors.New("Invalid period " + period + ", expected YYYY-MM") }
+	}
+
+	keys, err := t.retrieve_ids(stub, "liabilityIDs", "ledgers")
+	if err != nil { return nil, err }
+
+	report := Liability_Report{ByPartner: map[string]Liability_Movement{}, ByProgram: map[string]Liability_Total{}, Entries: []Liability{}}
+	if partnerID == "" { report.Total, report.ByPeriod = &Liability_Total{}, map[string]Liability_Total{} }
+
+	for _, key := range keys {
+		bytes, err := stub.GetState(LIABILITY_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Failed to retrieve Liability " + key) }
+
+		var v Liability
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt Liability " + key) }
+		if (partnerID != "" && v.PartnerID != partnerID) || (period != "" && v.Period != period) { continue }
+		report.Entries = append(report.Entries, v)
+
+		if v.ProgramID != "" {
+			total := report.ByProgram[v.ProgramID]
+			total.add(v.Liability_Movement)
+			report.ByProgram[v.ProgramID] = total
+			continue
+		}
+
+		movement := report.ByPartner[v.PartnerID]
+		movement.add(v.Liability_Movement)
+		report.ByPartner[v.PartnerID] = movement
+
+		if report.Total != nil {
+			report.Total.add(v.Liability_Movement)
+
+			total := report.ByPeriod[v.Period]
+			total.add(v.Liability_Movement)
+			report.ByPeriod[v.Period] = total
+		}
+	}
+
+	bytes, err := json.Marshal(report)
+	if err != nil { fmt.Printf("GET_LIABILITY_REPORT: Error converting report: %s", err); return nil, errors.New("Error converting liability report") }
+	return bytes, nil
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"errors"
+	"strconv"
 	"fmt"
 	"strings"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"encoding/json"
-	"regexp"
 )
 
 var logger = shim.NewLogger("LoyaltyChaincode")
@@ -21,6 +21,7 @@ const   HOTEL			=  "hotel"
 const   AIRLINES		=  "airlines"
 const   CUSTOMER		=  "customer"
 const   VENDOR			=  "vendor"
+const   CHARITY			=  "charity"
 
 //==============================================================================================================================
 //	 Structure Definitions
@@ -43,6 +44,10 @@ type Customer struct {
 	Email          	string `json:"email"`
 	Phone           string `json:"phone"`
 	Status	        bool   `json:"status"`
+	Tier			string `json:"tier,omitempty"`
+	ReferredBy		string `json:"referredBy,omitempty"`
+	PoolID			string `json:"poolId,omitempty"`
+	Balances		map[string]int `json:"balances,omitempty"`
 }
 
 //==============================================================================================================================
@@ -55,6 +60,21 @@ type PoS struct {
 	PoSName				string `json:"posName"`
 	Status				bool   `json:"status"`
 	LoyaltyPercentage	int	   `json:"percentage"`
+	PartnerID			string `json:"partnerId,omitempty"`
+	MerchantID			string `json:"merchantId,omitempty"`
+	RateOverride		bool   `json:"rateOverride,omitempty"`
+}
+
+//==============================================================================================================================
+//	Partner - A business partner of the program, e.g. the airline, a hotel or a vendor. PoS records name the partner
+//			  that operates them.
+//==============================================================================================================================
+
+type Partner struct {
+	PartnerID			string `json:"partnerId"`
+	Name				string `json:"name"`
+	Type				string `json:"type"`
+	Status				bool   `json:"status"`
 }
 
 //==============================================================================================================================
@@ -66,8 +86,45 @@ type Item struct {
 	PoSID		string `json:"posId"`
 	ItemName	string `json:"itemName"`
 	Price		int	   `json:"price"`
+	CategoryID	string `json:"categoryId,omitempty"`
+	SKU			string `json:"sku,omitempty"`
+	Override	bool   `json:"override,omitempty"`
 }
 
+//==============================================================================================================================
+//	Purchase - The record of one purchase, stored under purchase_<TxID>. EarnRules lists the earn rules that fired,
+//			   CampaignID names the campaign the points were earned under, FrequencyPrograms the frequency programs
+//			   that rewarded the visit and VoucherID the voucher redeemed, if any. Discount is what the voucher and
+//			   frequency programs took off Price and CashPaid the part of a wallet purchase not covered by points.
+//			   PoolID names the pool the points were paid into or drawn from and PaymentRef is the payments
+//			   chaincode's reference for the money paid. A charity spending its points is named by CharityID and
+//			   has no CustomerID.
+//==============================================================================================================================
+
+type Purchase struct {
+	TxID				string `json:"txId"`
+	CustomerID			string `json:"customerId"`
+	CharityID			string `json:"charityId,omitempty"`
+	ItemID				string `json:"itemId"`
+	PoSID				string `json:"posId"`
+	Price				int    `json:"price"`
+	Method				string `json:"method"`
+	PointsEarned		int    `json:"pointsEarned"`
+	PointsRedeemed		int    `json:"pointsRedeemed"`
+	CashPaid			int    `json:"cashPaid,omitempty"`
+	EarnRules			[]string `json:"earnRules,omitempty"`
+	CampaignID			string `json:"campaignId,omitempty"`
+	FrequencyPrograms	[]string `json:"frequencyPrograms,omitempty"`
+	VoucherID			string `json:"voucherId,omitempty"`
+	Discount			int    `json:"discount,omitempty"`
+	PoolID				string `json:"poolId,omitempty"`
+	PaymentRef			string `json:"paymentRef,omitempty"`
+	Timestamp			int64  `json:"timestamp"`
+}
+
+const   PURCHASE_BY_MONEY	=  "money"
+const   PURCHASE_BY_WALLET	=  "wallet"
+
 //==============================================================================================================================
 //	CustomerID Holder - Defines the structure that holds all the customerIDs for Customer that have been created.
 //				Used as an index when querying all vehicles.
@@ -96,7 +153,16 @@ type ItemID_Holder struct {
 }
 
 //==============================================================================================================================
-//	Init Function - Called when the user deploys the chaincode
+//	PartnerID Holder - Defines the structure that holds all the partnerIDs for Partners that have been created.
+//==============================================================================================================================
+
+type PartnerID_Holder struct {
+	PartnerIDs 	[]string `json:"partnerIDs"`
+}
+
+//==============================================================================================================================
+//	Init Function - Called when the user deploys the chaincode. An optional genesis JSON document in args[0] sets up
+//					the program before the first invoke, see Genesis.go.
 //==============================================================================================================================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
@@ -108,8 +174,32 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 
 	err = stub.PutState("customerIDs", bytes)
 
-	for i:=0; i < len(args); i=i+2 {
-		//t.add_pos(stub, args[i], args[i+1])
+	var posIDs PoSID_Holder
+
+	bytes, err = json.Marshal(posIDs)
+
+    if err != nil { return nil, errors.New("Error creating pos record") }
+
+	err = stub.PutState("posIDs", bytes)
+
+	var itemIDs ItemID_Holder
+
+	bytes, err = json.Marshal(itemIDs)
+
+    if err != nil { return nil, errors.New("Error creating item record") }
+
+	err = stub.PutState("itemIDs", bytes)
+
+	var partnerIDs PartnerID_Holder
+
+	bytes, err = json.Marshal(partnerIDs)
+
+    if err != nil { return nil, errors.New("Error creating partner record") }
+
+	err = stub.PutState("partnerIDs", bytes)
+
+	if len(args) > 0 && args[0] != "" {
+		return t.apply_genesis(stub, args[0])
 	}
 
 	return nil, nil
@@ -176,6 +266,107 @@ func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (str
 	return user, affiliation, nil
 }
 
+//==============================================================================================================================
+//	 get_tx_time - Returns the transaction timestamp in seconds since the epoch. Every peer sees the same value, unlike
+//				   the local clock. Returns 0 when the stub has no timestamp, e.g. under MockStub.
+//==============================================================================================================================
+func (t *SimpleChaincode) get_tx_time(stub shim.ChaincodeStubInterface) int64 {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil { return 0 }
+	return ts.Seconds
+}
+
+//==============================================================================================================================
+//	 check_authority - Returns an error unless the caller is the regulator.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_authority(stub shim.ChaincodeStubInterface) error {
+
+	_, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+	if caller_affiliation != AUTHORITY { return errors.New("Permission Denied. Only the regulator may perform this operation") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 check_admin - Returns an error unless the caller is the regulator or one of the admin identities named in the
+//				   genesis document.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_admin(stub shim.ChaincodeStubInterface) error {
+
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+	if caller_affiliation == AUTHORITY { return nil }
+
+	admins, err := t.retrieve_ids(stub, "adminIDs", "admins")
+	if err != nil { return err }
+	for _, admin := range admins {
+		if admin == caller && caller != "" { return nil }
+	}
+	return errors.New("Permission Denied. Only the regulator or an admin may perform this operation")
+}
+
+//==============================================================================================================================
+//	 check_customer_caller - Returns an error unless the caller is the customer, identified by a username equal to the
+//							 customerID. Nobody else may move or pool a customer's points.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_customer_caller(stub shim.ChaincodeStubInterface, customerID string) error {
+
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+
+	if caller_affiliation == CUSTOMER && caller == customerID && customerID != "" { return nil }
+	return errors.New("Permission Denied. Only customer " + customerID + " may perform this operation")
+}
+
+//==============================================================================================================================
+//	 check_customer_view - Returns an error unless the caller is the customer or the regulator, who may see a customer's
+//						   records but not act on them.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_customer_view(stub shim.ChaincodeStubInterface, customerID string) error {
+
+	if t.check_authority(stub) == nil { return nil }
+	return t.check_customer_caller(stub, customerID)
+}
+
+//==============================================================================================================================
+//	 check_program_caller - Program wide rules, such as the category tree and the earn rules, are managed only by the
+//							airline and the regulator.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_program_caller(stub shim.ChaincodeStubInterface) error {
+
+	_, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { return errors.New("Error retrieving caller information") }
+	if caller_affiliation != AUTHORITY && caller_affiliation != AIRLINES { return errors.New("Permission Denied. Program rules are managed by the airline or the regulator") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 check_partner_self - Returns an error unless the caller is the regulator or a partner whose certificate carries a
+//						  partnerId attribute of partnerID.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_partner_self(stub shim.ChaincodeStubInterface, partnerID string) error {
+
+	if t.check_authority(stub) == nil { return nil }
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return err }
+
+	ok, err := stub.VerifyAttribute("partnerId", []byte(partnerID))
+	if err != nil || !ok || partnerID == "" { return errors.New("Permission Denied. Caller does not act for partner " + partnerID) }
+	return nil
+}
+
+//==============================================================================================================================
+//	 check_acts_for - Returns an error unless the caller may manage partnerID's own records: the airline, the regulator,
+//					  or the partner itself.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_acts_for(stub shim.ChaincodeStubInterface, partnerID string) error {
+
+	if t.check_program_caller(stub) == nil { return nil }
+	return t.check_partner_self(stub, partnerID)
+}
+
 //==============================================================================================================================
 //	 retrieve_customer - Gets the state of the data at customerID in the ledger then converts it from the stored
 //					JSON into the Customer struct for use in the contract. Returns the Vehcile struct.
@@ -235,6 +426,25 @@ func (t *SimpleChaincode) retrieve_pos(stub shim.ChaincodeStubInterface, posID s
 	return v, nil
 }
 
+//==============================================================================================================================
+//	 retrieve_partner - Gets the state of the data at partnerID in the ledger then converts it from the stored
+//					JSON into the Partner struct for use in the contract. Returns empty v if it errors.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_partner(stub shim.ChaincodeStubInterface, partnerID string) (Partner, error) {
+
+	var v Partner
+
+	bytes, err := stub.GetState(partnerID);
+
+	if err != nil {	fmt.Printf("RETRIEVE_PARTNER: Failed to invoke partnerID: %s", err); return v, errors.New("RETRIEVE_PARTNER: Error retrieving Partner with partnerID = " + partnerID) }
+
+	err = json.Unmarshal(bytes, &v);
+
+    if err != nil {	fmt.Printf("RETRIEVE_PARTNER: Corrupt Partner record "+string(bytes)+": %s", err); return v, errors.New("RETRIEVE_PARTNER: Corrupt Partner record"+string(bytes))	}
+
+	return v, nil
+}
+
 //==============================================================================================================================
 // save_changes - Writes to the ledger the Customer struct passed in a JSON format. Uses the shim file's
 //				  method 'PutState'.
@@ -262,7 +472,7 @@ func (t *SimpleChaincode) save_changes_pos(stub shim.ChaincodeStubInterface, v P
 
 	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting pos record: %s", err); return false, errors.New("Error converting pos record") }
 
-	err = stub.PutState(v.PoSName, bytes)
+	err = stub.PutState(v.PoSID, bytes)
 
 	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing pos record: %s", err); return false, errors.New("Error storing pos record") }
 
@@ -279,44 +489,289 @@ func (t *SimpleChaincode) save_changes_item(stub shim.ChaincodeStubInterface, v
 
 	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting pos record: %s", err); return false, errors.New("Error converting pos record") }
 
-	err = stub.PutState(v.ItemName, bytes)
+	err = stub.PutState(v.ItemID, bytes)
 
 	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing pos record: %s", err); return false, errors.New("Error storing pos record") }
 
 	return true, nil
 }
 
+//==============================================================================================================================
+// save_changes_partner - Writes to the ledger the Partner struct passed in a JSON format. Uses the shim file's
+//				  method 'PutState'.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_partner(stub shim.ChaincodeStubInterface, v Partner) (bool, error) {
+
+	bytes, err := json.Marshal(v)
+
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting partner record: %s", err); return false, errors.New("Error converting partner record") }
+
+	err = stub.PutState(v.PartnerID, bytes)
+
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing partner record: %s", err); return false, errors.New("Error storing partner record") }
+
+	return true, nil
+}
+
+//==============================================================================================================================
+// save_purchase - Writes the Purchase record to the ledger and returns it as JSON for the caller.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_purchase(stub shim.ChaincodeStubInterface, v Purchase) ([]byte, error) {
+
+	v.TxID = stub.GetTxID()
+	v.Timestamp = t.get_tx_time(stub)
+
+	bytes, err := json.Marshal(v)
+
+	if err != nil { fmt.Printf("SAVE_PURCHASE: Error converting purchase record: %s", err); return nil, errors.New("Error converting purchase record") }
+
+	err = stub.PutState("purchase_" + v.TxID, bytes)
+
+	if err != nil { fmt.Printf("SAVE_PURCHASE: Error storing purchase record: %s", err); return nil, errors.New("Error storing purchase record") }
+
+	err = t.record_merchant_sale(stub, v)
+	if err != nil { fmt.Printf("SAVE_PURCHASE: Error recording merchant sale: %s", err); return nil, errors.New("Error recording merchant sale") }
+
+	return bytes, nil
+}
+
 //==============================================================================================================================
 //	 Router Functions
 //==============================================================================================================================
-//	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
-//		  initial arguments passed to other things for use in the called function e.g. name -> ecert
+//	Invoke - Called on chaincode invoke. Calls the function through invoke and records the call in the audit log, see
+//		  Audit.go. The function's writes are held back and dropped if it fails, and its error is returned; the
+//		  failure's audit entry is logged and sent as the invokeFailed event, as the peer drops it with the rest.
 //==============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
+	tx := new_buffered_stub(stub)
+
+	result, err := t.invoke(tx, function, args)
+	if err == nil { err = tx.commit() }
+	if err != nil { t.report_failure(stub, t.audit_entry(stub, function, args, err)); return nil, err }
+
+	err = t.record_audit(stub, t.audit_entry(stub, function, args, nil))
+	if err != nil { fmt.Printf("INVOKE: Error recording audit: %s", err); return nil, err }
+	return result, nil
+}
+
+//==============================================================================================================================
+//	invoke - Takes a function name passed and calls that function. Converts some initial arguments passed to other
+//		  things for use in the called function e.g. name -> ecert
+//==============================================================================================================================
+func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
 	if function == "create_customer" {
-        return t.create_customer(stub, args[0])
+		for len(args) < 2 { args = append(args, "") }							// customerID and referrer are both optional
+        return t.create_customer(stub, args[0], args[1])
+	} else if function == "create_pos" {
+		caller, caller_affiliation, err := t.get_caller_data(stub)
+		if err != nil { return nil, errors.New("Error retrieving caller information") }
+		err = t.check_partner_caller(stub)
+		if err != nil { return nil, err }
+		for len(args) < 3 { args = append(args, "") }
+		return t.create_pos(stub, caller, caller_affiliation, args[0], args[1], args[2])
+	} else if function == "create_item" {
+		if len(args) != 4 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected itemID, posID, itemName and price") }
+		caller, caller_affiliation, err := t.get_caller_data(stub)
+		if err != nil { return nil, errors.New("Error retrieving caller information") }
+		err = t.check_partner_caller(stub)
+		if err != nil { return nil, err }
+		price, err := strconv.Atoi(args[3])
+		if err != nil { return nil, errors.New("Invalid price " + args[3]) }
+		return t.create_item(stub, caller, caller_affiliation, args[0], args[1], args[2], price)
 	} else if function == "ping" {
         return t.ping(stub)
+	} else if function == "bulk_create_customers" {
+		if len(args) < 2 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected format, payload and optional partnerId awarding opening balances") }
+		for len(args) < 3 { args = append(args, "") }
+		return t.bulk_create_customers(stub, args[0], args[1], args[2])
+	} else if function == "bulk_create_pos" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected format and payload") }
+		return t.bulk_create_pos(stub, args[0], args[1])
+	} else if function == "bulk_create_items" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected format and payload") }
+		return t.bulk_create_items(stub, args[0], args[1])
+	} else if function == "import_ledger" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected an export page") }
+		return t.import_ledger(stub, args[0])
+	} else if function == "abort_import" {
+		return t.abort_import(stub)
+	} else if function == "update_program_config" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected version and config") }
+		return t.update_program_config(stub, args[0], args[1])
+	} else if function == "create_campaign" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected campaign JSON") }
+		return t.create_campaign(stub, args[0])
+	} else if function == "end_campaign" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected campaignId") }
+		return t.end_campaign(stub, args[0])
+	} else if function == "create_category" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected category JSON") }
+		return t.create_category(stub, args[0])
+	} else if function == "update_category" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected category JSON") }
+		return t.update_category(stub, args[0])
+	} else if function == "assign_item_category" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected itemID and categoryId") }
+		return t.assign_item_category(stub, args[0], args[1])
+	} else if function == "create_earn_rule" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected earn rule JSON") }
+		return t.create_earn_rule(stub, args[0])
+	} else if function == "update_earn_rule" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected earn rule JSON") }
+		return t.update_earn_rule(stub, args[0])
+	} else if function == "create_frequency_program" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected frequency program JSON") }
+		return t.create_frequency_program(stub, args[0])
+	} else if function == "end_frequency_program" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected programId") }
+		return t.end_frequency_program(stub, args[0])
+	} else if function == "create_pool" {
+		if len(args) < 2 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected poolId, owner customerID and optional name") }
+		for len(args) < 3 { args = append(args, "") }
+		return t.create_pool(stub, args[0], args[1], args[2])
+	} else if function == "set_pool_member" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected poolId and member JSON") }
+		return t.set_pool_member(stub, args[0], args[1])
+	} else if function == "remove_pool_member" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected poolId and customerID") }
+		return t.remove_pool_member(stub, args[0], args[1])
+	} else if function == "accept_pool_invite" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected poolId and customerID") }
+		return t.accept_pool_invite(stub, args[0], args[1])
+	} else if function == "send_gift" {
+		if len(args) < 3 || len(args) > 5 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected sender, recipient, points, optional message and claim code") }
+		for len(args) < 5 { args = append(args, "") }
+		return t.send_gift(stub, args[0], args[1], args[2], args[3], args[4])
+	} else if function == "accept_gift" {
+		if len(args) < 2 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected giftId, customerID and optional claim code") }
+		for len(args) < 3 { args = append(args, "") }
+		return t.accept_gift(stub, args[0], args[1], args[2])
+	} else if function == "decline_gift" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected giftId and customerID") }
+		return t.decline_gift(stub, args[0], args[1])
+	} else if function == "return_expired_gifts" {
+		return t.return_expired_gifts(stub)
+	} else if function == "register_charity" {
+		if len(args) < 1 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected charityId, optional name and manager") }
+		for len(args) < 3 { args = append(args, "") }
+		return t.register_charity(stub, args[0], args[1], args[2])
+	} else if function == "approve_charity" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected charityId") }
+		return t.approve_charity(stub, args[0])
+	} else if function == "donate_points" {
+		if len(args) != 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected customerID, charityId and points") }
+		return t.donate_points(stub, args[0], args[1], args[2])
+	} else if function == "authorise_charity_redemption" {
+		if len(args) < 2 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected charityId, itemID and optional points") }
+		requested := -1
+		if len(args) == 3 {
+			var err error
+			requested, err = strconv.Atoi(args[2])
+			if err != nil || requested < 0 { return nil, errors.New("Invalid points " + args[2]) }
+		}
+		return t.authorise_charity_redemption(stub, args[0], args[1], requested)
+	} else if function == "charity_redeem" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected authId") }
+		return t.charity_redeem(stub, args[0])
+	} else if function == "purchase_float" {
+		if len(args) < 2 || len(args) > 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected partnerId, points and optional reference") }
+		for len(args) < 3 { args = append(args, "") }
+		return t.purchase_float(stub, args[0], args[1], args[2])
+	} else if function == "set_float_threshold" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected partnerId and points") }
+		return t.set_float_threshold(stub, args[0], args[1])
+	} else if function == "create_loyalty_program" {
+		if len(args) != 4 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected programId, name, partnerId and manager") }
+		return t.create_loyalty_program(stub, args[0], args[1], args[2], args[3])
+	} else if function == "award_program_points" {
+		if len(args) != 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected programId, customerID and points") }
+		return t.award_program_points(stub, args[0], args[1], args[2])
+	} else if function == "propose_exchange_rate" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected exchange rate JSON") }
+		return t.propose_exchange_rate(stub, args[0])
+	} else if function == "approve_exchange_rate" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected fromProgram and toProgram") }
+		return t.approve_exchange_rate(stub, args[0], args[1])
+	} else if function == "exchange_points" {
+		if len(args) != 4 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected customerID, fromProgram, toProgram and points") }
+		return t.exchange_points(stub, args[0], args[1], args[2], args[3])
+	} else if function == "set_fee_schedule" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected fee schedule JSON") }
+		return t.set_fee_schedule(stub, args[0])
+	} else if function == "create_merchant" {
+		if len(args) < 3 || len(args) > 4 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId, name, partnerId and optional percentage") }
+		for len(args) < 4 { args = append(args, "") }
+		return t.create_merchant(stub, args[0], args[1], args[2], args[3])
+	} else if function == "add_merchant_pos" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId and posID") }
+		return t.add_merchant_pos(stub, args[0], args[1])
+	} else if function == "set_merchant_earn_rate" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId and percentage") }
+		return t.set_merchant_earn_rate(stub, args[0], args[1])
+	} else if function == "set_outlet_earn_rate" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected posID and percentage or default") }
+		return t.set_outlet_earn_rate(stub, args[0], args[1])
+	} else if function == "set_catalog_item" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId and catalog item JSON") }
+		return t.set_catalog_item(stub, args[0], args[1])
+	} else if function == "set_outlet_price" {
+		if len(args) != 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected posID, sku and price or default") }
+		return t.set_outlet_price(stub, args[0], args[1], args[2])
+	} else if function == "set_merchant_staff" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId and staff JSON") }
+		return t.set_merchant_staff(stub, args[0], args[1])
+	} else if function == "remove_merchant_staff" {
+		if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected merchantId and username") }
+		return t.remove_merchant_staff(stub, args[0], args[1])
+	} else if function == "freeze_entity" {
+		if len(args) < 4 || len(args) > 5 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected entity type, entityId, reason, case reference and optional expiry") }
+		for len(args) < 5 { args = append(args, "") }
+		return t.freeze_entity(stub, args[0], args[1], args[2], args[3], args[4])
+	} else if function == "unfreeze_entity" {
+		if len(args) != 3 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected entity type, entityId and reason") }
+		return t.unfreeze_entity(stub, args[0], args[1], args[2])
+	} else if function == "close_settlement_period" {
+		return t.close_settlement_period(stub)
+	} else if function == "settle_statement" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected statementId") }
+		return t.settle_statement(stub, args[0])
+	} else if function == "issue_voucher" {
+		if len(args) != 1 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected voucher JSON") }
+		return t.issue_voucher(stub, args[0])
     } else { 																	// If the function is not a create then there must be a car so we need to retrieve the customer.
 		argPos := 0
 		v, err := t.retrieve_customer(stub, args[argPos])
 
         if err != nil { fmt.Printf("INVOKE: Error retrieving Customer: %s", err); return nil, errors.New("Error retrieving customer") }
         if strings.Contains(function, "update") == false && function != "delete_customer"    {
+				voucher_code := ""
+				if len(args) > 3 { voucher_code = args[3] }							// Optional voucher code after the itemID
 				if function == "buy_item_by_money" {
 					argPos := 2
 					i, err := t.retrieve_item(stub, args[argPos])
 					if err != nil { fmt.Printf("INVOKE: Error retrieving Item: %s", err); return nil, errors.New("Error retrieving Item") }
-					return t.buy_item_by_money(stub, v, i)
-				} else if  function == "buy_item_by_wallet" {
+					return t.buy_item_by_money(stub, v, i, voucher_code)
+				} else if  function == "buy_item_by_wallet" || function == "buy_item_by_pool" {
 					argPos := 2
 					i, err := t.retrieve_item(stub, args[argPos])
 					if err != nil { fmt.Printf("INVOKE: Error retrieving Item: %s", err); return nil, errors.New("Error retrieving Item") }
-					return t.buy_item_by_wallet(stub, v,  i)
+					requested := -1												// Optional points to spend, otherwise as many as allowed
+					if len(args) > 4 {
+						requested, err = strconv.Atoi(args[4])
+						if err != nil || requested < 0 { return nil, errors.New("Invalid points " + args[4]) }
+					}
+					return t.buy_item_by_wallet(stub, v,  i, voucher_code, requested, function == "buy_item_by_pool")
 				}
 
-		} else if function == "update_name" { return t.update_name(stub, v, args[0]) }
+		} else if function == "update_name" { return t.update_name(stub, v, args[0])
+		} else if function == "update_tier" {
+			if len(args) != 2 { return nil, errors.New("INVOKE: Incorrect number of arguments passed, expected customerID and tier") }
+			caller, caller_affiliation, err := t.get_caller_data(stub)
+			if err != nil { return nil, errors.New("Error retrieving caller information") }
+			return t.update_tier(stub, v, caller, caller_affiliation, args[1])
+		}
 		return nil, errors.New("Function of the name "+ function +" doesn't exist.")
 
 	}
@@ -336,6 +791,76 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 		return t.check_unique_customer(stub, args[0])
 	} else if function == "get_customers" {
 		return t.get_customers(stub)
+	} else if function == "export_ledger" {
+		if len(args) < 1 || len(args) > 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected page and optional page size") }
+		size := ""
+		if len(args) == 2 { size = args[1] }
+		return t.export_ledger(stub, args[0], size)
+	} else if function == "get_program_config" {
+		return t.get_program_config(stub)
+	} else if function == "get_program_config_history" {
+		return t.get_program_config_history(stub)
+	} else if function == "get_campaigns" {
+		return t.get_campaigns(stub)
+	} else if function == "get_categories" {
+		return t.get_categories(stub)
+	} else if function == "get_earn_rules" {
+		return t.get_earn_rules(stub)
+	} else if function == "get_frequency_progress" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected customerID") }
+		return t.get_frequency_progress(stub, args[0])
+	} else if function == "get_exchange_rates" {
+		return t.get_exchange_rates(stub)
+	} else if function == "get_merchant" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected merchantId") }
+		return t.get_merchant(stub, args[0])
+	} else if function == "get_merchant_report" {
+		if len(args) < 1 || len(args) > 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected merchantId and optional period") }
+		for len(args) < 2 { args = append(args, "") }
+		return t.get_merchant_report(stub, args[0], args[1])
+	} else if function == "get_freezes" {
+		return t.get_freezes(stub)
+	} else if function == "get_freeze_log" {
+		if len(args) > 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected optional entity type and entityId") }
+		for len(args) < 2 { args = append(args, "") }
+		return t.get_freeze_log(stub, args[0], args[1])
+	} else if function == "get_audit_log" {
+		if len(args) > 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected optional filter JSON") }
+		for len(args) < 1 { args = append(args, "") }
+		return t.get_audit_log(stub, args[0])
+	} else if function == "get_liability_report" {
+		if len(args) > 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected optional partnerId and period") }
+		for len(args) < 2 { args = append(args, "") }
+		return t.get_liability_report(stub, args[0], args[1])
+	} else if function == "get_fee_statement" {
+		if len(args) != 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected partnerId and period") }
+		return t.get_fee_statement(stub, args[0], args[1])
+	} else if function == "get_float_statement" {
+		if len(args) != 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected partnerId and period") }
+		return t.get_float_statement(stub, args[0], args[1])
+	} else if function == "get_statements" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected partnerId") }
+		return t.get_statements(stub, args[0])
+	} else if function == "simulate_earn" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected purchase JSON") }
+		return t.simulate_earn(stub, args[0])
+	} else if function == "get_voucher" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected voucher code") }
+		return t.get_voucher(stub, args[0])
+	} else if function == "get_referrals" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected customerID") }
+		return t.get_referrals(stub, args[0])
+	} else if function == "get_pool" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected poolId") }
+		return t.get_pool(stub, args[0])
+	} else if function == "get_gifts" {
+		if len(args) != 1 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected customerID") }
+		return t.get_gifts(stub, args[0])
+	} else if function == "get_charities" {
+		return t.get_charities(stub)
+	} else if function == "get_donation_receipt" {
+		if len(args) != 2 { return nil, errors.New("QUERY: Incorrect number of arguments passed, expected customerID and year") }
+		return t.get_donation_receipt(stub, args[0], args[1])
 	} else if function == "ping" {
 		return t.ping(stub)
 	}
@@ -356,46 +881,43 @@ func (t *SimpleChaincode) ping(stub shim.ChaincodeStubInterface) ([]byte, error)
 //=================================================================================================================================
 //	 Create Function
 //=================================================================================================================================
-//	 Create Customer - Creates the initial JSON for the vehcile and then saves it to the ledger.
+//	 Create Customer - Creates the customer record and then saves it to the ledger. Returns the customerID, which is
+//					   generated when customerID is empty and the customer ID policy allows it. A non empty
+//					   referrerID records a pending referral, see Referral.go.
 //=================================================================================================================================
-func (t *SimpleChaincode) create_customer(stub shim.ChaincodeStubInterface, customerID string) ([]byte, error) {
-	var v Customer
+func (t *SimpleChaincode) create_customer(stub shim.ChaincodeStubInterface, customerID string, referrerID string) ([]byte, error) {
 
-	customerId		:= "\"CustomerID\":\""+customerID+"\", "							// Variables to define the JSON
-	name            := "\"Name\":\""+customerID+"\", "
-	address			:= "\"Address\":\"UNDEFINED\", "
-	cashback		:= "\"Cashback\":0, "
-	email			:= "\"Email\":\"UNDEFINED\", "
-	phone			:= "\"Phone\":\"UNDEFINED\", "
-	status			:= "\"Status\":true"
-		
-	customer_json := "{"+customerId+name+address+cashback+email+phone+status+"}" 	// Concatenates the variables to create the total JSON object
-	matched, err := regexp.Match("^[A-z][A-z][0-9]{7}", []byte(customerID))  				// matched = true if the customerId passed fits format of two letters followed by seven digits
-	if err != nil { fmt.Printf("CREATE_CUSTOMER: Invalid customerID: %s", err); return nil, errors.New("Invalid customerID") }
-	
-	if	customerID  == "" || matched == false {
-		fmt.Printf("CREATE_CUSTOMER: Invalid customerID provided");
-		return nil, errors.New("Invalid customerID provided "+customerID)
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if customerID == "" {														// Leave the customerID empty to have one generated
+		customerID, err = t.generate_id(stub, config, ENTITY_CUSTOMER, nil)
+		if err != nil { fmt.Printf("CREATE_CUSTOMER: %s", err); return nil, err }
 	}
 
-	err = json.Unmarshal([]byte(customer_json), &v)	// Convert the JSON defined above into a customer object for go
-	if err != nil { return nil, errors.New("Invalid JSON object") }
+	err = config.check_id(ENTITY_CUSTOMER, customerID, "")
+	if err != nil { fmt.Printf("CREATE_CUSTOMER: Invalid customerID provided"); return nil, err }
+
+	v := Customer{CustomerID: customerID, Name: customerID, Address: "UNDEFINED", Cashback: 0, Email: "UNDEFINED", Phone: "UNDEFINED", Status: true, ReferredBy: referrerID}
+
 	record, err := stub.GetState(v.CustomerID) 								// If not an error then a record exists so cant create a new car with this customerId as it must be unique
 	if record != nil { return nil, errors.New("Customer already exists") }
-	
+
+	if referrerID != "" {
+		err = t.check_referrer(stub, config, customerID, referrerID)
+		if err != nil { fmt.Printf("CREATE_CUSTOMER: %s", err); return nil, err }
+	}
+
 	_, err  = t.save_changes(stub, v)
 	if err != nil { fmt.Printf("CREATE_CUSTOMER: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-	bytes, err := stub.GetState("customerIDs")
-	if err != nil { return nil, errors.New("Unable to get customerID") }
-	var customerIDs CustomerID_Holder
-	err = json.Unmarshal(bytes, &customerIDs)
-	if err != nil {	return nil, errors.New("Corrupt Customer record") }
-	customerIDs.Customers = append(customerIDs.Customers, customerID)
-	bytes, err = json.Marshal(customerIDs)
-	if err != nil { fmt.Print("Error creating Customer record") }
-	err = stub.PutState("customerIDs", bytes)
-	if err != nil { return nil, errors.New("Unable to put the state") }
-	return nil, nil
+	err = t.append_ids(stub, "customerIDs", "customers", []string{customerID})
+	if err != nil { return nil, err }
+
+	if referrerID != "" {
+		err = t.create_referral(stub, customerID, referrerID)
+		if err != nil { return nil, err }
+	}
+	return []byte(customerID), nil
 }
 
 //=================================================================================================================================
@@ -436,6 +958,12 @@ func (t *SimpleChaincode) update_address(stub shim.ChaincodeStubInterface, v Cus
 func (t *SimpleChaincode) update_cashback(stub shim.ChaincodeStubInterface, v Customer, caller string, caller_affiliation string, new_value int) ([]byte, error) {
 
 	if 	v.Status == true {
+		config, err := t.retrieve_program_config(stub)
+		if err != nil { return nil, err }
+		change := Liability_Movement{}											// A correction down writes the points off
+		if new_value > v.Cashback { change.Issued = new_value - v.Cashback } else { change.Expired = v.Cashback - new_value }
+		err = t.record_liability(stub, config.issuer_liability_partner(), change)
+		if err != nil { return nil, err }
 		v.Cashback = new_value
 	} else {
 		return nil, errors.New(fmt.Sprint("Not found"))
@@ -463,44 +991,57 @@ func (t *SimpleChaincode) update_email(stub shim.ChaincodeStubInterface, v Custo
 }
 
 //=================================================================================================================================
-//	 Create PoS - Creates the initial JSON for the vehcile and then saves it to the ledger.
+//	 update_tier - Tiers are set by the airline or the regulator. Campaigns can target them.
 //=================================================================================================================================
-func (t *SimpleChaincode) create_pos(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, posID string) ([]byte, error) {
-	var v PoS
+func (t *SimpleChaincode) update_tier(stub shim.ChaincodeStubInterface, v Customer, caller string, caller_affiliation string, new_value string) ([]byte, error) {
 
-	posId		:= "\"PoSID\":\""+posID+"\", "							// Variables to define the JSON
-	posName     := "\"PoSName\":0, "
-	status			:= "\"Status\":\"true\", "
-	percentage		:= "\"LoyaltyPercentage\":\"5%\", "
-	
-	
-	pos_json := "{"+posId+posName+status+percentage+"}" 	// Concatenates the variables to create the total JSON object
-	matched, err := regexp.Match("^[A-z][A-z][0-9]{7}", []byte(posId))  				// matched = true if the customerId passed fits format of two letters followed by seven digits
-	if err != nil { fmt.Printf("CREATE_POS: Invalid posID: %s", err); return nil, errors.New("Invalid posID") }
+	if caller_affiliation != AUTHORITY && caller_affiliation != AIRLINES { return nil, errors.New("Permission Denied. update_tier is not allowed for role " + caller_affiliation) }
 
-	if posId  == "" ||	matched == false {
-		fmt.Printf("CREATE_POS: Invalid posID provided");
-		return nil, errors.New("Invalid posID provided")
+	if 	v.Status == true {
+		v.Tier = new_value
+	} else {
+		return nil, errors.New(fmt.Sprint("Not found"))
 	}
-	
-	err = json.Unmarshal([]byte(pos_json), &v)	// Convert the JSON defined above into a PoS object for go
-	if err != nil { return nil, errors.New("Invalid JSON object") }
+
+	_, err := t.save_changes(stub, v)
+	if err != nil { fmt.Printf("UPDATE_TIER: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 Create PoS - Creates the PoS record and then saves it to the ledger. Returns the posID, which is generated when
+//				  posID is empty and the PoS ID policy allows it.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_pos(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, posID string, posName string, partnerID string) ([]byte, error) {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if partnerID != "" {
+		_, err = t.retrieve_partner(stub, partnerID)
+		if err != nil { return nil, errors.New("Unknown partnerId " + partnerID) }
+	}
+
+	if posID == "" {
+		posID, err = t.generate_id(stub, config, ENTITY_POS, nil)
+		if err != nil { fmt.Printf("CREATE_POS: %s", err); return nil, err }
+	}
+
+	err = config.check_id(ENTITY_POS, posID, partnerID)
+	if err != nil { fmt.Printf("CREATE_POS: Invalid posID provided"); return nil, err }
+
+	if posName == "" { posName = posID }
+
+	v := PoS{PoSID: posID, PoSName: posName, Status: true, LoyaltyPercentage: config.DefaultEarnRate, PartnerID: partnerID}
+
 	record, err := stub.GetState(v.PoSID) 								// If not an error then a record exists so cant create a new car with this CustomerID as it must be unique
 	if record != nil { return nil, errors.New("POS already exists") }
-	
+
 	_, err  = t.save_changes_pos(stub, v)
 	if err != nil { fmt.Printf("CREATE_POS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-	bytes, err := stub.GetState("posID")
-	if err != nil { return nil, errors.New("Unable to get PoSID") }
-	var posIDs PoSID_Holder
-	err = json.Unmarshal(bytes, &posIDs)
-	if err != nil {	return nil, errors.New("Corrupt PoS record") }
-	posIDs.PoSIDs = append(posIDs.PoSIDs, posID)
-	bytes, err = json.Marshal(posIDs)
-	if err != nil { fmt.Print("Error creating PoS record") }
-	err = stub.PutState("posIDs", bytes)
-	if err != nil { return nil, errors.New("Unable to put the state") }
-	return nil, nil
+	err = t.append_ids(stub, "posIDs", "posIDs", []string{posID})
+	if err != nil { return nil, err }
+	return []byte(posID), nil
 }
 
 //=================================================================================================================================
@@ -526,6 +1067,7 @@ func (t *SimpleChaincode) update_percentage(stub shim.ChaincodeStubInterface, v
 
 	if 	v.Status == true {
 		v.LoyaltyPercentage = new_value
+		v.RateOverride = v.MerchantID != ""									// A merchant outlet keeps its own rate from now on
 	} else {
 		return nil, errors.New(fmt.Sprint("Not found"))
 	}
@@ -536,44 +1078,38 @@ func (t *SimpleChaincode) update_percentage(stub shim.ChaincodeStubInterface, v
 }
 
 //=================================================================================================================================
-//	 Create PoS - Creates the initial JSON for the vehcile and then saves it to the ledger.
+//	 Create Item - Creates the item record for a PoS and then saves it to the ledger. Returns the itemID, which is
+//				   generated when itemID is empty and the item ID policy allows it.
 //=================================================================================================================================
-func (t *SimpleChaincode) create_item(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, itemID string) ([]byte, error) {
-	var v Item
+func (t *SimpleChaincode) create_item(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, itemID string, posID string, itemName string, price int) ([]byte, error) {
 
-	itemId		:= "\"ItemID\":\""+itemID+"\", "							// Variables to define the JSON
-	posID		:= "\"PoSID\":0, "
-	itemName	:= "\"Active\":\"UNDEFINED\", "
-	price		:= "\"Price\":\"500\", "
-	
-	
-	item_json := "{"+itemId+posID+itemName+price+"}" 	// Concatenates the variables to create the total JSON object
-	matched, err := regexp.Match("^[A-z][A-z][0-9]{7}", []byte(itemId))  				// matched = true if the customerId passed fits format of two letters followed by seven digits
-	if err != nil { fmt.Printf("CREATE_ITEM: Invalid itemID: %s", err); return nil, errors.New("Invalid itemID") }
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	p, err := t.retrieve_pos(stub, posID)
+	if err != nil { return nil, errors.New("Unknown posId " + posID) }
 
-	if itemId  == "" ||	matched == false {
-		fmt.Printf("CREATE_ITEM: Invalid itemID provided");
-		return nil, errors.New("Invalid itemID provided")
+	if itemID == "" {
+		itemID, err = t.generate_id(stub, config, ENTITY_ITEM, nil)
+		if err != nil { fmt.Printf("CREATE_ITEM: %s", err); return nil, err }
 	}
-	
-	err = json.Unmarshal([]byte(item_json), &v)	// Convert the JSON defined above into a PoS object for go
-	if err != nil { return nil, errors.New("Invalid JSON object") }
+
+	err = config.check_id(ENTITY_ITEM, itemID, p.PartnerID)
+	if err != nil { fmt.Printf("CREATE_ITEM: Invalid itemID provided"); return nil, err }
+
+	if price <= 0 { return nil, errors.New("Price must be greater than 0") }
+	if itemName == "" { itemName = itemID }
+
+	v := Item{ItemID: itemID, PoSID: posID, ItemName: itemName, Price: price}
+
 	record, err := stub.GetState(v.ItemID) 								// If not an error then a record exists so cant create a new car with this CustomerID as it must be unique
 	if record != nil { return nil, errors.New("Item already exists") }
-	
+
 	_, err  = t.save_changes_item(stub, v)
-	if err != nil { fmt.Printf("CREATE_POS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-	bytes, err := stub.GetState("itemID")
-	if err != nil { return nil, errors.New("Unable to get ItemID") }
-	var itemIDs ItemID_Holder
-	err = json.Unmarshal(bytes, &itemIDs)
-	if err != nil {	return nil, errors.New("Corrupt Item record") }
-	itemIDs.ItemIDs = append(itemIDs.ItemIDs, itemId)
-	bytes, err = json.Marshal(itemIDs)
-	if err != nil { fmt.Print("Error creating Item record") }
-	err = stub.PutState("itemIDs", bytes)
-	if err != nil { return nil, errors.New("Unable to put the state") }
-	return nil, nil
+	if err != nil { fmt.Printf("CREATE_ITEM: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	err = t.append_ids(stub, "itemIDs", "itemIDIDs", []string{itemID})
+	if err != nil { return nil, err }
+	return []byte(itemID), nil
 }
 
 //=================================================================================================================================
@@ -679,12 +1215,59 @@ func (t *SimpleChaincode) check_unique_customer(stub shim.ChaincodeStubInterface
 //=================================================================================================================================
 //	 buy_item_by_money
 //=================================================================================================================================
-func (t *SimpleChaincode) buy_item_by_money(stub shim.ChaincodeStubInterface, v Customer, i Item) ([]byte, error) {
+func (t *SimpleChaincode) buy_item_by_money(stub shim.ChaincodeStubInterface, v Customer, i Item, voucher_code string) ([]byte, error) {
+
+	var points int
+	var campaignID string
+	var voucher Voucher
+	var earn Earn_Result
+	var pooled int
+	var pooled_into string
+	var frequency Frequency_Reward
+	var payment string
+	price := i.Price
 
 	if v.Status == true {
+		config, err := t.retrieve_program_config(stub)
+		if err != nil { return nil, err }
 		p, err := t.retrieve_pos(stub, i.PoSID)
 		if err != nil { fmt.Printf("INVOKE: Error retrieving PoS: %s", err); return nil, errors.New("Error retrieving PoS") }
-		v.Cashback = v.Cashback + (p.LoyaltyPercentage * i.Price)/100
+		err = t.check_pos_operator(stub, p)
+		if err != nil { return nil, err }
+		err = t.check_not_frozen(stub, ENTITY_CUSTOMER, v.CustomerID)
+		if err != nil { return nil, err }
+		err = t.check_pos_not_frozen(stub, p)
+		if err != nil { return nil, err }
+		if voucher_code != "" {
+			voucher, err = t.redeem_voucher(stub, voucher_code, v, i, p)
+			if err != nil { fmt.Printf("buy_item_by_money: Voucher rejected: %s", err); return nil, err }
+			price = voucher.price(i.Price)
+		}
+		frequency, err = t.apply_frequency(stub, v, p, price)					// Frequent visitors pay less and earn more
+		if err != nil { fmt.Printf("buy_item_by_money: Error applying frequency programs: %s", err); return nil, errors.New("Error applying frequency programs") }
+		price = price - price * frequency.DiscountPercent / 100
+		payment, err = t.pay(stub, config, v, p, price)							// Points are only earned once the money has moved, see Payments.go
+		if err != nil { fmt.Printf("buy_item_by_money: %s", err); return nil, err }
+		now := t.get_tx_time(stub)
+		earn, err = t.evaluate_earn_rules(stub, v.Tier, i, p, price, now)			// See EarnRule.go for how the rules stack
+		if err != nil { fmt.Printf("buy_item_by_money: Error evaluating earn rules: %s", err); return nil, errors.New("Error evaluating earn rules") }
+		points, campaignID, err = t.apply_campaigns(stub, v, i, p, earn.Points, now)	// Best running campaign, if any beats the rules
+		if err != nil { fmt.Printf("buy_item_by_money: Error applying campaigns: %s", err); return nil, errors.New("Error applying campaigns") }
+		points = points * frequency.MultiplierPercent / 100 + voucher.bonus()
+		err = t.draw_float(stub, p, v.CustomerID, points)							// Partners award from their prepaid float, see Float.go
+		if err != nil { fmt.Printf("buy_item_by_money: %s", err); return nil, err }
+		pooled, err = t.pool_contribution(stub, v, points)						// Contributing members earn into their pool
+		if err != nil { fmt.Printf("buy_item_by_money: Error paying into pool: %s", err); return nil, errors.New("Error paying into pool") }
+		v.Cashback = v.Cashback + points - pooled
+		if pooled > 0 { pooled_into = v.PoolID }
+		err = t.settle_earn(stub, p, points)								// Cross-partner earns are owed to the issuer, see Settlement.go
+		if err == nil { err = t.charge_fee(stub, p, FEE_ISSUE, points, points * config.PointCost / 100) }
+		if err != nil { fmt.Printf("buy_item_by_money: Error posting settlement: %s", err); return nil, errors.New("Error posting settlement") }
+		err = t.record_liability(stub, p.PartnerID, Liability_Movement{Issued: points})	// Liability report, see Liability.go
+		if err != nil { fmt.Printf("buy_item_by_money: %s", err); return nil, err }
+		bonus, err := t.reward_referral(stub, &v, price)						// First qualifying purchase of a referred customer
+		if err != nil { fmt.Printf("buy_item_by_money: Error rewarding referral: %s", err); return nil, errors.New("Error rewarding referral") }
+		points = points + bonus
 	} else {												// Otherwise if there is an error
 		fmt.Printf("buy_item_by_money: Customer Not Active");
         return nil, errors.New(fmt.Sprintf(" Customer Not Active."))
@@ -692,25 +1275,79 @@ func (t *SimpleChaincode) buy_item_by_money(stub shim.ChaincodeStubInterface, v
 	
 	_, err := t.save_changes(stub, v)						// Write new state
 	if err != nil {	fmt.Printf("buy_item_by_money: Error saving changes: %s", err); return nil, errors.New("Error saving changes")	}
-	return nil, nil									// We are Done
+	return t.save_purchase(stub, Purchase{CustomerID: v.CustomerID, ItemID: i.ItemID, PoSID: i.PoSID, Price: i.Price, Method: PURCHASE_BY_MONEY, PointsEarned: points, EarnRules: earn.rule_ids(), CampaignID: campaignID, FrequencyPrograms: frequency.ProgramIDs, VoucherID: voucher.VoucherID, Discount: i.Price - price, PoolID: pooled_into, PaymentRef: payment})
 }
 
-func (t *SimpleChaincode) buy_item_by_wallet(stub shim.ChaincodeStubInterface, v Customer, i Item) ([]byte, error) {
+func (t *SimpleChaincode) buy_item_by_wallet(stub shim.ChaincodeStubInterface, v Customer, i Item, voucher_code string, requested int, from_pool bool) ([]byte, error) {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+	if !config.feature_enabled(FEATURE_WALLET_PURCHASE) { return nil, errors.New("Paying with points is disabled") }
+
+	rules, err := t.category_rules(stub, i)
+	if err != nil { fmt.Printf("buy_item_by_wallet: Error retrieving category: %s", err); return nil, errors.New("Error retrieving category") }
+	if !rules.Redeemable { return nil, errors.New(" Items in category " + rules.CategoryID + " cannot be bought with points.") }
+
+	p, err := t.retrieve_pos(stub, i.PoSID)
+	if err != nil { fmt.Printf("buy_item_by_wallet: Error retrieving PoS: %s", err); return nil, errors.New("Error retrieving PoS") }
+
+	err = t.check_pos_operator(stub, p)
+	if err != nil { return nil, err }
+
+	err = t.check_not_frozen(stub, ENTITY_CUSTOMER, v.CustomerID)
+	if err != nil { return nil, err }
+	err = t.check_pos_not_frozen(stub, p)
+	if err != nil { return nil, err }
+
+	var voucher Voucher
+	var r Redemption
+	var drawn_from string
+	var payment string
+	cost := i.Price
 
 	if v.Status == true {
-		if v.Cashback > i.Price {
-			v.Cashback = v.Cashback - i.Price
+		if voucher_code != "" {
+			voucher, err = t.redeem_voucher(stub, voucher_code, v, i, p)
+			if err != nil { fmt.Printf("buy_item_by_wallet: Voucher rejected: %s", err); return nil, err }
+			cost = voucher.price(i.Price)
+		}
+		r, err = plan_redemption(config, p, cost, requested)				// Redemption limits, see Redemption.go
+		if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
+		payment, err = t.pay(stub, config, v, p, r.Cash)					// The part not paid with points, see Payments.go
+		if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
+
+		if from_pool {														// Household pool, see Pool.go
+			err = t.pool_withdrawal(stub, v, r.Points, i.ItemID)
+			if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
+			v.Cashback = v.Cashback + voucher.bonus()
+			drawn_from = v.PoolID
+		} else if v.Cashback - r.Points >= config.MinBalanceAfterRedemption {
+			v.Cashback = v.Cashback - r.Points + voucher.bonus()
 		} else {
 			fmt.Printf("buy_item_by_wallet: Not enough balance");
         	return nil, errors.New(fmt.Sprintf(" Not enough balance."))
 		}
+
+		err = t.record_burn(stub, config, v.CustomerID, r.Points)
+		if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
+
+		err = t.draw_float(stub, p, v.CustomerID, voucher.bonus())
+		if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
+
+		err = t.settle_burn(stub, p, r.Points, r.Value)						// The issuer owes other partners for what they deliver
+		if err == nil { err = t.settle_earn(stub, p, voucher.bonus()) }
+		if err == nil { err = t.charge_fee(stub, p, FEE_REDEMPTION, r.Points, r.Value) }
+		if err != nil { fmt.Printf("buy_item_by_wallet: Error posting settlement: %s", err); return nil, errors.New("Error posting settlement") }
+
+		err = t.record_liability(stub, p.PartnerID, Liability_Movement{Issued: voucher.bonus(), Redeemed: r.Points})
+		if err != nil { fmt.Printf("buy_item_by_wallet: %s", err); return nil, err }
 	} else {									// Otherwise if there is an error
 		fmt.Printf("buy_item_by_wallet: Customer Not Active");
         return nil, errors.New(fmt.Sprintf(" Customer Not Active."))
 	}
-	_, err := t.save_changes(stub, v)						// Write new state
+	_, err = t.save_changes(stub, v)						// Write new state
 	if err != nil {	fmt.Printf("buy_item_by_wallet: Error saving changes: %s", err); return nil, errors.New("Error saving changes")	}
-	return nil, nil									// We are Done
+	return t.save_purchase(stub, Purchase{CustomerID: v.CustomerID, ItemID: i.ItemID, PoSID: i.PoSID, Price: i.Price, Method: PURCHASE_BY_WALLET, PointsEarned: voucher.bonus(), PointsRedeemed: r.Points, CashPaid: r.Cash, VoucherID: voucher.VoucherID, Discount: i.Price - cost, PoolID: drawn_from, PaymentRef: payment})
 }
 
 //=================================================================================================================================
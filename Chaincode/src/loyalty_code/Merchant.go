@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   MERCHANT_PREFIX			=  "merchant_"
+const   MERCHANT_SALES_PREFIX	=  "merchantsales_"
+
+//==============================================================================================================================
+//	Merchant - A business running many outlets, e.g. a hotel chain. Each outlet is a PoS. The merchant's
+//			   DefaultEarnRate is the loyalty percentage of every outlet that does not override it, and every catalog
+//			   item is sold at every outlet as an Item the outlet may reprice. Owner is the username that created
+//			   the merchant and may do everything for it; Staff may do what their permissions allow at the outlets
+//			   they are scoped to. The outlet copies of catalog items get generated itemIDs, so the item ID policy
+//			   must have generation enabled before a merchant can have a catalog.
+//==============================================================================================================================
+type Merchant struct {
+	MerchantID		string				`json:"merchantId"`
+	Name			string				`json:"name"`
+	PartnerID		string				`json:"partnerId"`
+	Owner			string				`json:"owner"`
+	DefaultEarnRate	int					`json:"defaultEarnRate"`
+	PoSIDs			[]string			`json:"posIds"`
+	Catalog			[]Catalog_Item		`json:"catalog"`
+	Staff			[]Merchant_Staff	`json:"staff"`
+}
+
+//==============================================================================================================================
+//	Catalog Item - An item sold at every outlet of a merchant. Items maps each posID to the itemID of the outlet's copy.
+//==============================================================================================================================
+type Catalog_Item struct {
+	SKU				string				`json:"sku"`
+	ItemName		string				`json:"itemName"`
+	Price			int					`json:"price"`
+	CategoryID		string				`json:"categoryId,omitempty"`
+	Items			map[string]string	`json:"items"`
+}
+
+//==============================================================================================================================
+//	Merchant Staff - A username working for a merchant. Manage allows changing rates, the catalog and the outlets;
+//					 Reports allows the sales reports; Operate allows recording purchases at the outlets. PoSIDs limits
+//					 the staff member to those outlets, empty for all.
+//==============================================================================================================================
+type Merchant_Staff struct {
+	Username		string		`json:"username"`
+	Manage			bool		`json:"manage"`
+	Reports			bool		`json:"reports"`
+	Operate			bool		`json:"operate"`
+	PoSIDs			[]string	`json:"posIds"`
+}
+
+//==============================================================================================================================
+//	Merchant Sales - A merchant's purchases in one month, by outlet, stored under merchantsales_<merchantID>_<YYYY-MM>.
+//==============================================================================================================================
+type Merchant_Sales struct {
+	MerchantID		string					`json:"merchantId"`
+	Period			string					`json:"period"`
+	ByPoS			map[string]Sales_Total	`json:"byPos"`
+}
+
+type Sales_Total struct {
+	Purchases		int		`json:"purchases"`
+	Sales			int		`json:"sales"`
+	PointsEarned	int		`json:"pointsEarned"`
+	PointsRedeemed	int		`json:"pointsRedeemed"`
+}
+
+//==============================================================================================================================
+//	Merchant Report - Sales rolled up by outlet and for the whole merchant.
+//==============================================================================================================================
+type Merchant_Report struct {
+	MerchantID		string					`json:"merchantId"`
+	Periods			[]string				`json:"periods"`
+	Total			Sales_Total				`json:"total"`
+	ByPoS			map[string]Sales_Total	`json:"byPos"`
+}
+
+//==============================================================================================================================
+//	Merchant Holders - Define the structures that hold the merchantIDs and the <merchantID>_<period> keys of sales.
+//==============================================================================================================================
+type MerchantID_Holder struct {
+	MerchantIDs		[]string	`json:"merchantIDs"`
+}
+
+type MerchantSales_Holder struct {
+	Sales			[]string	`json:"sales"`
+}
+
+//==============================================================================================================================
+//	 retrieve_merchant - Gets the merchant stored for merchantID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_merchant(stub shim.ChaincodeStubInterface, merchantID string) (Merchant, error) {
+
+	var v Merchant
+
+	bytes, err := stub.GetState(MERCHANT_PREFIX + merchantID)
+	if err != nil { return v, errors.New("RETRIEVE_MERCHANT: Error retrieving Merchant with merchantID = " + merchantID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_MERCHANT: No Merchant with merchantID = " + merchantID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_MERCHANT: Corrupt Merchant record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_merchant - Writes the merchant to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_merchant(stub shim.ChaincodeStubInterface, v Merchant) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting merchant record: %s", err); return errors.New("Error converting merchant record") }
+
+	err = stub.PutState(MERCHANT_PREFIX + v.MerchantID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing merchant record: %s", err); return errors.New("Error storing merchant record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 staff_scope - Returns the outlets the caller may act on with the permission ("manage", "reports" or "operate", or "" for any
+//				   staff member), or nil with all set if the caller may act on every outlet. The owner may act
+//				   everywhere; admins and the regulator may only see the merchant and its reports.
+//==============================================================================================================================
+func (t *SimpleChaincode) staff_scope(stub shim.ChaincodeStubInterface, m Merchant, permission string) (all bool, posIDs []string, err error) {
+
+	if (permission == "" || permission == "reports") && t.check_admin(stub) == nil { return true, nil, nil }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return false, nil, errors.New("Error retrieving caller information") }
+	if caller != "" && caller == m.Owner { return true, nil, nil }
+
+	for _, s := range m.Staff {
+		if s.Username != caller { continue }
+		if (permission == "manage" && !s.Manage) || (permission == "reports" && !s.Reports) || (permission == "operate" && !s.Operate) { break }
+		return len(s.PoSIDs) == 0, s.PoSIDs, nil
+	}
+	return false, nil, errors.New("Permission Denied. Caller may not " + permission + " merchant " + m.MerchantID)
+}
+
+//==============================================================================================================================
+//	 check_merchant_caller - Returns an error unless the caller has the permission for outlet posID, or for the whole
+//							 merchant if posID is empty.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_merchant_caller(stub shim.ChaincodeStubInterface, m Merchant, posID string, permission string) error {
+
+	all, posIDs, err := t.staff_scope(stub, m, permission)
+	if err != nil || all { return err }
+
+	if posID != "" {
+		for _, id := range posIDs {
+			if id == posID { return nil }
+		}
+	}
+	return errors.New("Permission Denied. Caller may not " + permission + " outlet " + posID + " of merchant " + m.MerchantID)
+}
+
+//==============================================================================================================================
+//	 check_pos_operator - Returns an error unless the caller may record purchases at p: its certificate carries a posId
+//						  attribute naming p, or it operates p for the merchant that owns it.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_pos_operator(stub shim.ChaincodeStubInterface, p PoS) error {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return err }
+	if !config.feature_enabled(FEATURE_POS_AUTHORISATION) { return nil }
+
+	ok, err := stub.VerifyAttribute("posId", []byte(p.PoSID))
+	if err == nil && ok { return nil }
+
+	if p.MerchantID != "" {
+		m, err := t.retrieve_merchant(stub, p.MerchantID)
+		if err != nil { return err }
+		if t.check_merchant_caller(stub, m, p.PoSID, "operate") == nil { return nil }
+	}
+	return errors.New("Permission Denied. Caller is not an operator of PoS " + p.PoSID)
+}
+
+//==============================================================================================================================
+//	 outlet_item - Creates or updates outlet p's copy of catalog item c, keeping the outlet's price if it has repriced
+//				   it. Returns c with the copy recorded. taken holds the itemIDs generated in this transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) outlet_item(stub shim.ChaincodeStubInterface, config Program_Config, p PoS, c Catalog_Item, taken map[string]bool) (Catalog_Item, error) {
+
+	var i Item
+	var err error
+
+	if itemID, ok := c.Items[p.PoSID]; ok {
+		i, err = t.retrieve_item(stub, itemID)
+		if err != nil { return c, err }
+		if i.Override { i.ItemName, i.CategoryID = c.ItemName, c.CategoryID } else { i.ItemName, i.Price, i.CategoryID = c.ItemName, c.Price, c.CategoryID }
+	} else {
+		itemID, err := t.generate_id(stub, config, ENTITY_ITEM, taken)
+		if err != nil { return c, err }
+		taken[itemID] = true
+		err = config.check_id(ENTITY_ITEM, itemID, p.PartnerID)
+		if err != nil { return c, err }
+
+		i = Item{ItemID: itemID, PoSID: p.PoSID, ItemName: c.ItemName, Price: c.Price, CategoryID: c.CategoryID, SKU: c.SKU}
+
+		err = t.append_ids(stub, "itemIDs", "itemIDIDs", []string{itemID})
+		if err != nil { return c, err }
+		c.Items[p.PoSID] = itemID
+	}
+
+	_, err = t.save_changes_item(stub, i)
+	if err != nil { fmt.Printf("OUTLET_ITEM: Error saving changes: %s", err); return c, errors.New("Error saving changes") }
+	return c, nil
+}
+
+//=================================================================================================================================
+//	 create_merchant - Creates a merchant for a partner. The caller, who must act for the partner, becomes its owner.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_merchant(stub shim.ChaincodeStubInterface, merchantID string, name string, partnerID string, rate_arg string) ([]byte, error) {
+
+	err := t.check_acts_for(stub, partnerID)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if merchantID == "" { return nil, errors.New("Merchant must have a merchantId") }
+	_, err = t.retrieve_merchant(stub, merchantID)
+	if err == nil { return nil, errors.New("Merchant already exists") }
+
+	_, err = t.retrieve_partner(stub, partnerID)
+	if err != nil { return nil, errors.New("Unknown partnerId " + partnerID) }
+
+	rate := config.DefaultEarnRate
+	if rate_arg != "" {
+		rate, err = strconv.Atoi(rate_arg)
+		if err != nil || rate < 0 || rate > 100 { return nil, errors.New("Percentage must be between 0 and 100") }
+	}
+	if name == "" { name = merchantID }
+
+	err = t.save_changes_merchant(stub, Merchant{MerchantID: merchantID, Name: name, PartnerID: partnerID, Owner: caller, DefaultEarnRate: rate, PoSIDs: []string{}, Catalog: []Catalog_Item{}, Staff: []Merchant_Staff{}})
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "merchantIDs", "merchantIDs", []string{merchantID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 add_merchant_pos - Makes a PoS an outlet of the merchant. The outlet takes the merchant's earn rate and catalog.
+//						As the merchant's staff then operate the PoS, a manager may only adopt a PoS whose posId
+//						attribute their certificate carries, unless they are the airline or the regulator.
+//=================================================================================================================================
+func (t *SimpleChaincode) add_merchant_pos(stub shim.ChaincodeStubInterface, merchantID string, posID string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, "", "manage")
+	if err != nil { return nil, err }
+
+	if t.check_program_caller(stub) != nil {
+		ok, err := stub.VerifyAttribute("posId", []byte(posID))
+		if err != nil || !ok || posID == "" { return nil, errors.New("Permission Denied. Caller does not hold PoS " + posID) }
+	}
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	p, err := t.retrieve_pos(stub, posID)
+	if err != nil || p.PoSID == "" { return nil, errors.New("Unknown posId " + posID) }
+	if p.MerchantID != "" { return nil, errors.New("PoS " + posID + " already belongs to merchant " + p.MerchantID) }
+	if p.PartnerID != "" && p.PartnerID != m.PartnerID { return nil, errors.New("PoS " + posID + " belongs to another partner") }
+
+	p.MerchantID = merchantID
+	p.PartnerID = m.PartnerID
+	p.LoyaltyPercentage = m.DefaultEarnRate
+	p.RateOverride = false
+
+	_, err = t.save_changes_pos(stub, p)
+	if err != nil { fmt.Printf("ADD_MERCHANT_POS: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	taken := map[string]bool{}
+	for n, c := range m.Catalog {
+		m.Catalog[n], err = t.outlet_item(stub, config, p, c, taken)
+		if err != nil { return nil, err }
+	}
+	m.PoSIDs = append(m.PoSIDs, posID)
+
+	err = t.save_changes_merchant(stub, m)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_merchant_earn_rate - Changes the merchant's default earn rate and every outlet that has not overridden it.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_merchant_earn_rate(stub shim.ChaincodeStubInterface, merchantID string, rate_arg string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, "", "manage")
+	if err != nil { return nil, err }
+
+	rate, err := strconv.Atoi(rate_arg)
+	if err != nil || rate < 0 || rate > 100 { return nil, errors.New("Percentage must be between 0 and 100") }
+
+	m.DefaultEarnRate = rate
+
+	for _, posID := range m.PoSIDs {
+		p, err := t.retrieve_pos(stub, posID)
+		if err != nil { return nil, err }
+		if p.RateOverride { continue }
+
+		p.LoyaltyPercentage = rate
+		_, err = t.save_changes_pos(stub, p)
+		if err != nil { fmt.Printf("SET_MERCHANT_EARN_RATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	}
+
+	err = t.save_changes_merchant(stub, m)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_outlet_earn_rate - Overrides the earn rate of one outlet, or with "default" returns it to the merchant's.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_outlet_earn_rate(stub shim.ChaincodeStubInterface, posID string, rate_arg string) ([]byte, error) {
+
+	p, err := t.retrieve_pos(stub, posID)
+	if err != nil || p.PoSID == "" { return nil, errors.New("Unknown posId " + posID) }
+	if p.MerchantID == "" { return nil, errors.New("PoS " + posID + " does not belong to a merchant") }
+
+	m, err := t.retrieve_merchant(stub, p.MerchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, posID, "manage")
+	if err != nil { return nil, err }
+
+	if rate_arg == "default" {
+		p.LoyaltyPercentage = m.DefaultEarnRate
+		p.RateOverride = false
+	} else {
+		rate, err := strconv.Atoi(rate_arg)
+		if err != nil || rate < 0 || rate > 100 { return nil, errors.New("Percentage must be between 0 and 100") }
+		p.LoyaltyPercentage = rate
+		p.RateOverride = true
+	}
+
+	_, err = t.save_changes_pos(stub, p)
+	if err != nil { fmt.Printf("SET_OUTLET_EARN_RATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_catalog_item - Adds an item to the merchant's catalog, or changes one, and copies it to every outlet. Outlets
+//						that have repriced the item keep their price.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_catalog_item(stub shim.ChaincodeStubInterface, merchantID string, item_json string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, "", "manage")
+	if err != nil { return nil, err }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	var c Catalog_Item
+	err = json.Unmarshal([]byte(item_json), &c)
+	if err != nil { return nil, errors.New("Invalid catalog item JSON") }
+
+	if c.SKU == "" { return nil, errors.New("Catalog item must have a sku") }
+	if c.Price <= 0 { return nil, errors.New("Price must be greater than 0") }
+	if c.ItemName == "" { c.ItemName = c.SKU }
+	if c.CategoryID != "" {
+		_, err = t.retrieve_category(stub, c.CategoryID)
+		if err != nil { return nil, errors.New("Unknown categoryId " + c.CategoryID) }
+	}
+
+	position := -1
+	for n, existing := range m.Catalog {
+		if existing.SKU == c.SKU { position = n }
+	}
+
+	c.Items = map[string]string{}
+	if position >= 0 { c.Items = m.Catalog[position].Items }
+
+	taken := map[string]bool{}
+	for _, posID := range m.PoSIDs {
+		p, err := t.retrieve_pos(stub, posID)
+		if err != nil { return nil, err }
+
+		c, err = t.outlet_item(stub, config, p, c, taken)
+		if err != nil { return nil, err }
+	}
+
+	if position >= 0 { m.Catalog[position] = c } else { m.Catalog = append(m.Catalog, c) }
+
+	err = t.save_changes_merchant(stub, m)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_outlet_price - Reprices a catalog item at one outlet, or with "default" returns it to the catalog price.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_outlet_price(stub shim.ChaincodeStubInterface, posID string, sku string, price_arg string) ([]byte, error) {
+
+	p, err := t.retrieve_pos(stub, posID)
+	if err != nil || p.PoSID == "" { return nil, errors.New("Unknown posId " + posID) }
+	if p.MerchantID == "" { return nil, errors.New("PoS " + posID + " does not belong to a merchant") }
+
+	m, err := t.retrieve_merchant(stub, p.MerchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, posID, "manage")
+	if err != nil { return nil, err }
+
+	for _, c := range m.Catalog {
+		if c.SKU != sku { continue }
+
+		i, err := t.retrieve_item(stub, c.Items[posID])
+		if err != nil { return nil, err }
+
+		if price_arg == "default" {
+			i.Price = c.Price
+			i.Override = false
+		} else {
+			price, err := strconv.Atoi(price_arg)
+			if err != nil || price <= 0 { return nil, errors.New("Price must be greater than 0") }
+			i.Price = price
+			i.Override = true
+		}
+
+		_, err = t.save_changes_item(stub, i)
+		if err != nil { fmt.Printf("SET_OUTLET_PRICE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		return nil, nil
+	}
+	return nil, errors.New("Merchant " + m.MerchantID + " has no catalog item " + sku)
+}
+
+//=================================================================================================================================
+//	 set_merchant_staff - Adds a staff member to the merchant or changes their permissions and outlets.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_merchant_staff(stub shim.ChaincodeStubInterface, merchantID string, staff_json string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, "", "manage")
+	if err != nil { return nil, err }
+
+	var s Merchant_Staff
+	err = json.Unmarshal([]byte(staff_json), &s)
+	if err != nil { return nil, errors.New("Invalid staff JSON") }
+	if s.Username == "" { return nil, errors.New("Staff must have a username") }
+
+	for _, posID := range s.PoSIDs {
+		if len(m.PoSIDs) == 0 || !in_list(m.PoSIDs, posID) { return nil, errors.New("PoS " + posID + " is not an outlet of merchant " + merchantID) }
+	}
+
+	for n, existing := range m.Staff {
+		if existing.Username == s.Username { m.Staff = append(m.Staff[:n], m.Staff[n+1:]...); break }
+	}
+	m.Staff = append(m.Staff, s)
+
+	err = t.save_changes_merchant(stub, m)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 remove_merchant_staff - Takes a staff member off the merchant.
+//=================================================================================================================================
+func (t *SimpleChaincode) remove_merchant_staff(stub shim.ChaincodeStubInterface, merchantID string, username string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	err = t.check_merchant_caller(stub, m, "", "manage")
+	if err != nil { return nil, err }
+
+	for n, existing := range m.Staff {
+		if existing.Username != username { continue }
+		m.Staff = append(m.Staff[:n], m.Staff[n+1:]...)
+
+		err = t.save_changes_merchant(stub, m)
+		if err != nil { return nil, err }
+		return nil, nil
+	}
+	return nil, errors.New(username + " is not on the staff of merchant " + merchantID)
+}
+
+//==============================================================================================================================
+//	 record_merchant_sale - Adds a purchase at one of a merchant's outlets to the merchant's sales for the month.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_merchant_sale(stub shim.ChaincodeStubInterface, v Purchase) error {
+
+	p, err := t.retrieve_pos(stub, v.PoSID)
+	if err != nil || p.MerchantID == "" { return nil }
+
+	period := time.Unix(v.Timestamp, 0).UTC().Format("2006-01")
+	key := p.MerchantID + "_" + period
+
+	sales := Merchant_Sales{MerchantID: p.MerchantID, Period: period, ByPoS: map[string]Sales_Total{}}
+
+	bytes, err := stub.GetState(MERCHANT_SALES_PREFIX + key)
+	if err != nil { return errors.New("Unable to get sales for merchant " + p.MerchantID) }
+	first := bytes == nil
+	if !first {
+		err = json.Unmarshal(bytes, &sales)
+		if err != nil { return errors.New("Corrupt sales for merchant " + p.MerchantID) }
+	}
+
+	total := sales.ByPoS[v.PoSID]
+	total.add(Sales_Total{Purchases: 1, Sales: v.Price - v.Discount, PointsEarned: v.PointsEarned, PointsRedeemed: v.PointsRedeemed})
+	sales.ByPoS[v.PoSID] = total
+
+	bytes, err = json.Marshal(sales)
+	if err != nil { return errors.New("Error converting sales for merchant " + p.MerchantID) }
+
+	err = stub.PutState(MERCHANT_SALES_PREFIX + key, bytes)
+	if err != nil { return errors.New("Error storing sales for merchant " + p.MerchantID) }
+
+	if first { return t.append_ids(stub, "merchantSalesIDs", "sales", []string{key}) }
+	return nil
+}
+
+func (s *Sales_Total) add(o Sales_Total) {
+
+	s.Purchases = s.Purchases + o.Purchases
+	s.Sales = s.Sales + o.Sales
+	s.PointsEarned = s.PointsEarned + o.PointsEarned
+	s.PointsRedeemed = s.PointsRedeemed + o.PointsRedeemed
+}
+
+//=================================================================================================================================
+//	 get_merchant - Returns the merchant with its outlets, catalog and staff.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_merchant(stub shim.ChaincodeStubInterface, merchantID string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	_, _, err = t.staff_scope(stub, m, "")
+	if err != nil { return nil, errors.New("Permission Denied. Only the staff of merchant " + merchantID + " may see it") }
+
+	return json.Marshal(m)
+}
+
+//=================================================================================================================================
+//	 get_merchant_report - Rolls up the merchant's sales and points by outlet for a month given as YYYY-MM, or for all
+//						   months if period is empty. Staff scoped to some outlets only see those.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_merchant_report(stub shim.ChaincodeStubInterface, merchantID string, period string) ([]byte, error) {
+
+	m, err := t.retrieve_merchant(stub, merchantID)
+	if err != nil { return nil, err }
+
+	all, scope, err := t.staff_scope(stub, m, "reports")
+	if err != nil { return nil, err }
+
+	keys, err := t.retrieve_ids(stub, "merchantSalesIDs", "sales")
+	if err != nil { return nil, err }
+	sort.Strings(keys)
+
+	report := Merchant_Report{MerchantID: merchantID, Periods: []string{}, ByPoS: map[string]Sales_Total{}}
+
+	for _, key := range keys {
+		bytes, err := stub.GetState(MERCHANT_SALES_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Unable to get sales " + key) }
+
+		var sales Merchant_Sales
+		err = json.Unmarshal(bytes, &sales)
+		if err != nil { return nil, errors.New("Corrupt sales " + key) }
+
+		if sales.MerchantID != merchantID || (period != "" && sales.Period != period) { continue }
+
+		report.Periods = append(report.Periods, sales.Period)
+		for posID, total := range sales.ByPoS {
+			if !all && !in_list(scope, posID) { continue }
+
+			outlet := report.ByPoS[posID]
+			outlet.add(total)
+			report.ByPoS[posID] = outlet
+			report.Total.add(total)
+		}
+	}
+	return json.Marshal(report)
+}
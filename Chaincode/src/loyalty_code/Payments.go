@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/util"
+)
+
+const   DEFAULT_PAYMENTS_FUNCTION	=  "pay"
+
+//==============================================================================================================================
+//	 pay - Moves amount from the customer to the partner that owns p through the payments chaincode named in the program
+//		   config, for a money purchase or the cash part of a purchase paid partly with points. The payments
+//		   chaincode is invoked as
+//
+//				<PaymentsFunction> [payerID, payeeID, amount, reference]
+//
+//		   where payeeID is the PoS's partner, or the PoS itself if it has none, and reference is the loyalty
+//		   transaction's TxID. It must fail if the money cannot be moved and may return its own payment reference,
+//		   which is returned here. Nothing is paid when no payments chaincode is configured, as the payment is then
+//		   taken outside the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) pay(stub shim.ChaincodeStubInterface, config Program_Config, v Customer, p PoS, amount int) (string, error) {
+
+	if config.PaymentsChaincode == "" || amount <= 0 { return "", nil }
+
+	function := config.PaymentsFunction
+	if function == "" { function = DEFAULT_PAYMENTS_FUNCTION }
+
+	payee := p.PartnerID
+	if payee == "" { payee = p.PoSID }
+
+	response, err := stub.InvokeChaincode(config.PaymentsChaincode, util.ToChaincodeArgs(function, v.CustomerID, payee, strconv.Itoa(amount), stub.GetTxID()))
+	if err != nil { fmt.Printf("PAY: Payments chaincode refused payment: %s", err); return "", errors.New(" Payment failed: " + err.Error()) }
+
+	return string(response), nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   POOL_PREFIX		=  "pool_"
+
+//==============================================================================================================================
+//	Pool - A household point pool. Members whose Contributes flag is set earn into the pool instead of their own
+//		   wallet, and members with CanSpend may pay with the pool's points, at most SpendLimit points per purchase
+//		   (0 for no limit). The owner manages the members and cannot leave the pool. A customer the owner adds is
+//		   Pending, and takes no part in the pool, until they accept. Every change to the pool is journaled under
+//		   journal_pool_<poolID>.
+//==============================================================================================================================
+type Pool struct {
+	PoolID			string			`json:"poolId"`
+	Name			string			`json:"name"`
+	OwnerID			string			`json:"ownerId"`
+	Members			[]Pool_Member	`json:"members"`
+	Balance			int				`json:"balance"`
+}
+
+type Pool_Member struct {
+	CustomerID		string		`json:"customerId"`
+	Contributes		bool		`json:"contributes"`
+	CanSpend		bool		`json:"canSpend"`
+	SpendLimit		int			`json:"spendLimit"`
+	Pending			bool		`json:"pending,omitempty"`
+}
+
+//==============================================================================================================================
+//	PoolID Holder - Defines the structure that holds all the poolIDs for Pools that have been created.
+//==============================================================================================================================
+type PoolID_Holder struct {
+	PoolIDs			[]string	`json:"poolIDs"`
+}
+
+//==============================================================================================================================
+//	 retrieve_pool - Gets the pool stored for poolID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_pool(stub shim.ChaincodeStubInterface, poolID string) (Pool, error) {
+
+	var v Pool
+
+	bytes, err := stub.GetState(POOL_PREFIX + poolID)
+	if err != nil { return v, errors.New("RETRIEVE_POOL: Error retrieving Pool with poolID = " + poolID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_POOL: No Pool with poolID = " + poolID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_POOL: Corrupt Pool record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_pool - Writes the pool to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_pool(stub shim.ChaincodeStubInterface, v Pool) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting pool record: %s", err); return errors.New("Error converting pool record") }
+
+	err = stub.PutState(POOL_PREFIX + v.PoolID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing pool record: %s", err); return errors.New("Error storing pool record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 member - Returns the position of customerID in the member list, or -1.
+//==============================================================================================================================
+func (p Pool) member(customerID string) int {
+
+	for m, member := range p.Members {
+		if member.CustomerID == customerID { return m }
+	}
+	return -1
+}
+
+//=================================================================================================================================
+//	 create_pool - Creates a pool owned by ownerID, who becomes its first member with full permissions.
+//=================================================================================================================================
+func (t *SimpleChaincode) create_pool(stub shim.ChaincodeStubInterface, poolID string, ownerID string, name string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, ownerID)
+	if err != nil { return nil, err }
+
+	if poolID == "" { return nil, errors.New("Pool must have a poolId") }
+	_, err = t.retrieve_pool(stub, poolID)
+	if err == nil { return nil, errors.New("Pool already exists") }
+
+	owner, err := t.retrieve_customer(stub, ownerID)
+	if err != nil { return nil, err }
+	if !owner.Status { return nil, errors.New(" Customer Not Active.") }
+	if owner.PoolID != "" { return nil, errors.New("Customer " + ownerID + " is already in pool " + owner.PoolID) }
+
+	if name == "" { name = poolID }
+
+	v := Pool{PoolID: poolID, Name: name, OwnerID: ownerID, Members: []Pool_Member{{CustomerID: ownerID, Contributes: true, CanSpend: true}}}
+
+	err = t.save_changes_pool(stub, v)
+	if err != nil { return nil, err }
+
+	owner.PoolID = poolID
+	_, err = t.save_changes(stub, owner)
+	if err != nil { fmt.Printf("CREATE_POOL: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	err = t.append_ids(stub, "poolIDs", "poolIDs", []string{poolID})
+	if err != nil { return nil, err }
+
+	err = t.append_journal(stub, POOL_PREFIX + poolID, Journal_Entry{Action: "create", CustomerID: ownerID, Note: name})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 set_pool_member - Invites a customer to the pool or changes a member's permissions. Only the owner may do this.
+//					   An invited customer joins once they call accept_pool_invite.
+//=================================================================================================================================
+func (t *SimpleChaincode) set_pool_member(stub shim.ChaincodeStubInterface, poolID string, member_json string) ([]byte, error) {
+
+	p, err := t.retrieve_pool(stub, poolID)
+	if err != nil { return nil, err }
+
+	err = t.check_customer_caller(stub, p.OwnerID)
+	if err != nil { return nil, err }
+
+	var m Pool_Member
+	err = json.Unmarshal([]byte(member_json), &m)
+	if err != nil { return nil, errors.New("Invalid pool member JSON") }
+	if m.SpendLimit < 0 { return nil, errors.New("spendLimit cannot be negative") }
+
+	action := "update_member"
+	position := p.member(m.CustomerID)
+
+	if position < 0 {
+		v, err := t.retrieve_customer(stub, m.CustomerID)
+		if err != nil { return nil, err }
+		if !v.Status { return nil, errors.New(" Customer Not Active.") }
+		if v.PoolID != "" { return nil, errors.New("Customer " + m.CustomerID + " is already in pool " + v.PoolID) }
+
+		m.Pending = true
+		p.Members = append(p.Members, m)
+		action = "invite_member"
+	} else {
+		if m.CustomerID == p.OwnerID && (!m.CanSpend || m.SpendLimit != 0) { return nil, errors.New("The owner's spending cannot be limited") }
+		m.Pending = p.Members[position].Pending
+		p.Members[position] = m
+	}
+
+	err = t.save_changes_pool(stub, p)
+	if err != nil { return nil, err }
+
+	err = t.append_journal(stub, POOL_PREFIX + poolID, Journal_Entry{Action: action, CustomerID: m.CustomerID, Note: member_json})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 accept_pool_invite - The invited customer joins the pool.
+//=================================================================================================================================
+func (t *SimpleChaincode) accept_pool_invite(stub shim.ChaincodeStubInterface, poolID string, customerID string) ([]byte, error) {
+
+	err := t.check_customer_caller(stub, customerID)
+	if err != nil { return nil, err }
+
+	p, err := t.retrieve_pool(stub, poolID)
+	if err != nil { return nil, err }
+
+	position := p.member(customerID)
+	if position < 0 || !p.Members[position].Pending { return nil, errors.New("Customer " + customerID + " has no invitation to pool " + poolID) }
+
+	v, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+	if !v.Status { return nil, errors.New(" Customer Not Active.") }
+	if v.PoolID != "" { return nil, errors.New("Customer " + customerID + " is already in pool " + v.PoolID) }
+
+	v.PoolID = poolID
+	_, err = t.save_changes(stub, v)
+	if err != nil { fmt.Printf("ACCEPT_POOL_INVITE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+
+	p.Members[position].Pending = false
+
+	err = t.save_changes_pool(stub, p)
+	if err != nil { return nil, err }
+
+	err = t.append_journal(stub, POOL_PREFIX + poolID, Journal_Entry{Action: "add_member", CustomerID: customerID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 remove_pool_member - Takes a member out of the pool. The owner may remove anyone else and members may leave or
+//						  decline an invitation. The points stay in the pool.
+//=================================================================================================================================
+func (t *SimpleChaincode) remove_pool_member(stub shim.ChaincodeStubInterface, poolID string, customerID string) ([]byte, error) {
+
+	p, err := t.retrieve_pool(stub, poolID)
+	if err != nil { return nil, err }
+
+	err = t.check_customer_caller(stub, customerID)
+	if err != nil { err = t.check_customer_caller(stub, p.OwnerID) }
+	if err != nil { return nil, err }
+
+	if customerID == p.OwnerID { return nil, errors.New("The owner cannot leave the pool") }
+
+	position := p.member(customerID)
+	if position < 0 { return nil, errors.New("Customer " + customerID + " is not in pool " + poolID) }
+	p.Members = append(p.Members[:position], p.Members[position+1:]...)
+
+	v, err := t.retrieve_customer(stub, customerID)
+	if err != nil { return nil, err }
+
+	if v.PoolID == poolID {													// An invited customer never joined
+		v.PoolID = ""
+		_, err = t.save_changes(stub, v)
+		if err != nil { fmt.Printf("REMOVE_POOL_MEMBER: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	}
+
+	err = t.save_changes_pool(stub, p)
+	if err != nil { return nil, err }
+
+	err = t.append_journal(stub, POOL_PREFIX + poolID, Journal_Entry{Action: "remove_member", CustomerID: customerID})
+	if err != nil { return nil, err }
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 pool_contribution - Pays points earned by v into v's pool if v contributes to one. Returns the points the pool
+//						 took, which are not added to v's wallet.
+//==============================================================================================================================
+func (t *SimpleChaincode) pool_contribution(stub shim.ChaincodeStubInterface, v Customer, points int) (int, error) {
+
+	if v.PoolID == "" || points <= 0 { return 0, nil }
+
+	p, err := t.retrieve_pool(stub, v.PoolID)
+	if err != nil { return 0, err }
+
+	position := p.member(v.CustomerID)
+	if position < 0 || !p.Members[position].Contributes { return 0, nil }
+
+	p.Balance = p.Balance + points
+
+	err = t.save_changes_pool(stub, p)
+	if err != nil { return 0, err }
+
+	err = t.append_journal(stub, POOL_PREFIX + p.PoolID, Journal_Entry{Action: "contribute", CustomerID: v.CustomerID, Points: points})
+	if err != nil { return 0, err }
+
+	return points, nil
+}
+
+//==============================================================================================================================
+//	 pool_withdrawal - Takes points from v's pool to pay for a purchase, within v's spending permissions.
+//==============================================================================================================================
+func (t *SimpleChaincode) pool_withdrawal(stub shim.ChaincodeStubInterface, v Customer, points int, itemID string) error {
+
+	if v.PoolID == "" { return errors.New(" Customer " + v.CustomerID + " is not in a pool.") }
+
+	p, err := t.retrieve_pool(stub, v.PoolID)
+	if err != nil { return err }
+
+	position := p.member(v.CustomerID)
+	if position < 0 { return errors.New(" Customer " + v.CustomerID + " is not in pool " + p.PoolID + ".") }
+
+	m := p.Members[position]
+	if !m.CanSpend { return errors.New(" Customer " + v.CustomerID + " may not spend the points of pool " + p.PoolID + ".") }
+	if m.SpendLimit > 0 && points > m.SpendLimit { return errors.New(fmt.Sprintf(" Customer %s may spend at most %d pool points per purchase.", v.CustomerID, m.SpendLimit)) }
+	if p.Balance < points { return errors.New(" Not enough points in pool " + p.PoolID + ".") }
+
+	p.Balance = p.Balance - points
+
+	err = t.save_changes_pool(stub, p)
+	if err != nil { return err }
+
+	return t.append_journal(stub, POOL_PREFIX + p.PoolID, Journal_Entry{Action: "withdraw", CustomerID: v.CustomerID, Points: points, Note: itemID})
+}
+
+//=================================================================================================================================
+//	 get_pool - Returns the pool together with its journal.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_pool(stub shim.ChaincodeStubInterface, poolID string) ([]byte, error) {
+
+	p, err := t.retrieve_pool(stub, poolID)
+	if err != nil { return nil, err }
+
+	for _, m := range p.Members {												// Any member, or the regulator, may see the pool
+		err = t.check_customer_view(stub, m.CustomerID)
+		if err == nil { break }
+	}
+	if err != nil { return nil, errors.New("Permission Denied. Only members of pool " + poolID + " may see it") }
+
+	journal, err := t.retrieve_journal(stub, POOL_PREFIX + poolID)
+	if err != nil { return nil, err }
+
+	return json.Marshal(struct {
+		Pool		Pool				`json:"pool"`
+		Journal		[]Journal_Entry		`json:"journal"`
+	}{p, journal})
+}
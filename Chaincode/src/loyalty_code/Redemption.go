@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const   BURN_PREFIX				=  "burn_"
+const   DEFAULT_POINTS_PER_100	=  100
+
+//==============================================================================================================================
+//	Burn Counter - The points a customer has redeemed in the current day and month, stored under burn_<customerID>.
+//				   Day is the number of days since the epoch and Month is YYYY-MM, both in UTC. A counter for an
+//				   earlier day or month counts as zero.
+//==============================================================================================================================
+type Burn_Counter struct {
+	Day				int64	`json:"day"`
+	DayPoints		int		`json:"dayPoints"`
+	Month			string	`json:"month"`
+	MonthPoints		int		`json:"monthPoints"`
+}
+
+//==============================================================================================================================
+//	Redemption - How a wallet purchase is paid: Value is the part of the price paid with Points, Cash the rest.
+//==============================================================================================================================
+type Redemption struct {
+	Points			int
+	Value			int
+	Cash			int
+}
+
+//==============================================================================================================================
+//	 points_per_100 - The points needed for 100 of value at the PoS.
+//==============================================================================================================================
+func (c Program_Config) points_per_100(posID string) int {
+
+	rate, ok := c.PoSPointRates[posID]
+	if !ok || rate <= 0 { return DEFAULT_POINTS_PER_100 }
+	return rate
+}
+
+//==============================================================================================================================
+//	 plan_redemption - Splits a price of cost at PoS p between points and cash. requested is the points the customer
+//					   wants to spend, or -1 to pay as much as the program allows with points. The points are rounded
+//					   up so a customer never pays for less than the value they receive.
+//==============================================================================================================================
+func plan_redemption(config Program_Config, p PoS, cost int, requested int) (Redemption, error) {
+
+	rate := config.points_per_100(p.PoSID)
+	max_value := cost * config.MaxRedemptionShare / 100
+
+	var r Redemption
+	if requested < 0 {
+		r.Value = max_value
+	} else {
+		r.Value = requested * 100 / rate
+		if r.Value > cost { r.Value = cost }
+		if r.Value > max_value { return r, errors.New(fmt.Sprintf(" At most %d%% of the price, %d points, can be paid with points at this PoS.", config.MaxRedemptionShare, (max_value * rate + 99) / 100)) }
+	}
+
+	r.Points = (r.Value * rate + 99) / 100
+	r.Cash = cost - r.Value
+
+	if r.Points > 0 && r.Points < config.MinRedemption {
+		return r, errors.New(" Redemption of " + strconv.Itoa(r.Points) + " points is below the minimum of " + strconv.Itoa(config.MinRedemption) + ".")
+	}
+	return r, nil
+}
+
+//==============================================================================================================================
+//	 retrieve_burn_counter - Returns the customer's counter, reset for the day and month of now.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_burn_counter(stub shim.ChaincodeStubInterface, customerID string, now int64) (Burn_Counter, error) {
+
+	var v Burn_Counter
+
+	bytes, err := stub.GetState(BURN_PREFIX + customerID)
+	if err != nil { return v, errors.New("Unable to get burn counter for " + customerID) }
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return v, errors.New("Corrupt burn counter for " + customerID) }
+	}
+
+	day := now / 86400
+	month := time.Unix(now, 0).UTC().Format("2006-01")
+
+	if v.Day != day { v.Day = day; v.DayPoints = 0 }
+	if v.Month != month { v.Month = month; v.MonthPoints = 0 }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 record_burn - Checks the points fit in the customer's daily and monthly caps and adds them to the counter.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_burn(stub shim.ChaincodeStubInterface, config Program_Config, customerID string, points int) error {
+
+	if points == 0 { return nil }
+
+	v, err := t.retrieve_burn_counter(stub, customerID, t.get_tx_time(stub))
+	if err != nil { return err }
+
+	if config.DailyBurnCap > 0 && v.DayPoints + points > config.DailyBurnCap {
+		return errors.New(fmt.Sprintf(" Daily redemption limit of %d points exceeded, %d points left today.", config.DailyBurnCap, config.DailyBurnCap - v.DayPoints))
+	}
+	if config.MonthlyBurnCap > 0 && v.MonthPoints + points > config.MonthlyBurnCap {
+		return errors.New(fmt.Sprintf(" Monthly redemption limit of %d points exceeded, %d points left this month.", config.MonthlyBurnCap, config.MonthlyBurnCap - v.MonthPoints))
+	}
+
+	v.DayPoints = v.DayPoints + points
+	v.MonthPoints = v.MonthPoints + points
+
+	bytes, err := json.Marshal(v)
+	if err != nil { return errors.New("Error converting burn counter") }
+
+	err = stub.PutState(BURN_PREFIX + customerID, bytes)
+	if err != nil { return errors.New("Error storing burn counter") }
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Referral status
+//==============================================================================================================================
+const   REFERRAL_PENDING		=  "pending"
+const   REFERRAL_REWARDED		=  "rewarded"
+
+const   REFERRAL_PREFIX			=  "referral_"
+const   MAX_REFERRAL_DEPTH		=  32
+
+//==============================================================================================================================
+//	Referral - Records that RefereeID joined on ReferrerID's recommendation, stored under referral_<refereeID>. The
+//			   referral stays pending until the referee's first qualifying money purchase, which pays both bonuses.
+//==============================================================================================================================
+type Referral struct {
+	ReferrerID			string	`json:"referrerId"`
+	RefereeID			string	`json:"refereeId"`
+	Status				string	`json:"status"`
+	CreatedAt			int64	`json:"createdAt"`
+	RewardedAt			int64	`json:"rewardedAt,omitempty"`
+	PurchaseTxID		string	`json:"purchaseTxId,omitempty"`
+	ReferrerBonus		int		`json:"referrerBonus,omitempty"`
+	RefereeBonus		int		`json:"refereeBonus,omitempty"`
+}
+
+//==============================================================================================================================
+//	Referral Holder - Defines the structure that holds the customerIDs of every referred customer.
+//==============================================================================================================================
+type Referral_Holder struct {
+	Referees		[]string	`json:"referees"`
+}
+
+//==============================================================================================================================
+//	 retrieve_referral - Gets the referral for the referred customer.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_referral(stub shim.ChaincodeStubInterface, refereeID string) (Referral, error) {
+
+	var v Referral
+
+	bytes, err := stub.GetState(REFERRAL_PREFIX + refereeID)
+	if err != nil { return v, errors.New("RETRIEVE_REFERRAL: Error retrieving Referral for customerID = " + refereeID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_REFERRAL: No Referral for customerID = " + refereeID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_REFERRAL: Corrupt Referral record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_referral - Writes the referral to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_referral(stub shim.ChaincodeStubInterface, v Referral) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting referral record: %s", err); return errors.New("Error converting referral record") }
+
+	err = stub.PutState(REFERRAL_PREFIX + v.RefereeID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing referral record: %s", err); return errors.New("Error storing referral record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 check_referrer - Checks that referrerID may refer customerID: the referrer must be an active customer, and
+//					  customerID may not be the referrer or anyone the referrer was referred by, however far back.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_referrer(stub shim.ChaincodeStubInterface, config Program_Config, customerID string, referrerID string) error {
+
+	if !config.feature_enabled(FEATURE_REFERRALS) { return errors.New("Referrals are disabled") }
+	if referrerID == customerID { return errors.New("A customer cannot refer themselves") }
+
+	record, err := stub.GetState(referrerID)
+	if err != nil { return errors.New("Unable to check referrer " + referrerID) }
+	if record == nil { return errors.New("Unknown referrer " + referrerID) }
+
+	ancestorID := referrerID
+	for depth := 0; ancestorID != ""; depth++ {
+		if depth >= MAX_REFERRAL_DEPTH { return errors.New(fmt.Sprintf("Referral chain cannot be longer than %d", MAX_REFERRAL_DEPTH)) }
+
+		ancestor, err := t.retrieve_customer(stub, ancestorID)
+		if err != nil { return err }
+		if depth == 0 && !ancestor.Status { return errors.New("Referrer " + referrerID + " is not active") }
+		if ancestor.ReferredBy == customerID { return errors.New("Referral loop, " + customerID + " already referred " + referrerID) }
+		ancestorID = ancestor.ReferredBy
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 create_referral - Records a pending referral for a customer that has just been created.
+//==============================================================================================================================
+func (t *SimpleChaincode) create_referral(stub shim.ChaincodeStubInterface, customerID string, referrerID string) error {
+
+	v := Referral{ReferrerID: referrerID, RefereeID: customerID, Status: REFERRAL_PENDING, CreatedAt: t.get_tx_time(stub)}
+
+	err := t.save_changes_referral(stub, v)
+	if err != nil { return err }
+
+	return t.append_ids(stub, "referralIDs", "referees", []string{customerID})
+}
+
+//==============================================================================================================================
+//	 reward_referral - Pays the referral bonuses if v was referred, the referral is still pending and price qualifies.
+//					   The referee's bonus is added to v, which the caller saves; the referrer is saved here. Returns
+//					   the referee's bonus.
+//==============================================================================================================================
+func (t *SimpleChaincode) reward_referral(stub shim.ChaincodeStubInterface, v *Customer, price int) (int, error) {
+
+	if v.ReferredBy == "" { return 0, nil }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return 0, err }
+	if !config.feature_enabled(FEATURE_REFERRALS) || price < config.ReferralMinPurchase { return 0, nil }
+
+	r, err := t.retrieve_referral(stub, v.CustomerID)
+	if err != nil { return 0, err }
+	if r.Status != REFERRAL_PENDING { return 0, nil }
+
+	referrer, err := t.retrieve_customer(stub, r.ReferrerID)
+	if err != nil { return 0, err }
+
+	frozen := t.check_not_frozen(stub, ENTITY_CUSTOMER, referrer.CustomerID) != nil
+
+	if referrer.Status && !frozen {												// An inactive or frozen referrer forfeits the bonus
+		referrer.Cashback = referrer.Cashback + config.ReferrerBonus
+		r.ReferrerBonus = config.ReferrerBonus
+
+		_, err = t.save_changes(stub, referrer)
+		if err != nil { fmt.Printf("REWARD_REFERRAL: Error saving changes: %s", err); return 0, errors.New("Error saving changes") }
+	}
+
+	v.Cashback = v.Cashback + config.RefereeBonus
+	r.RefereeBonus = config.RefereeBonus
+	r.Status = REFERRAL_REWARDED
+	r.RewardedAt = t.get_tx_time(stub)
+	r.PurchaseTxID = stub.GetTxID()
+
+	err = t.save_changes_referral(stub, r)
+	if err != nil { return 0, err }
+
+	err = t.record_liability(stub, config.issuer_liability_partner(), Liability_Movement{Issued: r.RefereeBonus + r.ReferrerBonus})
+	if err != nil { return 0, err }
+
+	return r.RefereeBonus, nil
+}
+
+//=================================================================================================================================
+//	 get_referrals - Returns the referrals made by a customer and their status.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_referrals(stub shim.ChaincodeStubInterface, referrerID string) ([]byte, error) {
+
+	ids, err := t.retrieve_ids(stub, "referralIDs", "referees")
+	if err != nil { return nil, err }
+
+	referrals := []Referral{}
+	for _, id := range ids {
+		r, err := t.retrieve_referral(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Referral") }
+		if r.ReferrerID == referrerID { referrals = append(referrals, r) }
+	}
+	return json.Marshal(referrals)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Settlement entry types
+//==============================================================================================================================
+const   SETTLE_EARN				=  "earn"
+const   SETTLE_BURN				=  "burn"
+const   SETTLE_FLOAT			=  "float"
+const   SETTLE_FEE				=  "fee"
+
+const   SETTLEMENT_PREFIX		=  "settlement_"
+const   STATEMENT_PREFIX		=  "statement_"
+
+const   STATEMENT_UNSETTLED		=  "unsettled"
+const   STATEMENT_SETTLED		=  "settled"
+
+//==============================================================================================================================
+//	Settlement Entry - One amount owed between two partners. An earn at another partner's PoS means that partner owes
+//					   the issuer for the points it awarded, a float purchase for the points it bought and a fee
+//					   for the fee charged; a burn means the issuer owes the partner for the value it delivered.
+//==============================================================================================================================
+type Settlement_Entry struct {
+	TxID			string	`json:"txId"`
+	Timestamp		int64	`json:"timestamp"`
+	Type			string	`json:"type"`
+	PayerID			string	`json:"payerId"`
+	PayeeID			string	`json:"payeeId"`
+	PoSID			string	`json:"posId,omitempty"`
+	Points			int		`json:"points"`
+	Amount			int		`json:"amount"`
+}
+
+//==============================================================================================================================
+//	Settlement Account - The entries posted between two partners since the last statement, stored under
+//						 settlement_<PartnerA>_<PartnerB> with PartnerA sorting before PartnerB.
+//==============================================================================================================================
+type Settlement_Account struct {
+	PartnerA		string				`json:"partnerA"`
+	PartnerB		string				`json:"partnerB"`
+	OpenedAt		int64				`json:"openedAt"`
+	Entries			[]Settlement_Entry	`json:"entries"`
+}
+
+//==============================================================================================================================
+//	Statement - A closed period of a settlement account. AOwesB and BOwesA are the totals each way and Net is what
+//				PayerID owes PayeeID once they are offset.
+//==============================================================================================================================
+type Statement struct {
+	StatementID		string				`json:"statementId"`
+	PartnerA		string				`json:"partnerA"`
+	PartnerB		string				`json:"partnerB"`
+	PeriodStart		int64				`json:"periodStart"`
+	PeriodEnd		int64				`json:"periodEnd"`
+	AOwesB			int					`json:"aOwesB"`
+	BOwesA			int					`json:"bOwesA"`
+	PayerID			string				`json:"payerId"`
+	PayeeID			string				`json:"payeeId"`
+	Net				int					`json:"net"`
+	Entries			[]Settlement_Entry	`json:"entries"`
+	Status			string				`json:"status"`
+	SettledAt		int64				`json:"settledAt,omitempty"`
+	SettledBy		string				`json:"settledBy,omitempty"`
+}
+
+//==============================================================================================================================
+//	Settlement Holder - Defines the structure that holds the keys of every settlement account and statement.
+//==============================================================================================================================
+type Settlement_Holder struct {
+	Accounts		[]string	`json:"accounts"`
+}
+
+type StatementID_Holder struct {
+	StatementIDs	[]string	`json:"statementIDs"`
+}
+
+//==============================================================================================================================
+//	 settlement_pair - Orders two partnerIDs the way their account is keyed.
+//==============================================================================================================================
+func settlement_pair(x string, y string) (string, string) {
+
+	if x < y { return x, y }
+	return y, x
+}
+
+//==============================================================================================================================
+//	 retrieve_settlement_account - Gets the account between two partners, or an empty one if none has been opened.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_settlement_account(stub shim.ChaincodeStubInterface, x string, y string) (Settlement_Account, bool, error) {
+
+	a, b := settlement_pair(x, y)
+	v := Settlement_Account{PartnerA: a, PartnerB: b, Entries: []Settlement_Entry{}}
+
+	bytes, err := stub.GetState(SETTLEMENT_PREFIX + a + "_" + b)
+	if err != nil { return v, false, errors.New("Unable to get settlement account " + a + "_" + b) }
+	if bytes == nil { return v, false, nil }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, false, errors.New("Corrupt settlement account " + a + "_" + b) }
+	return v, true, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_settlement_account - Writes the account to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_settlement_account(stub shim.ChaincodeStubInterface, v Settlement_Account) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting settlement account: %s", err); return errors.New("Error converting settlement account") }
+
+	err = stub.PutState(SETTLEMENT_PREFIX + v.PartnerA + "_" + v.PartnerB, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing settlement account: %s", err); return errors.New("Error storing settlement account") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_statement - Gets the statement stored for statementID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_statement(stub shim.ChaincodeStubInterface, statementID string) (Statement, error) {
+
+	var v Statement
+
+	bytes, err := stub.GetState(STATEMENT_PREFIX + statementID)
+	if err != nil { return v, errors.New("RETRIEVE_STATEMENT: Error retrieving Statement with statementID = " + statementID) }
+	if bytes == nil { return v, errors.New("RETRIEVE_STATEMENT: No Statement with statementID = " + statementID) }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_STATEMENT: Corrupt Statement record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_statement - Writes the statement to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_statement(stub shim.ChaincodeStubInterface, v Statement) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting statement record: %s", err); return errors.New("Error converting statement record") }
+
+	err = stub.PutState(STATEMENT_PREFIX + v.StatementID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing statement record: %s", err); return errors.New("Error storing statement record") }
+	return nil
+}
+
+//==============================================================================================================================
+//	 post_settlement - Adds an entry to the account between payer and payee, opening the account if needed.
+//==============================================================================================================================
+func (t *SimpleChaincode) post_settlement(stub shim.ChaincodeStubInterface, entry Settlement_Entry) error {
+
+	v, exists, err := t.retrieve_settlement_account(stub, entry.PayerID, entry.PayeeID)
+	if err != nil { return err }
+
+	entry.TxID = stub.GetTxID()
+	entry.Timestamp = t.get_tx_time(stub)
+
+	if len(v.Entries) == 0 { v.OpenedAt = entry.Timestamp }
+	v.Entries = append(v.Entries, entry)
+
+	err = t.save_changes_settlement_account(stub, v)
+	if err != nil { return err }
+
+	if exists { return nil }
+	return t.append_ids(stub, "settlementAccounts", "accounts", []string{v.PartnerA + "_" + v.PartnerB})
+}
+
+//==============================================================================================================================
+//	 settle_earn - Posts the points a purchase at p earned, if p belongs to a partner other than the issuer. The partner
+//				   owes the issuer PointCost for every 100 points, unless they came from its float, which it has
+//				   already paid for.
+//==============================================================================================================================
+func (t *SimpleChaincode) settle_earn(stub shim.ChaincodeStubInterface, p PoS, points int) error {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return err }
+	if config.IssuerPartnerID == "" || p.PartnerID == "" || p.PartnerID == config.IssuerPartnerID || points <= 0 { return nil }
+	if config.uses_float(p) { return nil }
+
+	return t.post_settlement(stub, Settlement_Entry{Type: SETTLE_EARN, PayerID: p.PartnerID, PayeeID: config.IssuerPartnerID, PoSID: p.PoSID, Points: points, Amount: points * config.PointCost / 100})
+}
+
+//==============================================================================================================================
+//	 settle_burn - Posts points redeemed at p for value, if p belongs to a partner other than the issuer. The issuer
+//				   owes the partner the value it delivered.
+//==============================================================================================================================
+func (t *SimpleChaincode) settle_burn(stub shim.ChaincodeStubInterface, p PoS, points int, value int) error {
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return err }
+	if config.IssuerPartnerID == "" || p.PartnerID == "" || p.PartnerID == config.IssuerPartnerID || points <= 0 { return nil }
+
+	return t.post_settlement(stub, Settlement_Entry{Type: SETTLE_BURN, PayerID: config.IssuerPartnerID, PayeeID: p.PartnerID, PoSID: p.PoSID, Points: points, Amount: value})
+}
+
+//=================================================================================================================================
+//	 close_settlement_period - Turns the open entries of every settlement account into a statement for that pair of
+//							   partners and empties the accounts. Returns the new statements. The airline is a party
+//							   to most accounts, so only the regulator closes a period.
+//=================================================================================================================================
+func (t *SimpleChaincode) close_settlement_period(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	err := t.check_authority(stub)
+	if err != nil { return nil, err }
+
+	keys, err := t.retrieve_ids(stub, "settlementAccounts", "accounts")
+	if err != nil { return nil, err }
+	sort.Strings(keys)
+
+	ids, err := t.retrieve_ids(stub, "statementIDs", "statementIDs")
+	if err != nil { return nil, err }
+
+	now := t.get_tx_time(stub)
+	statements := []Statement{}
+	var new_ids []string
+
+	for _, key := range keys {
+		bytes, err := stub.GetState(SETTLEMENT_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Unable to get settlement account " + key) }
+
+		var v Settlement_Account
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt settlement account " + key) }
+		if len(v.Entries) == 0 { continue }
+
+		s := Statement{StatementID: fmt.Sprintf("ST%08d", len(ids) + len(new_ids) + 1), PartnerA: v.PartnerA, PartnerB: v.PartnerB, PeriodStart: v.OpenedAt, PeriodEnd: now, Entries: v.Entries, Status: STATEMENT_UNSETTLED}
+		for _, entry := range v.Entries {
+			if entry.PayerID == v.PartnerA { s.AOwesB = s.AOwesB + entry.Amount } else { s.BOwesA = s.BOwesA + entry.Amount }
+		}
+
+		if s.AOwesB >= s.BOwesA {
+			s.PayerID, s.PayeeID, s.Net = v.PartnerA, v.PartnerB, s.AOwesB - s.BOwesA
+		} else {
+			s.PayerID, s.PayeeID, s.Net = v.PartnerB, v.PartnerA, s.BOwesA - s.AOwesB
+		}
+
+		err = t.save_changes_statement(stub, s)
+		if err != nil { return nil, err }
+
+		v.Entries = []Settlement_Entry{}
+		err = t.save_changes_settlement_account(stub, v)
+		if err != nil { return nil, err }
+
+		new_ids = append(new_ids, s.StatementID)
+		statements = append(statements, s)
+	}
+
+	if len(new_ids) > 0 {
+		err = t.append_ids(stub, "statementIDs", "statementIDs", new_ids)
+		if err != nil { return nil, err }
+	}
+
+	return json.Marshal(statements)
+}
+
+//=================================================================================================================================
+//	 settle_statement - Marks a statement as paid. Only the regulator or the payee, who has received the money, may do
+//						this.
+//=================================================================================================================================
+func (t *SimpleChaincode) settle_statement(stub shim.ChaincodeStubInterface, statementID string) ([]byte, error) {
+
+	v, err := t.retrieve_statement(stub, statementID)
+	if err != nil { return nil, err }
+
+	err = t.check_partner_self(stub, v.PayeeID)
+	if err != nil { return nil, err }
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+	if v.Status == STATEMENT_SETTLED { return nil, errors.New("Statement " + statementID + " has already been settled") }
+
+	v.Status = STATEMENT_SETTLED
+	v.SettledAt = t.get_tx_time(stub)
+	v.SettledBy = caller
+
+	err = t.save_changes_statement(stub, v)
+	if err != nil { return nil, err }
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 get_statements - Returns a partner's statements and the entries still open in its settlement accounts. Only the
+//					  regulator and the partner itself may see them.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_statements(stub shim.ChaincodeStubInterface, partnerID string) ([]byte, error) {
+
+	err := t.check_partner_self(stub, partnerID)
+	if err != nil { return nil, err }
+
+	ids, err := t.retrieve_ids(stub, "statementIDs", "statementIDs")
+	if err != nil { return nil, err }
+
+	statements := []Statement{}
+	for _, id := range ids {
+		s, err := t.retrieve_statement(stub, id)
+		if err != nil { return nil, errors.New("Failed to retrieve Statement") }
+		if s.PartnerA == partnerID || s.PartnerB == partnerID { statements = append(statements, s) }
+	}
+
+	keys, err := t.retrieve_ids(stub, "settlementAccounts", "accounts")
+	if err != nil { return nil, err }
+
+	open := []Settlement_Account{}
+	for _, key := range keys {
+		bytes, err := stub.GetState(SETTLEMENT_PREFIX + key)
+		if err != nil || bytes == nil { return nil, errors.New("Unable to get settlement account " + key) }
+
+		var v Settlement_Account
+		err = json.Unmarshal(bytes, &v)
+		if err != nil { return nil, errors.New("Corrupt settlement account " + key) }
+		if (v.PartnerA == partnerID || v.PartnerB == partnerID) && len(v.Entries) > 0 { open = append(open, v) }
+	}
+
+	return json.Marshal(struct {
+		Statements		[]Statement				`json:"statements"`
+		Open			[]Settlement_Account	`json:"open"`
+	}{statements, open})
+}
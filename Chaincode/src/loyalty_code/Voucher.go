@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Voucher types
+//==============================================================================================================================
+const   VOUCHER_POINTS_BONUS		=  "points_bonus"
+const   VOUCHER_PERCENT_DISCOUNT	=  "percent_discount"
+const   VOUCHER_FREE_ITEM			=  "free_item"
+
+const   VOUCHER_PREFIX				=  "voucher_"
+
+//==============================================================================================================================
+//	 VOUCHER_CODE_MIN_LENGTH - Codes are hashed without a salt, so they must be long enough that hashing every possible
+//							   code is out of reach.
+//==============================================================================================================================
+const   VOUCHER_CODE_MIN_LENGTH		=  16
+
+//==============================================================================================================================
+//	Voucher - Issued by a partner and redeemed as part of a purchase. The code itself is never stored; VoucherID is
+//			  its hash, so reading the ledger doesn't give away a usable code.
+//
+//			  PartnerID is the partner that issued the voucher, and it is only accepted at that partner's PoS. Value
+//			  is the bonus points for points_bonus and the percentage off for percent_discount. ItemID is the item a
+//			  free_item voucher pays for. Expiry of 0 never expires. CustomerID, if set, binds the voucher to that
+//			  customer.
+//==============================================================================================================================
+type Voucher struct {
+	VoucherID		string	`json:"voucherId"`
+	PartnerID		string	`json:"partnerId"`
+	Type			string	`json:"type"`
+	Value			int		`json:"value"`
+	ItemID			string	`json:"itemId,omitempty"`
+	MaxUses			int		`json:"maxUses"`
+	Uses			int		`json:"uses"`
+	Expiry			int64	`json:"expiry"`
+	CustomerID		string	`json:"customerId,omitempty"`
+	IssuedBy		string	`json:"issuedBy"`
+	Status			bool	`json:"status"`
+}
+
+//==============================================================================================================================
+//	Voucher Request - The JSON passed to issue_voucher. Code is hashed and dropped.
+//==============================================================================================================================
+type voucher_request struct {
+	Code			string	`json:"code"`
+	PartnerID		string	`json:"partnerId"`
+	Type			string	`json:"type"`
+	Value			int		`json:"value"`
+	ItemID			string	`json:"itemId"`
+	MaxUses			int		`json:"maxUses"`
+	Expiry			int64	`json:"expiry"`
+	CustomerID		string	`json:"customerId"`
+}
+
+//==============================================================================================================================
+//	VoucherID Holder - Defines the structure that holds all the voucherIDs for Vouchers that have been issued.
+//==============================================================================================================================
+type VoucherID_Holder struct {
+	VoucherIDs		[]string	`json:"voucherIDs"`
+}
+
+//==============================================================================================================================
+//	 hash_voucher_code - Returns the voucherID for a code.
+//==============================================================================================================================
+func hash_voucher_code(code string) string {
+
+	sum := sha256.Sum256([]byte("voucher|" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+//==============================================================================================================================
+//	 retrieve_voucher - Gets the voucher stored for voucherID.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_voucher(stub shim.ChaincodeStubInterface, voucherID string) (Voucher, error) {
+
+	var v Voucher
+
+	bytes, err := stub.GetState(VOUCHER_PREFIX + voucherID)
+	if err != nil { return v, errors.New("RETRIEVE_VOUCHER: Error retrieving Voucher") }
+	if bytes == nil { return v, errors.New("Unknown voucher code") }
+
+	err = json.Unmarshal(bytes, &v)
+	if err != nil { return v, errors.New("RETRIEVE_VOUCHER: Corrupt Voucher record " + string(bytes)) }
+	return v, nil
+}
+
+//==============================================================================================================================
+//	 save_changes_voucher - Writes the voucher to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_changes_voucher(stub shim.ChaincodeStubInterface, v Voucher) error {
+
+	bytes, err := json.Marshal(v)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting voucher record: %s", err); return errors.New("Error converting voucher record") }
+
+	err = stub.PutState(VOUCHER_PREFIX + v.VoucherID, bytes)
+	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing voucher record: %s", err); return errors.New("Error storing voucher record") }
+	return nil
+}
+
+//=================================================================================================================================
+//	 issue_voucher - Creates a voucher from its JSON definition and journals the issue. A partner issues vouchers
+//					 for its own PoS. Returns the voucherID.
+//=================================================================================================================================
+func (t *SimpleChaincode) issue_voucher(stub shim.ChaincodeStubInterface, voucher_json string) ([]byte, error) {
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil { return nil, errors.New("Error retrieving caller information") }
+
+	req := voucher_request{MaxUses: 1}
+	err = json.Unmarshal([]byte(voucher_json), &req)
+	if err != nil { return nil, errors.New("Invalid voucher JSON") }
+
+	err = t.check_acts_for(stub, req.PartnerID)
+	if err != nil { return nil, err }
+
+	_, err = t.retrieve_partner(stub, req.PartnerID)
+	if err != nil { return nil, errors.New("Unknown partnerId " + req.PartnerID) }
+
+	config, err := t.retrieve_program_config(stub)
+	if err != nil { return nil, err }
+
+	if len(req.Code) < VOUCHER_CODE_MIN_LENGTH { return nil, errors.New(fmt.Sprintf("Voucher code must be at least %d characters", VOUCHER_CODE_MIN_LENGTH)) }
+	if req.MaxUses <= 0 { return nil, errors.New("maxUses must be at least 1") }
+
+	if req.Type == VOUCHER_POINTS_BONUS {
+		if req.Value <= 0 { return nil, errors.New("Points bonus must be greater than 0") }
+		if req.Value > config.MaxVoucherBonus { return nil, errors.New(fmt.Sprintf("Points bonus cannot be above %d", config.MaxVoucherBonus)) }
+	} else if req.Type == VOUCHER_PERCENT_DISCOUNT {
+		if req.Value <= 0 || req.Value > 100 { return nil, errors.New("Discount must be between 1 and 100 percent") }
+	} else if req.Type == VOUCHER_FREE_ITEM {
+		i, err := t.retrieve_item(stub, req.ItemID)
+		if err != nil { return nil, errors.New("Unknown itemId " + req.ItemID) }
+		p, err := t.retrieve_pos(stub, i.PoSID)
+		if err != nil || p.PartnerID != req.PartnerID { return nil, errors.New("Item " + req.ItemID + " does not belong to partner " + req.PartnerID) }
+	} else {
+		return nil, errors.New("Unknown voucher type " + req.Type)
+	}
+
+	if req.CustomerID != "" {
+		_, err = t.retrieve_customer(stub, req.CustomerID)
+		if err != nil { return nil, errors.New("Unknown customerId " + req.CustomerID) }
+	}
+
+	v := Voucher{VoucherID: hash_voucher_code(req.Code), PartnerID: req.PartnerID, Type: req.Type, Value: req.Value, ItemID: req.ItemID, MaxUses: req.MaxUses, Expiry: req.Expiry, CustomerID: req.CustomerID, IssuedBy: caller, Status: true}
+
+	_, err = t.retrieve_voucher(stub, v.VoucherID)
+	if err == nil { return nil, errors.New("Voucher code already in use") }
+
+	err = t.save_changes_voucher(stub, v)
+	if err != nil { return nil, err }
+
+	err = t.append_ids(stub, "voucherIDs", "voucherIDs", []string{v.VoucherID})
+	if err != nil { return nil, err }
+
+	err = t.append_journal(stub, VOUCHER_PREFIX + v.VoucherID, Journal_Entry{Action: "issue", CustomerID: v.CustomerID, Points: v.Value, Note: v.Type})
+	if err != nil { return nil, err }
+
+	return []byte(v.VoucherID), nil
+}
+
+//==============================================================================================================================
+//	 redeem_voucher - Checks the voucher can be used by customer v on item i at PoS p, uses it once and journals the
+//					  redemption. Returns the voucher so the purchase can apply it.
+//==============================================================================================================================
+func (t *SimpleChaincode) redeem_voucher(stub shim.ChaincodeStubInterface, code string, v Customer, i Item, p PoS) (Voucher, error) {
+
+	voucher, err := t.retrieve_voucher(stub, hash_voucher_code(code))
+	if err != nil { return voucher, err }
+
+	now := t.get_tx_time(stub)
+
+	if !voucher.Status { return voucher, errors.New("Voucher has been withdrawn") }
+	if voucher.PartnerID != p.PartnerID { return voucher, errors.New("Voucher is not accepted at PoS " + p.PoSID) }
+	if voucher.Uses >= voucher.MaxUses { return voucher, errors.New("Voucher has already been used") }
+	if voucher.Expiry != 0 && now > voucher.Expiry { return voucher, errors.New("Voucher has expired") }
+	if voucher.CustomerID != "" && voucher.CustomerID != v.CustomerID { return voucher, errors.New("Voucher belongs to another customer") }
+	if voucher.Type == VOUCHER_FREE_ITEM && voucher.ItemID != i.ItemID { return voucher, errors.New("Voucher is for item " + voucher.ItemID) }
+
+	voucher.Uses = voucher.Uses + 1
+
+	err = t.save_changes_voucher(stub, voucher)
+	if err != nil { return voucher, err }
+
+	err = t.append_journal(stub, VOUCHER_PREFIX + voucher.VoucherID, Journal_Entry{Action: "redeem", CustomerID: v.CustomerID, Points: voucher.bonus(), Note: i.ItemID})
+	if err != nil { return voucher, err }
+
+	return voucher, nil
+}
+
+//==============================================================================================================================
+//	 price - The price of the item after the voucher.
+//==============================================================================================================================
+func (v Voucher) price(price int) int {
+
+	if v.Type == VOUCHER_FREE_ITEM { return 0 }
+	if v.Type == VOUCHER_PERCENT_DISCOUNT { return price - price * v.Value / 100 }
+	return price
+}
+
+//==============================================================================================================================
+//	 bonus - The points the voucher adds to the purchase.
+//==============================================================================================================================
+func (v Voucher) bonus() int {
+
+	if v.Type == VOUCHER_POINTS_BONUS { return v.Value }
+	return 0
+}
+
+//=================================================================================================================================
+//	 get_voucher - Returns the voucher for a code together with its journal.
+//=================================================================================================================================
+func (t *SimpleChaincode) get_voucher(stub shim.ChaincodeStubInterface, code string) ([]byte, error) {
+
+	err := t.check_partner_caller(stub)
+	if err != nil { return nil, err }
+
+	voucher, err := t.retrieve_voucher(stub, hash_voucher_code(code))
+	if err != nil { return nil, err }
+
+	err = t.check_acts_for(stub, voucher.PartnerID)
+	if err != nil { return nil, err }
+
+	journal, err := t.retrieve_journal(stub, VOUCHER_PREFIX + voucher.VoucherID)
+	if err != nil { return nil, err }
+
+	return json.Marshal(struct {
+		Voucher		Voucher				`json:"voucher"`
+		Journal		[]Journal_Entry		`json:"journal"`
+	}{voucher, journal})
+}
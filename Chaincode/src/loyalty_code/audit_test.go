@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//	event_stub - Keeps the last event sent, which MockStub drops.
+type event_stub struct {
+	*roleStub
+	name		string
+	payload		[]byte
+}
+
+func (e *event_stub) SetEvent(name string, payload []byte) error {
+
+	e.name, e.payload = name, payload
+	return nil
+}
+
+func TestFailedInvokesReturnTheirErrorAndSendTheirAuditEntry(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+	must_invoke(t, cc, s, "issue_voucher", `{"code":"AIRLINE-HALF-0001","partnerId":"PA0000001","type":"percent_discount","value":50}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	e := &event_stub{roleStub: s}
+	s.MockTransactionStart("failed")
+	_, err := cc.Invoke(e, "buy_item_by_pool", []string{"AB0000001", "", "IT0000001", "AIRLINE-HALF-0001"})
+	s.MockTransactionEnd("failed")
+	if err == nil { t.Fatal("a failed invoke returned no error") }
+
+	var entry Audit_Entry
+	json.Unmarshal(e.payload, &entry)
+	if e.name != INVOKE_FAILED_EVENT || entry.TxID != "failed" || entry.Function != "buy_item_by_pool" || entry.Caller != "till1" || entry.Entity != "AB0000001" || entry.Outcome != AUDIT_FAILURE || entry.Error != err.Error() { t.Fatalf("%s event = %s", e.name, e.payload) }
+
+	v, _ := cc.retrieve_voucher(s, hash_voucher_code("AIRLINE-HALF-0001"))
+	if v.Uses != 0 { t.Fatalf("the failed purchase used the voucher %d times", v.Uses) }
+
+	s.as("reg1", AUTHORITY)
+	var entries []Audit_Entry
+	json.Unmarshal(must_query(t, cc, s, "get_audit_log", ""), &entries)
+	if len(entries) != 2 || entries[1].Function != "issue_voucher" { t.Fatalf("audit entries = %+v, want the two successful invokes", entries) }
+}
+
+func TestAuditEntriesAreKeyedByTransaction(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	bytes, _ := s.GetState(AUDIT_PREFIX + "tx1")
+	var e Audit_Entry
+	json.Unmarshal(bytes, &e)
+	if e.TxID != "tx1" || e.Function != "bulk_create_customers" || e.Outcome != AUDIT_SUCCESS { t.Fatalf("audit_tx1 = %s", bytes) }
+}
+
+func TestRangeQueriesSeeTheInvokesOwnWrites(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	s.MockTransactionStart("range")
+	s.PutState(AUDIT_PREFIX + "a", []byte("old"))
+	s.PutState(AUDIT_PREFIX + "c", []byte("old"))
+	s.MockTransactionEnd("range")
+
+	s.MockTransactionStart("range")
+	b := new_buffered_stub(s)
+	b.PutState(AUDIT_PREFIX + "b", []byte("new"))
+	b.DelState(AUDIT_PREFIX + "c")
+	b.PutState(AUDIT_PREFIX + "d", []byte("new"))
+	ids, err := cc.retrieve_range_ids(b, AUDIT_PREFIX)
+	s.MockTransactionEnd("range")
+
+	if err != nil { t.Fatal(err) }
+	var got []string
+	for _, id := range ids {
+		if len(id) == 1 { got = append(got, id) }
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "d" { t.Fatalf("range ids = %v, want [a b d]", got) }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBulkCustomersWithoutOpeningBalances(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	s.as("inn1", HOTEL)
+
+	bytes := must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002","cashback":500}]`)
+	if !strings.Contains(string(bytes), `"created":1`) || !strings.Contains(string(bytes), "Opening balances need the regulator") {
+		t.Fatalf("expected the row with cashback to be refused, got %s", bytes)
+	}
+	if _, err := cc.retrieve_customer(s, "AB0000002"); err == nil { t.Fatal("customer with an unfunded opening balance was created") }
+}
+
+func TestBulkCustomersOpeningBalancesNeedRegulator(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	s.as("inn1", HOTEL)
+	must_fail(t, cc, s, "Only the regulator", "bulk_create_customers", "json", `[{"customerID":"AB0000001","cashback":500}]`, "PA0000002")
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "does not have enough points in its float", "bulk_create_customers", "json", `[{"customerID":"AB0000001","cashback":500}]`, "PA0000002")
+
+	must_invoke(t, cc, s, "purchase_float", "PA0000002", "600", "wire-1")
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001","cashback":500}]`, "PA0000002")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 500 { t.Fatalf("cashback = %d, want 500", got) }
+	f, _, _ := cc.retrieve_float(s, "PA0000002")
+	if f.Balance != 100 { t.Fatalf("float balance = %d, want 100", f.Balance) }
+}
+
+func TestBulkPoSAndItemsOnlyForTheCallersPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	s.as("inn1", HOTEL, "partnerId", "PA0000002")
+	bytes := must_invoke(t, cc, s, "bulk_create_pos", "json", `[{"posId":"PS0000003","partnerId":"PA0000002"},{"posId":"PS0000004","partnerId":"PA0000001"}]`)
+	if !strings.Contains(string(bytes), `"created":1`) || !strings.Contains(string(bytes), "does not act for partner PA0000001") {
+		t.Fatalf("expected the row for another partner to be refused, got %s", bytes)
+	}
+	if _, err := cc.retrieve_pos(s, "PS0000004"); err == nil { t.Fatal("PoS was created for another partner") }
+
+	bytes = must_invoke(t, cc, s, "bulk_create_items", "json", `[{"itemId":"IT0000003","itemName":"Suite","price":900,"posId":"PS0000003"},{"itemId":"IT0000004","itemName":"Seat","price":900,"posId":"PS0000001"}]`)
+	if !strings.Contains(string(bytes), `"created":1`) || !strings.Contains(string(bytes), "does not act for partner PA0000001") {
+		t.Fatalf("expected the item at another partner's PoS to be refused, got %s", bytes)
+	}
+
+	s.as("air1", AIRLINES)
+	must_invoke(t, cc, s, "bulk_create_pos", "json", `[{"posId":"PS0000004","partnerId":"PA0000002"}]`)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCampaignsBelongToTheirPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "Program rules", "create_campaign", `{"campaignId":"C1","start":-10,"end":10,"multiplierPercent":200}`)
+	must_fail(t, cc, s, "does not act for partner PA0000001", "create_campaign", `{"campaignId":"C1","partnerId":"PA0000001","start":-10,"end":10,"multiplierPercent":200}`)
+	must_fail(t, cc, s, "does not belong to partner", "create_campaign", `{"campaignId":"C1","partnerId":"PA0000002","posIds":["PS0000001"],"start":-10,"end":10,"multiplierPercent":200}`)
+	must_fail(t, cc, s, "does not belong to partner", "create_campaign", `{"campaignId":"C1","partnerId":"PA0000002","itemIds":["IT0000001"],"start":-10,"end":10,"multiplierPercent":200}`)
+	must_invoke(t, cc, s, "create_campaign", `{"campaignId":"C1","partnerId":"PA0000002","start":-10,"end":10,"multiplierPercent":200}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("cashback = %d, want 100 as the hotel's campaign does not apply at the airline", got) }
+
+	s.as("other", HOTEL)
+	must_fail(t, cc, s, "does not act for partner PA0000002", "end_campaign", "C1")
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_invoke(t, cc, s, "end_campaign", "C1")
+}
+
+func TestCampaignMultiplierIsCapped(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	must_fail(t, cc, s, "multiplierPercent cannot be above 300", "create_campaign", `{"campaignId":"C1","start":-10,"end":10,"multiplierPercent":1000}`)
+	must_fail(t, cc, s, "flatBonus cannot be above 1000", "create_campaign", `{"campaignId":"C1","start":-10,"end":10,"flatBonus":5000}`)
+	must_invoke(t, cc, s, "create_campaign", `{"campaignId":"C1","start":-10,"end":10,"multiplierPercent":300}`)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEarnRulesStackInStages(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "Program rules", "create_earn_rule", `{"ruleId":"R1","action":"percentage","value":20}`)
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "between 0 and 100", "create_earn_rule", `{"ruleId":"R1","action":"percentage","value":120}`)
+	must_invoke(t, cc, s, "create_earn_rule", `{"ruleId":"R4","priority":4,"action":"cap","value":300}`)
+	must_invoke(t, cc, s, "create_earn_rule", `{"ruleId":"R3","priority":3,"action":"bonus","value":10}`)
+	must_invoke(t, cc, s, "create_earn_rule", `{"ruleId":"R2","priority":2,"action":"multiplier","value":200}`)
+	must_invoke(t, cc, s, "create_earn_rule", `{"ruleId":"R1","priority":1,"action":"percentage","value":20}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	if got := cashback(t, cc, s, "AB0000001"); got != 300 { t.Fatalf("cashback = %d, want 20%% of 1000 doubled plus 10, capped at 300", got) }
+
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "create_earn_rule", `{"ruleId":"R0","exclusive":true,"itemIds":["IT0000001"],"action":"bonus","value":5}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	if got := cashback(t, cc, s, "AB0000001"); got != 405 { t.Fatalf("cashback = %d, want 300 plus the PoS rate and the exclusive bonus, 105", got) }
+}
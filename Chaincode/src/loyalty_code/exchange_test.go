@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//	exchange_ledger - The hotel's own program, with 1000 of its points on AB0000001 and a rate into cashback
+//					  proposed by the hotel but not yet approved by the airline side.
+func exchange_ledger(t *testing.T) (*SimpleChaincode, *roleStub) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"}]`)
+	must_invoke(t, cc, s, "create_loyalty_program", "inn", "Inn Points", "PA0000002", "innmgr")
+
+	s.as("innmgr", HOTEL)
+	must_invoke(t, cc, s, "award_program_points", "inn", "AB0000001", "1000")
+	must_invoke(t, cc, s, "propose_exchange_rate", `{"fromProgram":"inn","toProgram":"cashback","fromPoints":10,"toPoints":1,"feePercent":10,"minPoints":100,"dailyLimit":500}`)
+	return cc, s
+}
+
+func TestExchangeNeedsBothOwners(t *testing.T) {
+
+	cc, s := exchange_ledger(t)
+
+	s.as("AB0000001", CUSTOMER)
+	must_fail(t, cc, s, "has not been agreed", "exchange_points", "AB0000001", "inn", "cashback", "200")
+
+	s.as("mgr2", HOTEL)
+	must_fail(t, cc, s, "Permission Denied", "approve_exchange_rate", "inn", "cashback")
+	must_fail(t, cc, s, "Permission Denied", "award_program_points", "inn", "AB0000001", "1000")
+
+	s.as("air1", AIRLINES)
+	must_invoke(t, cc, s, "approve_exchange_rate", "inn", "cashback")
+
+	s.as("AB0000002", CUSTOMER)
+	must_fail(t, cc, s, "Permission Denied", "exchange_points", "AB0000001", "inn", "cashback", "200")
+
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "exchange_points", "AB0000001", "inn", "cashback", "200")
+
+	v, _ := cc.retrieve_customer(s, "AB0000001")
+	if v.Cashback != 18 || v.Balances["inn"] != 800 { t.Fatalf("after exchanging 200 at 10:1 less 10%%: cashback %d, inn %d, want 18 and 800", v.Cashback, v.Balances["inn"]) }
+}
+
+func TestExchangeMinimumAndDailyLimit(t *testing.T) {
+
+	cc, s := exchange_ledger(t)
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "approve_exchange_rate", "inn", "cashback")
+
+	s.as("AB0000001", CUSTOMER)
+	must_fail(t, cc, s, "At least 100 points", "exchange_points", "AB0000001", "inn", "cashback", "50")
+	must_invoke(t, cc, s, "exchange_points", "AB0000001", "inn", "cashback", "200")
+	must_fail(t, cc, s, "Daily exchange limit of 500 points reached, 300 left today", "exchange_points", "AB0000001", "inn", "cashback", "400")
+	must_invoke(t, cc, s, "exchange_points", "AB0000001", "inn", "cashback", "300")
+	must_fail(t, cc, s, "0 left today", "exchange_points", "AB0000001", "inn", "cashback", "100")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 45 { t.Fatalf("cashback = %d, want 45", got) }
+}
+
+func TestProposingNewTermsWithdrawsApproval(t *testing.T) {
+
+	cc, s := exchange_ledger(t)
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "approve_exchange_rate", "inn", "cashback")
+
+	s.as("innmgr", HOTEL)
+	must_invoke(t, cc, s, "propose_exchange_rate", `{"fromProgram":"inn","toProgram":"cashback","fromPoints":1,"toPoints":1}`)
+
+	s.as("AB0000001", CUSTOMER)
+	must_fail(t, cc, s, "has not been agreed", "exchange_points", "AB0000001", "inn", "cashback", "200")
+}
+
+func TestExchangeIsBookedToBothProgramsLiability(t *testing.T) {
+
+	cc, s := exchange_ledger(t)
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "approve_exchange_rate", "inn", "cashback")
+
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "exchange_points", "AB0000001", "inn", "cashback", "200")
+
+	s.as("reg1", AUTHORITY)
+	var report Liability_Report
+	json.Unmarshal(must_query(t, cc, s, "get_liability_report"), &report)
+
+	inn := Liability_Total{Liability_Movement{Issued: 1000, Redeemed: 180, Fees: 20}, 800}
+	if got := report.ByProgram["inn"]; got != inn { t.Fatalf("inn = %+v, want %+v", got, inn) }
+	if got := report.ByPartner["PA0000002"]; got != (Liability_Movement{Issued: 18}) { t.Fatalf("hotel cashback = %+v, want 18 issued", got) }
+	if report.Total == nil || report.Total.Outstanding != 18 { t.Fatalf("cashback total = %+v, want 18 outstanding", report.Total) }
+}
+
+func TestExchangeNeedsTheCustomerAndValidProgramIDs(t *testing.T) {
+
+	cc, s := exchange_ledger(t)
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "Invalid program ID provided inn_x", "create_loyalty_program", "inn_x", "Inn", "PA0000002", "innmgr")
+	must_invoke(t, cc, s, "approve_exchange_rate", "inn", "cashback")
+
+	for _, role := range []string{AIRLINES, HOTEL, VENDOR, AUTHORITY} {
+		s.as("innmgr", role, "partnerId", "PA0000002")
+		must_fail(t, cc, s, "Only customer AB0000001", "exchange_points", "AB0000001", "inn", "cashback", "200")
+	}
+}
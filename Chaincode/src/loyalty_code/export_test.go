@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+//	export_pages - Exports the whole ledger in pages of size records.
+func export_pages(t *testing.T, cc *SimpleChaincode, s *roleStub, size int) []Export_Page {
+
+	var pages []Export_Page
+	for page := 0; page >= 0; {
+		var p Export_Page
+		err := json.Unmarshal(must_query(t, cc, s, "export_ledger", strconv.Itoa(page), strconv.Itoa(size)), &p)
+		if err != nil { t.Fatalf("export page %d: %s", page, err) }
+		pages = append(pages, p)
+		page = p.NextPage
+	}
+	return pages
+}
+
+func page_json(t *testing.T, p Export_Page) string {
+
+	bytes, err := json.Marshal(p)
+	if err != nil { t.Fatal(err) }
+	return string(bytes)
+}
+
+//	sealed - Recomputes the checksums of a page that a test has tampered with, as an attacker would.
+func sealed(p Export_Page) Export_Page {
+
+	p.Checksum = export_checksum(p.Records)
+	return p
+}
+
+func populated_ledger(t *testing.T) (*SimpleChaincode, *roleStub) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"}]`)
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "freeze_entity", "customer", "AB0000002", "fraud", "CASE-1")
+	return cc, s
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+
+	cc, s := populated_ledger(t)
+	pages := export_pages(t, cc, s, 3)
+	if len(pages) < 3 { t.Fatalf("expected several pages, got %d", len(pages)) }
+
+	to, ts := new_test_stub(t, "")
+	for _, p := range pages {
+		must_invoke(t, to, ts, "import_ledger", page_json(t, p))
+	}
+
+	if got := cashback(t, to, ts, "AB0000001"); got != 100 { t.Fatalf("restored cashback = %d, want 100", got) }
+	f, _ := to.retrieve_freeze(ts, ENTITY_CUSTOMER, "AB0000002")
+	if f == nil || f.CaseRef != "CASE-1" { t.Fatalf("freeze was not restored: %+v", f) }
+
+	ids, _ := to.retrieve_range_ids(ts, AUDIT_PREFIX)
+	if len(ids) != len(pages) { t.Fatalf("%d audit entries after importing %d pages", len(ids), len(pages)) }
+	for _, id := range ids {
+		v, _ := ts.GetState(AUDIT_PREFIX + id)
+		var e Audit_Entry
+		json.Unmarshal(v, &e)
+		if e.Function != "import_ledger" { t.Fatalf("audit entry %s of the source ledger was restored: %+v", id, e) }
+	}
+}
+
+func TestImportRejectsSingleRecordsUnderOtherKeys(t *testing.T) {
+
+	cc, s := populated_ledger(t)
+	pages := export_pages(t, cc, s, 500)
+
+	p := pages[0]
+	forged := append([]Export_Record{}, p.Records...)
+	for n, r := range forged {
+		if r.Type == "program" { forged[n].ID = "float_PA0000002" }
+	}
+	p.Records = forged
+
+	to, ts := new_test_stub(t, "")
+	must_fail(t, to, ts, "must have id programConfig", "import_ledger", page_json(t, sealed(p)))
+	if bytes, _ := ts.GetState("float_PA0000002"); bytes != nil { t.Fatal("forged record was written") }
+}
+
+func TestImportRejectsRecordsThatDoNotDecode(t *testing.T) {
+
+	to, ts := new_test_stub(t, "")
+
+	p := Export_Page{Version: EXPORT_VERSION, NextPage: -1, Records: []Export_Record{{Type: "customer", ID: "float_PA0000002", Data: json.RawMessage(`{"customerID":"float_PA0000002","cashback":5}`)}}}
+	p.TotalRecords = 1
+	p.Snapshot = export_chain("", p.Records)
+	must_fail(t, to, ts, "is reserved", "import_ledger", page_json(t, sealed(p)))
+
+	p.Records = []Export_Record{{Type: "customer", ID: "AB0000009", Data: json.RawMessage(`{"customerID":"AB0000001","cashback":5}`)}}
+	p.Snapshot = export_chain("", p.Records)
+	must_fail(t, to, ts, "does not carry its own id", "import_ledger", page_json(t, sealed(p)))
+
+	p.Records = []Export_Record{{Type: "float", ID: "PA0000002", Data: json.RawMessage(`{"partnerId":"PA0000002","balance":5,"bonus":1}`)}}
+	p.Snapshot = export_chain("", p.Records)
+	must_fail(t, to, ts, "Invalid float", "import_ledger", page_json(t, sealed(p)))
+}
+
+func TestImportDetectsDroppedPage(t *testing.T) {
+
+	cc, s := populated_ledger(t)
+	pages := export_pages(t, cc, s, 3)
+
+	to, ts := new_test_stub(t, "")
+	must_invoke(t, to, ts, "import_ledger", page_json(t, pages[0]))
+	must_fail(t, to, ts, "Expected export page 1", "import_ledger", page_json(t, pages[2]))
+
+	last := pages[len(pages)-1]								// Renumbered to hide the gap
+	last.Page = 1
+	must_fail(t, to, ts, "Snapshot digest mismatch", "import_ledger", page_json(t, last))
+}
+
+func TestAbortImportUndoesPagesAlreadyWritten(t *testing.T) {
+
+	cc, s := populated_ledger(t)
+	pages := export_pages(t, cc, s, 3)
+
+	to, ts := new_test_stub(t, "")
+	before, _ := ts.GetState("programConfig")
+
+	must_invoke(t, to, ts, "import_ledger", page_json(t, pages[0]))
+	must_invoke(t, to, ts, "import_ledger", page_json(t, pages[1]))
+	last := pages[len(pages)-1]
+	last.Page = 2
+	must_fail(t, to, ts, "Snapshot digest mismatch", "import_ledger", page_json(t, last))
+	must_fail(t, to, ts, "An import is under way at page 2", "import_ledger", page_json(t, pages[0]))
+
+	ts.as("air1", AIRLINES)
+	must_fail(t, to, ts, "Only the regulator", "abort_import")
+
+	ts.as("reg1", AUTHORITY)
+	must_invoke(t, to, ts, "abort_import")
+	must_fail(t, to, ts, "No import is under way", "abort_import")
+
+	if after, _ := ts.GetState("programConfig"); string(after) != string(before) { t.Fatalf("program config was not put back: %s", after) }
+	for _, p := range pages[:2] {
+		for _, r := range p.Records {
+			if r.Type != "partner" && r.Type != "customer" && r.Type != "pos" { continue }
+			if bytes, _ := ts.GetState(r.ID); bytes != nil { t.Fatalf("%s %s is still on the ledger", r.Type, r.ID) }
+		}
+	}
+	if ids, _ := to.retrieve_ids(ts, "partnerIDs", "partnerIDs"); len(ids) != 0 { t.Fatalf("partnerIDs still lists %v", ids) }
+
+	for _, p := range pages {
+		must_invoke(t, to, ts, "import_ledger", page_json(t, p))
+	}
+	if got := cashback(t, to, ts, "AB0000001"); got != 100 { t.Fatalf("restored cashback = %d, want 100", got) }
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFeeStatementsAreOnlyShownToTheirPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_query(t, cc, s, "get_fee_statement", "PA0000002", "2026-01")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_query(t, cc, s, "get_fee_statement", "PA0000002", "2026-01")
+	must_deny_query(t, cc, s, "does not act for partner PA0000001", "get_fee_statement", "PA0000001", "2026-01")
+
+	s.as("shop", VENDOR)
+	must_deny_query(t, cc, s, "does not act for partner PA0000002", "get_fee_statement", "PA0000002", "2026-01")
+}
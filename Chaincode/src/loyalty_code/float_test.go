@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFloatsAreOnlyManagedByTheirPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "purchase_float", "PA0000002", "600", "wire-1")
+	must_query(t, cc, s, "get_float_statement", "PA0000002", "1970-01")
+
+	s.as("air", AIRLINES, "partnerId", "PA0000001")
+	must_fail(t, cc, s, "does not act for partner PA0000002", "set_float_threshold", "PA0000002", "500")
+	must_deny_query(t, cc, s, "does not act for partner PA0000002", "get_float_statement", "PA0000002", "1970-01")
+
+	s.as("shop", VENDOR)
+	must_deny_query(t, cc, s, "does not act for partner PA0000002", "get_float_statement", "PA0000002", "1970-01")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_invoke(t, cc, s, "set_float_threshold", "PA0000002", "500")
+	must_query(t, cc, s, "get_float_statement", "PA0000002", "1970-01")
+
+	f, _, _ := cc.retrieve_float(s, "PA0000002")
+	if f.LowThreshold != 500 { t.Fatalf("threshold = %d, want 500", f.LowThreshold) }
+}
+
+func TestFloatJournalIsKeptByMonth(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+	must_invoke(t, cc, s, "purchase_float", "PA0000002", "600", "wire-1")
+
+	s.as("till2", HOTEL, "posId", "PS0000002")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000002")
+
+	journal, _ := cc.retrieve_journal(s, FLOAT_PREFIX + "PA0000002_1970-01")
+	if len(journal) != 2 || journal[0].Action != "purchase" || journal[1].Action != "award" { t.Fatalf("journal = %+v", journal) }
+	if ids, _ := cc.retrieve_ids(s, "floatJournalIDs", "ledgers"); len(ids) != 1 || ids[0] != "PA0000002_1970-01" { t.Fatalf("floatJournalIDs = %v", ids) }
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	var statement struct {
+		Purchases	[]Float_Purchase	`json:"purchases"`
+		Journal		[]Journal_Entry		`json:"journal"`
+	}
+	json.Unmarshal(must_query(t, cc, s, "get_float_statement", "PA0000002", "1970-02"), &statement)
+	if len(statement.Purchases) != 0 || len(statement.Journal) != 0 { t.Fatalf("another month's statement = %+v", statement) }
+	must_deny_query(t, cc, s, "Invalid period", "get_float_statement", "PA0000002", "January")
+}
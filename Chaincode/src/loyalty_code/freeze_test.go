@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFrozenCustomerCannotEarnOrBurn(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	must_fail(t, cc, s, "Only the regulator", "freeze_entity", "customer", "AB0000001", "fraud", "CASE-1")
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "needs a reason and a case reference", "freeze_entity", "customer", "AB0000001", "fraud", "")
+	must_fail(t, cc, s, "Unknown customer AB0000009", "freeze_entity", "customer", "AB0000009", "fraud", "CASE-1")
+	must_invoke(t, cc, s, "freeze_entity", "customer", "AB0000001", "fraud", "CASE-1")
+
+	var freezes []Freeze
+	json.Unmarshal(must_query(t, cc, s, "get_freezes"), &freezes)
+	if len(freezes) != 1 || freezes[0].EntityID != "AB0000001" || freezes[0].FrozenBy != "reg1" { t.Fatalf("freezes = %+v", freezes) }
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_fail(t, cc, s, "customer AB0000001 is frozen under case CASE-1", "buy_item_by_money", "AB0000001", "", "IT0000001")
+	must_fail(t, cc, s, "customer AB0000001 is frozen under case CASE-1", "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "50")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000002", "", "IT0000001")
+	must_fail(t, cc, s, "Only the regulator", "unfreeze_entity", "customer", "AB0000001", "cleared")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("frozen customer's cashback = %d, want 100", got) }
+
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "unfreeze_entity", "customer", "AB0000001", "cleared")
+	must_fail(t, cc, s, "is not frozen", "unfreeze_entity", "customer", "AB0000001", "cleared")
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "50")
+	if got := cashback(t, cc, s, "AB0000001"); got != 50 { t.Fatalf("cashback after unfreezing = %d, want 50", got) }
+
+	s.as("reg1", AUTHORITY)
+	var log []Freeze_Action
+	json.Unmarshal(must_query(t, cc, s, "get_freeze_log", "customer", "AB0000001"), &log)
+	if len(log) != 2 || log[0].Action != "freeze" || log[1].Action != "unfreeze" || log[1].CaseRef != "CASE-1" { t.Fatalf("freeze log = %+v", log) }
+}
+
+func TestFrozenPoSAndPartnerCannotTrade(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	must_invoke(t, cc, s, "freeze_entity", "pos", "PS0000001", "skimming", "CASE-2")
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_fail(t, cc, s, "pos PS0000001 is frozen under case CASE-2", "buy_item_by_money", "AB0000001", "", "IT0000001")
+
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "unfreeze_entity", "pos", "PS0000001", "cleared")
+	must_invoke(t, cc, s, "freeze_entity", "partner", "PA0000001", "insolvency", "CASE-3")
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_fail(t, cc, s, "partner PA0000001 is frozen under case CASE-3", "buy_item_by_money", "AB0000001", "", "IT0000001")
+
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "unfreeze_entity", "partner", "PA0000001", "cleared")
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("cashback = %d, want 100", got) }
+
+	s.as("till1", AIRLINES)
+	must_deny_query(t, cc, s, "Only the regulator", "get_freezes")
+}
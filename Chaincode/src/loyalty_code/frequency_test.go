@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFrequencyProgramsBelongToTheirPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "does not act for partner PA0000001", "create_frequency_program", `{"programId":"F1","scope":"pos","scopeId":"PS0000001","window":100,"levels":[{"visits":1,"discountPercent":10}]}`)
+	must_fail(t, cc, s, "does not act for partner PA0000001", "create_frequency_program", `{"programId":"F1","scope":"partner","scopeId":"PA0000001","window":100,"levels":[{"visits":1,"discountPercent":10}]}`)
+	must_fail(t, cc, s, "discountPercent must be between 0 and 50", "create_frequency_program", `{"programId":"F1","scope":"pos","scopeId":"PS0000002","window":100,"levels":[{"visits":1,"discountPercent":100}]}`)
+	must_fail(t, cc, s, "multiplierPercent cannot be above 300", "create_frequency_program", `{"programId":"F1","scope":"pos","scopeId":"PS0000002","window":100,"levels":[{"visits":1,"multiplierPercent":5000}]}`)
+	must_invoke(t, cc, s, "create_frequency_program", `{"programId":"F1","scope":"pos","scopeId":"PS0000002","window":100,"levels":[{"visits":1,"discountPercent":10}]}`)
+
+	s.as("other", VENDOR)
+	must_fail(t, cc, s, "does not act for partner PA0000002", "end_frequency_program", "F1")
+
+	s.as("air", AIRLINES)
+	must_invoke(t, cc, s, "end_frequency_program", "F1")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//	init_fails - Init with genesis must fail with an error containing each of want, writing no partner.
+func init_fails(t *testing.T, genesis string, want ...string) {
+
+	cc := new(SimpleChaincode)
+	s := &roleStub{shim.NewMockStub("loyalty", cc), map[string]string{"username": "reg1", "role": AUTHORITY}, 0}
+
+	s.MockTransactionStart("init")
+	_, err := cc.Init(s, "init", []string{genesis})
+	s.MockTransactionEnd("init")
+
+	if err == nil { t.Fatalf("Init accepted %s", genesis) }
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) { t.Fatalf("expected an error containing %q, got %q", w, err) }
+	}
+	if _, err := cc.retrieve_partner(s, "PA0000001"); err == nil { t.Fatal("an invalid genesis document wrote its partners") }
+}
+
+func TestGenesisCreatesTheNetwork(t *testing.T) {
+
+	cc, s := new_test_stub(t, `{"program":{"programName":"Sky"},"admins":["ops1"],
+		"partners":[{"partnerId":"PA0000001","type":"airlines"}],
+		"pos":[{"posId":"PS0000001","partnerId":"PA0000001"}],
+		"items":[{"itemId":"IT0000001","itemName":"Seat","price":1000,"posId":"PS0000001"}]}`)
+
+	p, err := cc.retrieve_partner(s, "PA0000001")
+	if err != nil || !p.Status || p.Name != "PA0000001" { t.Fatalf("partner = %+v, %v", p, err) }
+	if _, err = cc.retrieve_pos(s, "PS0000001"); err != nil { t.Fatal(err) }
+
+	s.as("ops1", AIRLINES)
+	must_invoke(t, cc, s, "create_loyalty_program", "miles", "Miles", "PA0000001", "ops1")
+	s.as("ops2", AIRLINES)
+	must_fail(t, cc, s, "Permission Denied", "create_loyalty_program", "miles2", "Miles", "PA0000001", "ops2")
+}
+
+func TestGenesisRejectsUnknownFields(t *testing.T) {
+
+	init_fails(t, `{"partners":[{"partnerId":"PA0000001","type":"airlines"}],"outlets":[]}`, "unknown field")
+	init_fails(t, `{"partners":[{"partnerId":"PA0000001","type":"airlines","tier":"gold"}]}`, "unknown field")
+}
+
+func TestGenesisReportsEveryProblem(t *testing.T) {
+
+	init_fails(t, `{"partners":[{"partnerId":"PA0000001","type":"airlines"},{"partnerId":"PA0000001","type":"hotel"},{"partnerId":"PA0000003","type":"bank"},{"partnerId":"XX1","type":"hotel"}],
+		"pos":[{"posId":"PS0000001","partnerId":"PA0000009"}],
+		"items":[{"itemId":"IT0000001","itemName":"Seat","price":1000,"posId":"PS0000009"}],
+		"admins":[""]}`,
+		"partners[1]: Duplicate partnerId PA0000001",
+		"partners[2]: Unknown partner type bank",
+		"partners[3]: Invalid partner ID provided XX1",
+		"pos[0]: Unknown partnerId PA0000009",
+		"items[0]: Unknown posId PS0000009",
+		"admins[0]: Username cannot be empty")
+}
+
+func TestGenesisRejectsAnInvalidProgramConfig(t *testing.T) {
+
+	init_fails(t, `{"program":{"maxCampaignMultiplier":50},"partners":[{"partnerId":"PA0000001","type":"airlines"}]}`, "program:")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+//	gift_ledger - Three customers, AB0000001 with 100 points to give.
+func gift_ledger(t *testing.T) (*SimpleChaincode, *roleStub) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"},{"customerID":"AB0000003"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	return cc, s
+}
+
+func TestOnlyTheSenderMaySendAGift(t *testing.T) {
+
+	cc, s := gift_ledger(t)
+
+	for _, role := range []string{AIRLINES, HOTEL, VENDOR, AUTHORITY} {
+		s.as("staff1", role, "partnerId", "PA0000002")
+		must_fail(t, cc, s, "Only customer AB0000001", "send_gift", "AB0000001", "", "50", "", "secret-code")
+	}
+	s.as("AB0000003", CUSTOMER)
+	must_fail(t, cc, s, "Only customer AB0000001", "send_gift", "AB0000001", "AB0000003", "50")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("cashback = %d, want 100", got) }
+}
+
+func TestOnlyTheRecipientMayAcceptOrDeclineAGift(t *testing.T) {
+
+	cc, s := gift_ledger(t)
+
+	s.as("AB0000001", CUSTOMER)
+	named := string(must_invoke(t, cc, s, "send_gift", "AB0000001", "AB0000002", "30", "hi"))
+	coded := string(must_invoke(t, cc, s, "send_gift", "AB0000001", "", "20", "", "secret-code"))
+
+	s.as("pos1", VENDOR, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "Only customer AB0000002", "accept_gift", named, "AB0000002")
+	must_fail(t, cc, s, "Only customer AB0000002", "decline_gift", named, "AB0000002")
+	must_fail(t, cc, s, "Only customer AB0000003", "accept_gift", coded, "AB0000003", "secret-code")
+
+	s.as("AB0000003", CUSTOMER)
+	must_fail(t, cc, s, "Gift is for another customer", "accept_gift", named, "AB0000003")
+	must_fail(t, cc, s, "Gift is for another customer", "decline_gift", named, "AB0000003")
+	must_invoke(t, cc, s, "accept_gift", coded, "AB0000003", "secret-code")
+
+	s.as("AB0000002", CUSTOMER)
+	must_invoke(t, cc, s, "accept_gift", named, "AB0000002")
+
+	if got := cashback(t, cc, s, "AB0000002"); got != 30 { t.Fatalf("recipient cashback = %d, want 30", got) }
+	if got := cashback(t, cc, s, "AB0000003"); got != 20 { t.Fatalf("claimant cashback = %d, want 20", got) }
+	if got := cashback(t, cc, s, "AB0000001"); got != 50 { t.Fatalf("sender cashback = %d, want 50", got) }
+}
+
+func TestGiftIDsAreGeneratedFromTheTxID(t *testing.T) {
+
+	cc, s := gift_ledger(t)
+
+	s.as("AB0000001", CUSTOMER)
+	first := string(must_invoke(t, cc, s, "send_gift", "AB0000001", "AB0000002", "10"))
+	second := string(must_invoke(t, cc, s, "send_gift", "AB0000001", "AB0000002", "10"))
+
+	config, _ := cc.retrieve_program_config(s)
+	for _, id := range []string{first, second} {
+		if err := config.check_id(ENTITY_GIFT, id, ""); err != nil { t.Fatal(err) }
+		if _, err := cc.retrieve_gift(s, id); err != nil { t.Fatal(err) }
+	}
+	if first == second || first == "GF00000001" { t.Fatalf("gift IDs %s and %s look counted, not generated", first, second) }
+}
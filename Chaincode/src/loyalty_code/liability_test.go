@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLiabilityIsOwedByTheProgramNotByPartners(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	s.as("desk", HOTEL, "posId", "PS0000002")
+	must_invoke(t, cc, s, "buy_item_by_wallet", "AB0000001", "", "IT0000002", "", "40")
+
+	s.as("reg1", AUTHORITY)
+	var report Liability_Report
+	json.Unmarshal(must_query(t, cc, s, "get_liability_report"), &report)
+
+	if report.Total == nil || report.Total.Outstanding != 60 { t.Fatalf("total = %+v, want 60 outstanding", report.Total) }
+	if got := report.ByPartner["PA0000001"]; got != (Liability_Movement{Issued: 100}) { t.Fatalf("airline = %+v, want 100 issued", got) }
+	if got := report.ByPartner["PA0000002"]; got != (Liability_Movement{Redeemed: 40}) { t.Fatalf("hotel = %+v, want 40 accepted", got) }
+
+	report = Liability_Report{}
+	json.Unmarshal(must_query(t, cc, s, "get_liability_report", "PA0000002"), &report)
+	if report.Total != nil || report.ByPeriod != nil { t.Fatalf("a partner's report has a program total: %+v", report) }
+}
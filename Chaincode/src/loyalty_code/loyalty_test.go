@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Test harness - MockStub cannot read certificate attributes, so roleStub answers ReadCertAttribute and
+//					VerifyAttribute from attrs. Tests switch identity with as().
+//==============================================================================================================================
+type roleStub struct {
+	*shim.MockStub
+	attrs map[string]string
+	txs int
+}
+
+func (s *roleStub) ReadCertAttribute(name string) ([]byte, error) { return []byte(s.attrs[name]), nil }
+func (s *roleStub) VerifyAttribute(name string, v []byte) (bool, error) { return s.attrs[name] != "" && s.attrs[name] == string(v), nil }
+
+//	test_genesis - Two partners, each with a PoS and an item, with the airline as issuer.
+const   test_genesis	=  `{"program":{"programName":"Sky","issuerPartnerId":"PA0000001"},
+	"partners":[{"partnerId":"PA0000001","name":"Air","type":"airlines"},{"partnerId":"PA0000002","name":"Inn","type":"hotel"}],
+	"pos":[{"posId":"PS0000001","partnerId":"PA0000001","percentage":10},{"posId":"PS0000002","partnerId":"PA0000002","percentage":10}],
+	"items":[{"itemId":"IT0000001","itemName":"Seat","price":1000,"posId":"PS0000001"},{"itemId":"IT0000002","itemName":"Room","price":1000,"posId":"PS0000002"}]}`
+
+func new_test_stub(t *testing.T, genesis string) (*SimpleChaincode, *roleStub) {
+
+	cc := new(SimpleChaincode)
+	s := &roleStub{shim.NewMockStub("loyalty", cc), map[string]string{"username": "reg1", "role": AUTHORITY}, 0}
+
+	s.MockTransactionStart("init")
+	defer s.MockTransactionEnd("init")
+
+	var args []string
+	if genesis != "" { args = []string{genesis} }
+	_, err := cc.Init(s, "init", args)
+	if err != nil { t.Fatalf("Init: %s", err) }
+	return cc, s
+}
+
+//	as - Switches the caller. Any other attributes, such as posId or partnerId, are cleared.
+func (s *roleStub) as(username string, role string, attrs ...string) *roleStub {
+
+	s.attrs = map[string]string{"username": username, "role": role}
+	for n := 0; n+1 < len(attrs); n += 2 { s.attrs[attrs[n]] = attrs[n+1] }
+	return s
+}
+
+//	invoke - Runs the invoke as its own transaction.
+func invoke(cc *SimpleChaincode, s *roleStub, function string, args ...string) ([]byte, error) {
+
+	s.txs++
+	tx := "tx" + strconv.Itoa(s.txs)
+	s.MockTransactionStart(tx)
+	defer s.MockTransactionEnd(tx)
+
+	return cc.Invoke(s, function, args)
+}
+
+func must_invoke(t *testing.T, cc *SimpleChaincode, s *roleStub, function string, args ...string) []byte {
+
+	bytes, err := invoke(cc, s, function, args...)
+	if err != nil { t.Fatalf("%s%v: %s", function, args, err) }
+	return bytes
+}
+
+//	must_fail - The invoke must fail with an error containing want.
+func must_fail(t *testing.T, cc *SimpleChaincode, s *roleStub, want string, function string, args ...string) {
+
+	_, err := invoke(cc, s, function, args...)
+	if err == nil { t.Fatalf("%s%v: expected an error containing %q", function, args, want); return }
+	if !strings.Contains(err.Error(), want) { t.Fatalf("%s%v: expected an error containing %q, got %q", function, args, want, err) }
+}
+
+func must_query(t *testing.T, cc *SimpleChaincode, s *roleStub, function string, args ...string) []byte {
+
+	bytes, err := cc.Query(s, function, args)
+	if err != nil { t.Fatalf("%s%v: %s", function, args, err) }
+	return bytes
+}
+
+func cashback(t *testing.T, cc *SimpleChaincode, s *roleStub, customerID string) int {
+
+	v, err := cc.retrieve_customer(s, customerID)
+	if err != nil { t.Fatalf("retrieve_customer %s: %s", customerID, err) }
+	return v.Cashback
+}
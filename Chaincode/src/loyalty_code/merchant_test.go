@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMerchantsCannotAdoptAnotherPartnersPoS(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+
+	s.as("inn", HOTEL)
+	must_fail(t, cc, s, "does not act for partner PA0000001", "create_merchant", "M1", "Shop", "PA0000001")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_invoke(t, cc, s, "create_merchant", "M1", "Shop", "PA0000002")
+	must_fail(t, cc, s, "does not hold PoS PS0000002", "add_merchant_pos", "M1", "PS0000002")
+	must_fail(t, cc, s, "does not hold PoS PS0000001", "add_merchant_pos", "M1", "PS0000001")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002", "posId", "PS0000001")
+	must_fail(t, cc, s, "belongs to another partner", "add_merchant_pos", "M1", "PS0000001")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002", "posId", "PS0000002")
+	must_invoke(t, cc, s, "add_merchant_pos", "M1", "PS0000002")
+}
+
+func TestTheRegulatorOnlySeesMerchantReports(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002", "posId", "PS0000002")
+	must_invoke(t, cc, s, "create_merchant", "M1", "Shop", "PA0000002")
+	must_invoke(t, cc, s, "add_merchant_pos", "M1", "PS0000002")
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "not an operator of PoS PS0000002", "buy_item_by_money", "AB0000001", "", "IT0000002")
+	must_fail(t, cc, s, "may not manage merchant M1", "set_merchant_earn_rate", "M1", "20")
+	must_query(t, cc, s, "get_merchant_report", "M1", "")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 0 { t.Fatalf("cashback = %d, want 0", got) }
+}
+
+func TestMerchantReportsOnlyCountTheirOwnSales(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("air", AIRLINES, "partnerId", "PA0000001", "posId", "PS0000001")
+	must_invoke(t, cc, s, "create_merchant", "ACME", "Acme", "PA0000001")
+	must_invoke(t, cc, s, "create_merchant", "ACME_EU", "Acme Europe", "PA0000001")
+	must_invoke(t, cc, s, "add_merchant_pos", "ACME_EU", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+
+	var report Merchant_Report
+	json.Unmarshal(must_query(t, cc, s, "get_merchant_report", "ACME", ""), &report)
+	if len(report.Periods) != 0 || report.Total.Sales != 0 { t.Fatalf("ACME report = %+v, want no sales", report) }
+
+	json.Unmarshal(must_query(t, cc, s, "get_merchant_report", "ACME_EU", "1970-01"), &report)
+	if report.Total.Purchases != 1 || report.Total.Sales != 1000 { t.Fatalf("ACME_EU report = %+v, want one sale of 1000", report) }
+}
+
+func TestCharityRedemptionsNeedThePoSOperator(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+
+	s.as("helper", CHARITY)
+	must_invoke(t, cc, s, "register_charity", "CH1", "Helpers")
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "approve_charity", "CH1")
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "donate_points", "AB0000001", "CH1", "50")
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_fail(t, cc, s, "Only the manager of charity CH1", "authorise_charity_redemption", "CH1", "IT0000001", "10")
+
+	s.as("helper", CHARITY)
+	auth := string(must_invoke(t, cc, s, "authorise_charity_redemption", "CH1", "IT0000001", "10"))
+	must_fail(t, cc, s, "not an operator of PoS PS0000001", "charity_redeem", auth)
+
+	s.as("till2", HOTEL, "posId", "PS0000002")
+	must_fail(t, cc, s, "not an operator of PoS PS0000001", "charity_redeem", auth)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "charity_redeem", auth)
+	must_fail(t, cc, s, "has already been redeemed", "charity_redeem", auth)
+
+	c, _ := cc.retrieve_charity(s, "CH1")
+	if c.Balance != 40 { t.Fatalf("charity balance = %d, want 40", c.Balance) }
+}
+
+func TestPartnersCannotDonateForACustomer(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+
+	s.as("helper", CHARITY)
+	must_invoke(t, cc, s, "register_charity", "CH1", "Helpers")
+	s.as("reg1", AUTHORITY)
+	must_invoke(t, cc, s, "approve_charity", "CH1")
+
+	for _, role := range []string{AIRLINES, HOTEL, VENDOR, AUTHORITY, CHARITY} {
+		s.as("helper", role, "partnerId", "PA0000002")
+		must_fail(t, cc, s, "Only customer AB0000001", "donate_points", "AB0000001", "CH1", "50")
+	}
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("cashback = %d, want 100", got) }
+}
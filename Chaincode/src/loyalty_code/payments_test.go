@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 payments_stand_in - A payments chaincode for tests. Init takes pairs of account and opening balance; pay moves an
+//						 amount between accounts, refusing to overdraw, and returns a reference for the payment.
+//==============================================================================================================================
+type payments_stand_in struct{}
+
+func (c *payments_stand_in) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	for n := 0; n+1 < len(args); n += 2 {
+		err := stub.PutState(args[n], []byte(args[n+1]))
+		if err != nil { return nil, err }
+	}
+	return nil, nil
+}
+
+func (c *payments_stand_in) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	if function != DEFAULT_PAYMENTS_FUNCTION || len(args) != 4 { return nil, errors.New("expected pay payer, payee, amount and reference") }
+
+	amount, err := strconv.Atoi(args[2])
+	if err != nil || amount <= 0 { return nil, errors.New("invalid amount " + args[2]) }
+
+	payer, payee := balance(stub, args[0]), balance(stub, args[1])
+	if payer < amount { return nil, errors.New("insufficient funds in " + args[0]) }
+
+	stub.PutState(args[0], []byte(strconv.Itoa(payer - amount)))
+	stub.PutState(args[1], []byte(strconv.Itoa(payee + amount)))
+	return []byte("PAY-" + args[3]), nil
+}
+
+func (c *payments_stand_in) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	return stub.GetState(args[0])
+}
+
+func balance(stub shim.ChaincodeStubInterface, account string) int {
+
+	bytes, _ := stub.GetState(account)
+	n, _ := strconv.Atoi(string(bytes))
+	return n
+}
+
+//	new_payments_test_stub - A ledger whose program config pays through the stand-in, with the customer holding cash.
+func new_payments_test_stub(t *testing.T, cash int) (*SimpleChaincode, *roleStub, *shim.MockStub) {
+
+	cc, s := new_test_stub(t, strings.Replace(test_genesis, `"issuerPartnerId":"PA0000001"`, `"issuerPartnerId":"PA0000001","paymentsChaincode":"payments"`, 1))
+
+	payments := shim.NewMockStub("payments", &payments_stand_in{})
+	_, err := payments.MockInit("init", "init", []string{"AB0000001", strconv.Itoa(cash)})
+	if err != nil { t.Fatal(err) }
+	s.MockPeerChaincode("payments", payments)
+
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+	return cc, s, payments
+}
+
+func TestMoneyPurchasesArePaidBeforePointsAreEarned(t *testing.T) {
+
+	cc, s, payments := new_payments_test_stub(t, 1500)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	if got := balance(payments, "PA0000001"); got != 1000 { t.Fatalf("partner was paid %d, want 1000", got) }
+	if got := cashback(t, cc, s, "AB0000001"); got != 100 { t.Fatalf("cashback = %d, want 100", got) }
+
+	must_fail(t, cc, s, "insufficient funds", "buy_item_by_money", "AB0000001", "", "IT0000001")
+}
+
+func TestWalletPurchasesPayTheCashPart(t *testing.T) {
+
+	cc, s, payments := new_payments_test_stub(t, 2000)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	must_invoke(t, cc, s, "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "50")
+
+	if got := balance(payments, "AB0000001"); got != 50 { t.Fatalf("customer has %d left, want 50 after paying 1000 and then 950 in cash", got) }
+	if got := cashback(t, cc, s, "AB0000001"); got != 50 { t.Fatalf("cashback = %d, want 50", got) }
+
+	must_fail(t, cc, s, "insufficient funds", "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "10")
+	if got := cashback(t, cc, s, "AB0000001"); got != 50 { t.Fatalf("cashback = %d after a refused payment, want 50", got) }
+}
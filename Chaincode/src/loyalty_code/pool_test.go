@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPoolMembersMustAcceptTheirInvitation(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"},{"customerID":"AB0000003"}]`)
+
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "create_pool", "home", "AB0000001")
+	must_invoke(t, cc, s, "set_pool_member", "home", `{"customerId":"AB0000002","contributes":true,"canSpend":true}`)
+
+	v, _ := cc.retrieve_customer(s, "AB0000002")
+	if v.PoolID != "" { t.Fatalf("an invited customer was put in pool %s before accepting", v.PoolID) }
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000002", "", "IT0000001")
+	if got := cashback(t, cc, s, "AB0000002"); got != 100 { t.Fatalf("cashback = %d, want 100 kept while the invitation is pending", got) }
+
+	s.as("AB0000003", CUSTOMER)
+	must_fail(t, cc, s, "Only customer AB0000002", "accept_pool_invite", "home", "AB0000002")
+	must_fail(t, cc, s, "has no invitation", "accept_pool_invite", "home", "AB0000003")
+
+	s.as("AB0000002", CUSTOMER)
+	must_invoke(t, cc, s, "accept_pool_invite", "home", "AB0000002")
+	must_fail(t, cc, s, "has no invitation", "accept_pool_invite", "home", "AB0000002")
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000002", "", "IT0000001")
+	p, _ := cc.retrieve_pool(s, "home")
+	if p.Balance != 100 { t.Fatalf("pool balance = %d, want 100 once the member has joined", p.Balance) }
+}
+
+func TestDecliningAnInvitationLeavesTheCustomersPoolAlone(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"},{"customerID":"AB0000003"}]`)
+
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "create_pool", "home", "AB0000001")
+	must_invoke(t, cc, s, "set_pool_member", "home", `{"customerId":"AB0000003"}`)
+
+	s.as("AB0000002", CUSTOMER)
+	must_invoke(t, cc, s, "create_pool", "away", "AB0000002")
+	must_invoke(t, cc, s, "set_pool_member", "away", `{"customerId":"AB0000003"}`)
+
+	s.as("AB0000003", CUSTOMER)
+	must_invoke(t, cc, s, "accept_pool_invite", "away", "AB0000003")
+	must_invoke(t, cc, s, "remove_pool_member", "home", "AB0000003")
+
+	v, _ := cc.retrieve_customer(s, "AB0000003")
+	if v.PoolID != "away" { t.Fatalf("declining pool home took the customer out of pool %q", v.PoolID) }
+}
+
+func TestPartnersCannotActForPoolMembers(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"},{"customerID":"AB0000002"}]`)
+
+	for _, role := range []string{AIRLINES, HOTEL, VENDOR, AUTHORITY} {
+		s.as("staff1", role, "partnerId", "PA0000002")
+		must_fail(t, cc, s, "Only customer AB0000001", "create_pool", "home", "AB0000001")
+	}
+
+	s.as("AB0000001", CUSTOMER)
+	must_invoke(t, cc, s, "create_pool", "home", "AB0000001")
+	must_invoke(t, cc, s, "set_pool_member", "home", `{"customerId":"AB0000002","canSpend":true}`)
+
+	s.as("inn1", HOTEL, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "Only customer AB0000001", "set_pool_member", "home", `{"customerId":"AB0000002","canSpend":false}`)
+	must_fail(t, cc, s, "Only customer AB0000002", "accept_pool_invite", "home", "AB0000002")
+	must_fail(t, cc, s, "Only customer AB0000001", "remove_pool_member", "home", "AB0000002")
+	must_deny_query(t, cc, s, "Only members of pool home", "get_pool", "home")
+
+	s.as("reg1", AUTHORITY)
+	must_fail(t, cc, s, "Only customer AB0000002", "accept_pool_invite", "home", "AB0000002")
+	must_query(t, cc, s, "get_pool", "home")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPlanRedemption(t *testing.T) {
+
+	config := default_program_config()
+	config.MaxRedemptionShare = 50
+	config.MinRedemption = 10
+	config.PoSPointRates = map[string]int{"PS0000001": 200}
+	p := PoS{PoSID: "PS0000001"}
+
+	cases := []struct {
+		requested	int
+		want		Redemption
+		err			string
+	}{
+		{-1, Redemption{Points: 1000, Value: 500, Cash: 500}, ""},
+		{300, Redemption{Points: 300, Value: 150, Cash: 850}, ""},
+		{301, Redemption{Points: 300, Value: 150, Cash: 850}, ""},			// Only whole units of value are bought
+		{1200, Redemption{}, "At most 50%"},
+		{8, Redemption{}, "below the minimum of 10"},
+		{0, Redemption{Cash: 1000}, ""},
+	}
+
+	for _, c := range cases {
+		r, err := plan_redemption(config, p, 1000, c.requested)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) { t.Fatalf("requested %d: expected an error containing %q, got %v", c.requested, c.err, err) }
+			continue
+		}
+		if err != nil || r != c.want { t.Fatalf("requested %d: got %+v, %v, want %+v", c.requested, r, err, c.want) }
+	}
+}
+
+func TestBurnCapsLimitDailyRedemptions(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	config, _ := cc.retrieve_program_config(s)
+	must_invoke(t, cc, s, "update_program_config", strconv.Itoa(config.Version), `{"programName":"Sky","issuerPartnerId":"PA0000001","dailyBurnCap":100}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	must_invoke(t, cc, s, "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "80")
+	must_fail(t, cc, s, "Daily redemption limit of 100 points exceeded, 20 points left today", "buy_item_by_wallet", "AB0000001", "", "IT0000001", "", "30")
+
+	if got := cashback(t, cc, s, "AB0000001"); got != 120 { t.Fatalf("cashback = %d, want 120 with the refused redemption left out", got) }
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//	must_deny_query - The query must be refused with an error containing want.
+func must_deny_query(t *testing.T, cc *SimpleChaincode, s *roleStub, want string, function string, args ...string) {
+
+	_, err := cc.Query(s, function, args)
+	if err == nil || !strings.Contains(err.Error(), want) { t.Fatalf("%s%v: expected an error containing %q, got %v", function, args, want, err) }
+}
+
+func TestStatementsAreOnlyShownToTheirPartner(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_query(t, cc, s, "get_statements", "PA0000002")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_query(t, cc, s, "get_statements", "PA0000002")
+	must_deny_query(t, cc, s, "does not act for partner PA0000001", "get_statements", "PA0000001")
+
+	s.as("air", AIRLINES, "partnerId", "PA0000001")
+	must_deny_query(t, cc, s, "does not act for partner PA0000002", "get_statements", "PA0000002")
+
+	s.as("AB0000001", CUSTOMER)
+	must_deny_query(t, cc, s, "Permission Denied", "get_statements", "PA0000002")
+}
+
+func TestOnlyThePayeeOrRegulatorSettlesAStatement(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000001")
+	s.as("till2", HOTEL, "posId", "PS0000002")
+	must_invoke(t, cc, s, "buy_item_by_wallet", "AB0000001", "", "IT0000002", "", "50")
+
+	s.as("air", AIRLINES, "partnerId", "PA0000001")
+	must_fail(t, cc, s, "Only the regulator", "close_settlement_period")
+
+	s.as("reg1", AUTHORITY)
+	var statements []Statement
+	json.Unmarshal(must_invoke(t, cc, s, "close_settlement_period"), &statements)
+	if len(statements) != 1 || statements[0].PayerID != "PA0000001" || statements[0].PayeeID != "PA0000002" { t.Fatalf("statements = %+v", statements) }
+	id := statements[0].StatementID
+
+	s.as("air", AIRLINES, "partnerId", "PA0000001")
+	must_fail(t, cc, s, "does not act for partner PA0000002", "settle_statement", id)
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_invoke(t, cc, s, "settle_statement", id)
+	must_fail(t, cc, s, "has already been settled", "settle_statement", id)
+}
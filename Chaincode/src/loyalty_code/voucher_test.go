@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVouchersAreRedeemedOnlyAtTheIssuersPoS(t *testing.T) {
+
+	cc, s := new_test_stub(t, test_genesis)
+	must_invoke(t, cc, s, "bulk_create_customers", "json", `[{"customerID":"AB0000001"}]`)
+	must_invoke(t, cc, s, "purchase_float", "PA0000002", "600", "wire-1")
+
+	s.as("inn", HOTEL, "partnerId", "PA0000002")
+	must_fail(t, cc, s, "does not act for partner PA0000001", "issue_voucher", `{"code":"AIRLINE-CODE-0001","partnerId":"PA0000001","type":"percent_discount","value":50}`)
+	must_fail(t, cc, s, "at least 16 characters", "issue_voucher", `{"code":"SHORT1","partnerId":"PA0000002","type":"percent_discount","value":50}`)
+	must_fail(t, cc, s, "cannot be above 1000", "issue_voucher", `{"code":"HOTEL-BONUS-00001","partnerId":"PA0000002","type":"points_bonus","value":1000000}`)
+	must_fail(t, cc, s, "does not belong to partner PA0000002", "issue_voucher", `{"code":"HOTEL-SEAT-000001","partnerId":"PA0000002","type":"free_item","itemId":"IT0000001"}`)
+	must_invoke(t, cc, s, "issue_voucher", `{"code":"HOTEL-HALF-000001","partnerId":"PA0000002","type":"percent_discount","value":50}`)
+
+	s.as("till1", AIRLINES, "posId", "PS0000001")
+	must_fail(t, cc, s, "not accepted at PoS PS0000001", "buy_item_by_money", "AB0000001", "", "IT0000001", "HOTEL-HALF-000001")
+
+	s.as("desk", HOTEL, "posId", "PS0000002")
+	must_invoke(t, cc, s, "buy_item_by_money", "AB0000001", "", "IT0000002", "HOTEL-HALF-000001")
+	if got := cashback(t, cc, s, "AB0000001"); got != 50 { t.Fatalf("cashback = %d, want 50 on the discounted price", got) }
+
+	_, err := cc.Query(s, "get_voucher", []string{"HOTEL-HALF-000001"})
+	if err == nil { t.Fatal("a partner that did not issue the voucher could read it") }
+}